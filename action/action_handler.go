@@ -17,13 +17,21 @@ package action
 */
 
 import (
+	"context"
 	"strings"
 
 	"github.com/kreuzwerker/arebot/config"
+	"github.com/kreuzwerker/arebot/storeresults"
 	"github.com/kreuzwerker/arebot/util"
 )
 
-func HandleAction(result config.CompliantCheckResult, isEventDrivenCheck bool) error {
+func HandleAction(ctx context.Context, result config.CompliantCheckResult, isEventDrivenCheck bool) error {
+
+	// (0) the results waived by an exemption do not trigger any action
+	if result.Waived {
+		Log.Debugf("Actions of %s on resource %s suppressed by the exemption %s.", result.Check.Name, result.ResourceId, result.Exemption)
+		return nil
+	}
 
 	// (1) fetch all the Action objects triggered by the non-compliant check result
 	actions := fetchActions(result.Check)
@@ -33,6 +41,12 @@ func HandleAction(result config.CompliantCheckResult, isEventDrivenCheck bool) e
 	for _, action := range actions {
 		action := action
 
+		// (2.0) the action may only handle the results of some severities and categories
+		if !action.Accepts(result) {
+			Log.Debugf("Action %s skipped: the %s result of %s (category '%s') is filtered out (resource %s).", action.Name, result.Severity, result.Check.Name, result.Category, result.ResourceId)
+			continue
+		}
+
 		// (2.1) verify whether a time condition is defined and, in the affirmative case, if it is satisfied
 		/* There are two temporal conditions:
 		   -   "start_after": start the action after a given amount of time.
@@ -59,7 +73,7 @@ func HandleAction(result config.CompliantCheckResult, isEventDrivenCheck bool) e
 				// TODO:    we are now sending an email for each non-compliant check result
 				// FUTURE IMPROVEMENT:  bundle multiple results issued within a given time window
 				for _, receiver := range receivers {
-					deliveryErr := util.SendEmail(receiver, result, email.Template)
+					deliveryErr := util.SendEmail(ctx, receiver, result, email.Template)
 					if deliveryErr != nil {
 						Log.Errorf("Could not send the notification email: %s.", deliveryErr.Error())
 					}
@@ -74,6 +88,11 @@ func HandleAction(result config.CompliantCheckResult, isEventDrivenCheck bool) e
 	return nil
 }
 
+// WaiveExemptedResults marks the results matched by an exemption of the configuration or of the storage as waived
+func WaiveExemptedResults(results []config.CompliantCheckResult, getResourceProperties func(string) []string) []config.CompliantCheckResult {
+	return Cfg.WaiveExemptedResults(results, getResourceProperties, storeresults.GetExemptions())
+}
+
 // Return an array with the Action objects associated with the CompliantCheckResult
 func fetchActions(cc config.CompliantCheck) []config.Action {
 	var actions []config.Action
@@ -17,17 +17,18 @@ package action
 */
 
 import (
+	"context"
 	"errors"
 	"time"
 	"strings"
+	"sync"
 
 	"github.com/robfig/cron"
 
+	"github.com/kreuzwerker/arebot/cloudwatch"
 	"github.com/kreuzwerker/arebot/config"
 	"github.com/kreuzwerker/arebot/storeresults"
-	"github.com/kreuzwerker/arebot/resource/securitygroup"
 	"github.com/kreuzwerker/arebot/util"
-	"github.com/kreuzwerker/arebot/resource/ec2"
 )
 
 /* 		ACTION_TRIGGER IMPLEMENTATION: overview
@@ -38,7 +39,20 @@ import (
 		- looking for non-compliant checks NCC that are associated with the same actions triggered by AT
 		- append such NCC objects to a slice S_NCC
 		- once scanned through all the non-compliant checks, then it runs all the NCC into S_NCC
+	- StopActionTriggers stops all the tickers and waits for the running checks to complete
 */
+var (
+	triggersMu sync.Mutex
+	// the cron schedulers started by SetActionTrigger
+	schedulers []*cron.Cron
+	// set while StopActionTriggers waits for the running checks: no further check is started
+	triggersStopping bool
+	// the running periodic checks
+	triggerRuns sync.WaitGroup
+	// context of the periodic checks, cancelled when they do not complete before the StopActionTriggers timeout
+	triggerCtx, cancelTriggers = context.WithCancel(context.Background())
+)
+
 func SetActionTrigger(cp config.CompliancePolicy) {
 	c := cron.New()
 
@@ -46,16 +60,70 @@ func SetActionTrigger(cp config.CompliancePolicy) {
 		trigger := trigger
 
 		c.AddFunc(trigger.Schedule, func() {
+			ctx, ok := startTriggerRun()
+			if !ok {
+				return
+			}
+			defer triggerRuns.Done()
+
 			checksToTrigger, err := fetchChecksToTrigger(cp.Name, trigger)
 			if err != nil {
 				Log.Errorf("Set-up periodic check failed: %s.", err)
 				return
 			}
-			handleTriggeredCompliantChecks(trigger, checksToTrigger)
+			handleTriggeredCompliantChecks(ctx, trigger, checksToTrigger)
 		})
 		Log.Debugf("Action trigger %s has been scheduled.", trigger.Name)
 	}
 	c.Start()
+
+	triggersMu.Lock()
+	schedulers = append(schedulers, c)
+	triggersMu.Unlock()
+}
+
+// StopActionTriggers stops all the action triggers and waits for the running periodic checks. The checks still
+// running after timeout are cancelled. Action triggers can be set up again once the function returned.
+func StopActionTriggers(timeout time.Duration) {
+	triggersMu.Lock()
+	triggersStopping = true
+	for _, c := range schedulers {
+		c.Stop()
+	}
+	schedulers = nil
+	cancel := cancelTriggers
+	triggersMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		triggerRuns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		Log.Warnf("Periodic checks still running after %s: cancelling them.", timeout)
+		cancel()
+		<-done
+	}
+	cancel()
+
+	triggersMu.Lock()
+	triggerCtx, cancelTriggers = context.WithCancel(context.Background())
+	triggersStopping = false
+	triggersMu.Unlock()
+	Log.Debug("Action triggers stopped.")
+}
+
+// startTriggerRun registers a running periodic check and returns its context; it returns false while stopping
+func startTriggerRun() (context.Context, bool) {
+	triggersMu.Lock()
+	defer triggersMu.Unlock()
+	if triggersStopping {
+		return nil, false
+	}
+	triggerRuns.Add(1)
+	return triggerCtx, true
 }
 
 // retrieve all the compliant checks that trigger the actions defined in the passed ActionTrigger object
@@ -152,65 +220,40 @@ func evaluateTimeConditions(policyName string, actionName string, checks *[]conf
 	return &results
 }
 
-func handleTriggeredCompliantChecks(trigger config.ActionTrigger, resultsToRun []config.CompliantCheckResult) {
+func handleTriggeredCompliantChecks(ctx context.Context, trigger config.ActionTrigger, resultsToRun []config.CompliantCheckResult) {
 	for _, rtr := range resultsToRun {
-		resourcePrefix := strings.Split(rtr.ResourceId, "-")[0]
-		var apicallCfgs []config.APICall
-		var resource util.AwsResourceType
-
-		switch resourcePrefix {
-			case "sg":
-				sg, err := securitygroup.NewSecurityGroupWithStatus(rtr.ResourceId, rtr.EventUser.AccountId)
-				if err != nil {
-					Log.Debugf("Failed re-execution of the compliant check '%s'. Cannot find the security group '%s'. Err: ", rtr.Check.Name, rtr.ResourceId, err.Error())
-					continue
-				}
-				_, apicallCfgs = Cfg.GetAPICallConfigs(rtr.EventType, rtr.EventUser.AccountId, *sg.State.VpcId, "security_group")
-				resource = &sg
-
-			case "i":
-				ei, err := ec2instance.NewEC2WithStatus(rtr.ResourceId, rtr.EventUser.AccountId)
-				if err != nil {
-					Log.Debugf("Failed re-execution of the compliant check '%s'. Cannot find the EC2 instance '%s'. Err: ", rtr.Check.Name, rtr.ResourceId, err.Error())
-					continue
-				}
-				_, apicallCfgs = Cfg.GetAPICallConfigs(rtr.EventType, rtr.EventUser.AccountId, *ei.State.VpcId, "ec2")
-				resource = &ei
-
-			case "vol":
-				v, err := ec2instance.NewVolumeWithStatus(rtr.ResourceId, rtr.EventUser.AccountId)
-				if err != nil {
-					Log.Debugf("Failed re-execution of the compliant check '%s'. Cannot find the Volume instance '%s'. Err: ", rtr.Check.Name, rtr.ResourceId, err.Error())
-					continue
-				}
-				_, apicallCfgs = Cfg.GetAPICallConfigs(rtr.EventType, rtr.EventUser.AccountId, "", "ec2")
-				resource = &v
-
-			case "snap":
-				s, err := ec2instance.NewSnapshotWithStatus(rtr.ResourceId, rtr.EventUser.AccountId)
-				if err != nil {
-					Log.Debugf("Failed re-execution of the compliant check '%s'. Cannot find the Snapshot instance '%s'. Err: ", rtr.Check.Name, rtr.ResourceId, err.Error())
-					continue
-				}
-				_, apicallCfgs = Cfg.GetAPICallConfigs(rtr.EventType, rtr.EventUser.AccountId, "", "ec2")
-				resource = &s
-
+		rt, ok := cloudwatch.GetResourceType(rtr.ResourceId)
+		if !ok {
+			Log.Debugf("Failed re-execution of the compliant check '%s'. Unsupported type of resource '%s'.", rtr.Check.Name, rtr.ResourceId)
+			continue
+		}
+		// the results stored before the multi-region support have only the region of the event
+		region := rtr.Region
+		if region == "" {
+			region = rtr.EventUser.Region
+		}
+		resource, err := rt.New(ctx, rtr.ResourceId, rtr.EventUser.AccountId, region)
+		if err != nil {
+			Log.Debugf("Failed re-execution of the compliant check '%s'. Cannot find the %s '%s'. Err: %s", rtr.Check.Name, rt.Name, rtr.ResourceId, err.Error())
+			continue
 		}
+		_, apicallCfgs := Cfg.GetAPICallConfigs(rtr.EventType, rtr.EventUser.AccountId, util.GetVpcId(resource), rt.PolicyType, rt.ConfigName)
 
-		reexecCompliantChecks(resource, apicallCfgs, rtr)
+		reexecCompliantChecks(ctx, resource, apicallCfgs, rtr)
 
 	}
 }
 
-func reexecCompliantChecks(resource util.AwsResourceType, apicallCfgs []config.APICall, check config.CompliantCheckResult) {
+func reexecCompliantChecks(ctx context.Context, resource util.AwsResourceType, apicallCfgs []config.APICall, check config.CompliantCheckResult) {
 	for _, apicallCfg := range apicallCfgs {
 		if check.EventType == apicallCfg.Name {
 			Log.Debugf("action_trigger.launchChecks: periodic check of compliance %+v", apicallCfg)
 			results := apicallCfg.CheckCompliance(resource.GetProperties, check.ResourceId, check.EventUser)
+			results = WaiveExemptedResults(results, resource.GetProperties)
 			for _, res := range results {
 				res := res
 				if !res.IsCompliant {
-					HandleAction(res, false)
+					HandleAction(ctx, res, false)
 				}
 				if len(results) > 0 {
 					storeresults.StoreResourceCheckResults(resource.GetId(), results)
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"reflect"
 	"regexp"
@@ -38,6 +39,9 @@ var (
 	Log = newLogger()
 )
 
+// APICallDetailType is the type of the events recorded by CloudTrail
+const APICallDetailType = "AWS API Call via CloudTrail"
+
 func newLogger() *logrus.Logger {
 	_log := logrus.New()
 	_log.Out = os.Stdout
@@ -46,6 +50,21 @@ func newLogger() *logrus.Logger {
 	return _log
 }
 
+// DecodeError is returned when a message cannot be decoded into an AWSEvent.
+// Retrying such a message is pointless.
+type DecodeError struct {
+	msg string
+}
+
+func (e DecodeError) Error() string {
+	return fmt.Sprintf("Cannot decode event: %s", e.msg)
+}
+
+// NewDecodeError create new DecodeError
+func NewDecodeError(msg string) DecodeError {
+	return DecodeError{msg: msg}
+}
+
 // Event represents a CloudWatch Event
 // http://docs.aws.amazon.com/AmazonCloudWatch/latest/events/EventTypes.html#api_event_type
 type Event struct {
@@ -146,6 +165,19 @@ type SnapshotRequestParameters struct {
 	SnapshotId	string `json:"snapshotId,omitempty"`
 }
 
+type InstanceRequestParameters struct {
+	InstanceId	string `json:"instanceId,omitempty"`
+}
+
+type BucketRequestParameters struct {
+	BucketName	string `json:"bucketName,omitempty"`
+}
+
+type IAMRequestParameters struct {
+	UserName	string `json:"userName,omitempty"`
+	RoleName	string `json:"roleName,omitempty"`
+}
+
 type VolumeRequestParameters struct {
 	VolumeId	string `json:"volumeId,omitempty"`
 }
@@ -155,6 +187,8 @@ type AWSEvent struct {
 	ApiDetail        APIDetail
 	ApiCall          string
 	RequestParameter interface{}
+	// ARNs of the SNS topics the event has been published to before reaching the queue
+	Topics []string
 }
 
 type AWSEventInterface interface {
@@ -195,19 +229,25 @@ func unmarshalJsonObject(jsonStr []byte, obj interface{}, otherFields map[string
 func (e *AWSEvent) handleEventType(event Event) error {
 	e.Event = event
 	switch event.DetailType {
-	case "AWS API Call via CloudTrail":
+	case APICallDetailType:
 
 		if err := json.Unmarshal(e.Event.Detail, &e.ApiDetail); err != nil {
 			return err
 		}
 		e.ApiCall = e.ApiDetail.EventName
 	default:
-		Log.Printf("Unknown event type: %s", event.DetailType)
-		e.ApiCall = "unknown"
+		// the events that are not API calls (e.g. "EC2 Instance State-change Notification") are
+		// identified by their type: their decoders are registered with the detail type as event name
+		e.ApiCall = event.DetailType
 	}
 	return nil
 }
 
+// IsAPICall returns true for the API calls recorded by CloudTrail, false for the other CloudWatch events
+func (e AWSEvent) IsAPICall() bool {
+	return e.Event.DetailType == APICallDetailType
+}
+
 // GetValueFromTemplate parse input string as a template and sets values from AWSEvent event.
 func (e *AWSEvent) GetValueFromTemplate(input string) (string, error) {
 
@@ -247,35 +287,34 @@ func (e *AWSEvent) GetValueFromTemplate(input string) (string, error) {
 
 }
 
+// DecodeEvent decodes a CloudWatch event; it returns a DecodeError if the data is malformed
 func DecodeEvent(data json.RawMessage) (AWSEvent, error) {
 	e := AWSEvent{}
 	_e := Event{}
 	var req interface{} = nil
 
 	if err := json.Unmarshal(data, &_e); err != nil {
-		return e, err
+		return e, NewDecodeError(err.Error())
 	}
-	e.handleEventType(_e)
-
-	switch e.ApiCall {
-	case "CreateTags":
-		req = &CreateTagsRequestParameters{}
-	case "DeleteTags":
-		req = &CreateTagsRequestParameters{}
-	case "CreateSecurityGroup":
-		req = &CreateSecurityGroupRequestParameters{}
-	case "AuthorizeSecurityGroupIngress", "RevokeSecurityGroupIngress", "AuthorizeSecurityGroupEgress", "RevokeSecurityGroupEgress", "DeleteSecurityGroup":
-		req = &SecurityGroupPolicyRequestParameters{}
-	case "DeleteVolume":
-		req = &VolumeRequestParameters{}
-	case "DeleteSnapshot":
-		req = &SnapshotRequestParameters{}
+	if err := e.handleEventType(_e); err != nil {
+		return e, NewDecodeError(err.Error())
 	}
 
-	if req != nil {
+	// the request parameters struct is provided by the decoder registered for the API call
+	if decoder, ok := GetEventDecoder(e.ApiCall); ok && decoder.RequestParameters != nil {
+		req = decoder.RequestParameters()
+	}
+
+	if req != nil && !e.IsAPICall() {
+		// the other events have no request parameters: their detail is decoded instead
+		if err := json.Unmarshal(e.Event.Detail, req); err != nil {
+			return e, NewDecodeError(err.Error())
+		}
+		e.RequestParameter = req
+	} else if req != nil {
 		other := map[string]json.RawMessage{}
 		if err := unmarshalJsonObject([]byte(e.ApiDetail.RequestParams), req, other); err != nil {
-			return e, err
+			return e, NewDecodeError(err.Error())
 		}
 		e.RequestParameter = req
 	} else {
@@ -286,7 +325,7 @@ func DecodeEvent(data json.RawMessage) (AWSEvent, error) {
 
 func (e APIDetail) ParseUserIdentity() (map[string]string, error) {
 
-	pattern := `(?P<arn>arn:aws:(?P<service>sts|iam)::(?P<account>[0-9]{12}):(?P<entity>role|assumed-role|user)/(?P<name>\S+))`
+	pattern := `(?P<arn>arn:[^:]+:(?P<service>sts|iam)::(?P<account>[0-9]{12}):(?P<entity>role|assumed-role|user)/(?P<name>\S+))`
 	s := e.UserIdentity.ARN
 	re := regexp.MustCompile(pattern)
 
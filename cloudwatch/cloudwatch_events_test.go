@@ -17,6 +17,7 @@ package cloudwatch
 */
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"reflect"
@@ -29,6 +30,11 @@ import (
 func TestMain(m *testing.M) {
 	//mySetupFunction()
 	Log = newLogger()
+	// registered by the securitygroup package, which is not imported here
+	RegisterEventDecoder(EventDecoder{
+		EventNames:        []string{"CreateSecurityGroup"},
+		RequestParameters: func() interface{} { return &CreateSecurityGroupRequestParameters{} },
+	})
 	retCode := m.Run()
 	//   myTeardownFunction()
 	os.Exit(retCode)
@@ -123,6 +129,12 @@ func TestDecodeEvent(t *testing.T) {
 	if err != nil || user["name"] != "arebot/session" {
 		t.Errorf("user identity arn doesn't match %s", user)
 	}
+
+	event.ApiDetail.UserIdentity.ARN = "arn:aws-cn:sts::000000000000:assumed-role/arebot/session"
+	user, err = event.ApiDetail.ParseUserIdentity()
+	if err != nil || user["account"] != "000000000000" || user["entity"] != "assumed-role" {
+		t.Errorf("user identity arn of the aws-cn partition doesn't match %s", user)
+	}
 }
 
 func TestSetTag(t *testing.T) {
@@ -144,7 +156,7 @@ func TestSetTag(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	_, apicallsConfigs := cfg.GetAPICallConfigs("CreateSecurityGroup", "", "", "security_group")
+	_, apicallsConfigs := cfg.GetAPICallConfigs("CreateSecurityGroup", "", "", "security_group", "")
 	Log.Printf("XXX: %+v %s", apicallsConfigs, reflect.TypeOf(apicallsConfigs))
 
 	for _, apicallCfg := range apicallsConfigs {
@@ -163,7 +175,7 @@ func TestSetTag(t *testing.T) {
 		if err != nil {
 			t.Error(err)
 		}
-		_, apicallsConfigs := cfg.GetAPICallConfigs("CreateSecurityGroup", "", "", "security_group")
+		_, apicallsConfigs := cfg.GetAPICallConfigs("CreateSecurityGroup", "", "", "security_group", "")
 		Log.Printf("XXX: %+v %s", apicallsConfigs, reflect.TypeOf(apicallsConfigs))
 
 		for _, apicallCfg := range apicallsConfigs {
@@ -182,6 +194,47 @@ func TestSetTag(t *testing.T) {
 	}
 }
 
+func TestRegistry(t *testing.T) {
+	RegisterResourceType(ResourceType{Name: "test resource", IdPrefix: "test"})
+	if rt, ok := GetResourceType("test-0011aabb"); !ok || rt.Name != "test resource" {
+		t.Errorf("the resource type should be found by the ID prefix: %+v", rt)
+	}
+	if _, ok := GetResourceType("unknown-0011aabb"); ok {
+		t.Error("no resource type should be found for an unknown prefix")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a decoder twice for an API call should panic")
+		}
+	}()
+	RegisterEventDecoder(EventDecoder{EventNames: []string{"CreateTags"}})
+}
+
+func TestUnwrapMessage(t *testing.T) {
+	topic := "arn:aws:sns:eu-central-1:222233334444:arebot-events"
+	snsMessage, _ := json.Marshal(SNSNotification{
+		Type:      "Notification",
+		MessageId: "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+		TopicArn:  topic,
+		Message:   testEvent,
+	})
+
+	// raw message delivery disabled
+	data, topics, err := UnwrapMessage(snsMessage)
+	if err != nil || string(data) != testEvent || !reflect.DeepEqual(topics, []string{topic}) {
+		t.Errorf("the event should be unwrapped from the SNS notification: %s %+v %v", data, topics, err)
+	}
+	// raw message delivery enabled, or event delivered by a CloudWatch Events rule
+	data, topics, err = UnwrapMessage([]byte(testEvent))
+	if err != nil || string(data) != testEvent || len(topics) != 0 {
+		t.Errorf("the event should be returned unchanged: %s %+v %v", data, topics, err)
+	}
+	if ArnAccount(topic) != "222233334444" || ArnAccount("sg-0011aabb") != "" {
+		t.Errorf("wrong account of the ARN %s", topic)
+	}
+}
+
 const settag_ARN = `
 security_group "tag" {
 	api_call "CreateSecurityGroup" {
@@ -0,0 +1,64 @@
+package cloudwatch
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// maximum number of SNS envelopes around an event (e.g. a topic subscribed to another topic)
+const maxEnvelopes = 3
+
+// SNSNotification is the message delivered by SNS to an SQS queue when the raw message delivery
+// of the subscription is disabled: the published event is the Message string
+type SNSNotification struct {
+	Type      string `json:"Type"`
+	MessageId string `json:"MessageId"`
+	TopicArn  string `json:"TopicArn"`
+	Message   string `json:"Message"`
+	Timestamp string `json:"Timestamp"`
+}
+
+// UnwrapMessage returns the CloudWatch event delivered in an SQS message body, removing the SNS
+// envelopes around it, and the ARNs of the SNS topics it has been published to (outermost first).
+// The events delivered directly by a CloudWatch Events/EventBridge rule or by an SNS subscription
+// with raw message delivery are returned unchanged.
+func UnwrapMessage(body []byte) ([]byte, []string, error) {
+	var topics []string
+	for len(topics) <= maxEnvelopes {
+		var n SNSNotification
+		if err := json.Unmarshal(body, &n); err != nil {
+			return body, topics, NewDecodeError(err.Error())
+		}
+		if n.Type != "Notification" || n.TopicArn == "" {
+			return body, topics, nil
+		}
+		topics = append(topics, n.TopicArn)
+		body = []byte(n.Message)
+	}
+	return body, topics, NewDecodeError("too many SNS envelopes around the event")
+}
+
+// ArnAccount returns the account ID of an ARN (arn:partition:service:region:account:resource)
+func ArnAccount(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 || parts[0] != "arn" {
+		return ""
+	}
+	return parts[4]
+}
@@ -0,0 +1,240 @@
+package cloudwatch
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/kreuzwerker/arebot/config"
+	"github.com/kreuzwerker/arebot/util"
+)
+
+// ResourceType describes a type of AWS resource checked by AreBOT. The resource packages
+// register their types with RegisterResourceType.
+type ResourceType struct {
+	// name of the type in the log output, e.g. "security group"
+	Name string
+	// name of the type in the resource blocks of the policies, e.g. "ec2_instance"
+	ConfigName string
+	// prefix of the resource IDs, e.g. "sg" for "sg-0011aabb"
+	IdPrefix string
+	// matches the IDs of the types without prefix instead, e.g. the ARNs of the buckets
+	IdPattern *regexp.Regexp
+	// type of the compliance policies applied to the resources ("security_group", "ec2", "s3", "iam")
+	PolicyType string
+	// New returns the resource with its current state, described in the given region of the account
+	New func(ctx context.Context, id string, accountId string, region string) (util.AwsResourceType, error)
+	// FromState returns the resource of a state in the JSON format of the AWS API, e.g. an ec2.Instance,
+	// without describing it (optional). The policy tests check such states offline.
+	FromState func(state []byte) (util.AwsResourceType, error)
+	// property keys supported by the GetProperties method of the resources; a key ending with a dot
+	// is a prefix, e.g. "Tag." for the tags. The keys of the types without properties are not checked.
+	Properties []string
+}
+
+// EventDecoder describes the events of a set of API calls. The resource packages register
+// the API calls they handle with RegisterEventDecoder.
+type EventDecoder struct {
+	// names of the API calls (the CloudTrail event names)
+	EventNames []string
+	// RequestParameters returns the struct the request parameters of the event are decoded into (optional)
+	RequestParameters func() interface{}
+	// ResourceIds returns the IDs of the resources concerned by the event, found in its request or response elements
+	ResourceIds func(e AWSEvent) ([]string, error)
+	// set for the API calls deleting the resources: their stored check results are deleted
+	Deletes bool
+	// IsDeletion returns true if the event deleted the resources, for the events that only do so depending on their detail (optional)
+	IsDeletion func(e AWSEvent) bool
+	// Handle runs before the compliance checks of the resources concerned by the event, which are not run if it fails (optional)
+	Handle func(ctx context.Context, e AWSEvent, r util.AwsResourceType) error
+}
+
+// DeletesResources returns true if the resources concerned by the event have been deleted
+func (d EventDecoder) DeletesResources(e AWSEvent) bool {
+	return d.Deletes || (d.IsDeletion != nil && d.IsDeletion(e))
+}
+
+// Tagger is implemented by the resources that can be tagged by AreBOT
+type Tagger interface {
+	Tag(ctx context.Context, key string, value string) error
+}
+
+var (
+	registryMu sync.RWMutex
+	// the types by ID prefix, or by config name for the types with an ID pattern
+	resourceTypes = map[string]ResourceType{}
+	eventDecoders = map[string]EventDecoder{}
+)
+
+// RegisterResourceType makes a resource type available to the event handler and the action triggers.
+// It panics if a type with the same ID prefix (or config name, for the types with an ID pattern) is already registered.
+func RegisterResourceType(rt ResourceType) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	key := rt.IdPrefix
+	if rt.IdPattern != nil {
+		key = rt.ConfigName
+	}
+	if _, dup := resourceTypes[key]; dup {
+		panic("cloudwatch: RegisterResourceType called twice for prefix " + key)
+	}
+	resourceTypes[key] = rt
+}
+
+// RegisterEventDecoder makes the API calls of the decoder available to DecodeEvent and the event handler.
+// It panics if one of the API calls is already registered.
+func RegisterEventDecoder(d EventDecoder) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, name := range d.EventNames {
+		if _, dup := eventDecoders[name]; dup {
+			panic("cloudwatch: RegisterEventDecoder called twice for " + name)
+		}
+		eventDecoders[name] = d
+	}
+}
+
+// GetResourceType returns the registered type of the resource with the given ID
+func GetResourceType(resourceId string) (ResourceType, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	if rt, ok := resourceTypes[strings.Split(resourceId, "-")[0]]; ok && rt.IdPattern == nil {
+		return rt, true
+	}
+	for _, rt := range resourceTypes {
+		if rt.IdPattern != nil && rt.IdPattern.MatchString(resourceId) {
+			return rt, true
+		}
+	}
+	return ResourceType{}, false
+}
+
+// GetResourceTypeByConfigName returns the registered type with the given name in the policies, e.g. "ec2_volume"
+func GetResourceTypeByConfigName(name string) (ResourceType, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, rt := range resourceTypes {
+		if rt.ConfigName == name {
+			return rt, true
+		}
+	}
+	return ResourceType{}, false
+}
+
+// GetEventDecoder returns the decoder registered for the API call
+func GetEventDecoder(apiCall string) (EventDecoder, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	d, ok := eventDecoders[apiCall]
+	return d, ok
+}
+
+// IsHandledAPICall returns true if a decoder is registered for the API call
+func IsHandledAPICall(apiCall string) bool {
+	_, ok := GetEventDecoder(apiCall)
+	return ok
+}
+
+// IsSupportedProperty returns true if the property key is supported by one of the resource types of
+// the policy type (or by the resource type with the given config name), or if none of them lists its properties
+func IsSupportedProperty(policyType string, key string) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	listed := false
+	for _, rt := range resourceTypes {
+		if (rt.PolicyType != policyType && rt.ConfigName != policyType) || len(rt.Properties) == 0 {
+			continue
+		}
+		listed = true
+		for _, p := range rt.Properties {
+			if key == p || (strings.HasSuffix(p, ".") && strings.HasPrefix(key, p) && len(key) > len(p)) {
+				return true
+			}
+		}
+	}
+	return !listed
+}
+
+// IsResourceType returns true if a resource type of the policy type is registered with the config name
+func IsResourceType(policyType string, name string) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, rt := range resourceTypes {
+		if rt.PolicyType == policyType && rt.ConfigName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ResponseElement returns a string element of the event response, or a DecodeError if it is missing
+func (e AWSEvent) ResponseElement(key string) (string, error) {
+	resp, ok := e.ApiDetail.ResponseElements.(map[string]interface{})
+	if !ok {
+		return "", NewDecodeError(fmt.Sprintf("%s event without response elements", e.ApiCall))
+	}
+	value, ok := resp[key].(string)
+	if !ok {
+		return "", NewDecodeError(fmt.Sprintf("%s event without %s response element", e.ApiCall, key))
+	}
+	return value, nil
+}
+
+// the tagging API calls are shared by all the EC2 resource types
+func init() {
+	// the policies of the configuration are checked against the registry
+	config.IsHandledAPICall = IsHandledAPICall
+	config.IsSupportedProperty = IsSupportedProperty
+	config.IsResourceType = IsResourceType
+
+	RegisterEventDecoder(EventDecoder{
+		EventNames:        []string{"CreateTags"},
+		RequestParameters: func() interface{} { return &CreateTagsRequestParameters{} },
+		ResourceIds:       taggedResourceIds,
+	})
+	RegisterEventDecoder(EventDecoder{
+		EventNames:        []string{"DeleteTags"},
+		RequestParameters: func() interface{} { return &CreateTagsRequestParameters{} },
+		ResourceIds:       taggedResourceIds,
+		Handle:            restoreDeletedTags,
+	})
+}
+
+func taggedResourceIds(e AWSEvent) ([]string, error) {
+	var ids []string
+	for _, item := range e.RequestParameter.(*CreateTagsRequestParameters).ResourcesSet.Items {
+		ids = append(ids, item["resourceId"])
+	}
+	return ids, nil
+}
+
+// restoreDeletedTags sets again the AreBOT tags deleted from the resource
+func restoreDeletedTags(ctx context.Context, e AWSEvent, r util.AwsResourceType) error {
+	for _, item := range e.RequestParameter.(*CreateTagsRequestParameters).TagSet.Items {
+		Log.Printf("%s deleted tag: %+v", r.GetId(), item)
+		if tagger, ok := r.(Tagger); ok && strings.HasPrefix(item["key"], "AreBOT.") {
+			if err := tagger.Tag(ctx, item["key"], item["value"]); err != nil {
+				return fmt.Errorf("cannot restore tag %s of %s: %s", item["key"], r.GetId(), err)
+			}
+		}
+	}
+	return nil
+}
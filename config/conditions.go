@@ -0,0 +1,185 @@
+package config
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// timeNow returns the time of the time_window conditions
+var timeNow = time.Now
+
+var weekdays = map[string]time.Weekday{"mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday, "sun": time.Sunday}
+
+var hoursPattern = regexp.MustCompile("^([01][0-9]|2[0-3]):([0-5][0-9])-([01][0-9]|2[0-4]):([0-5][0-9])$")
+
+// timeWindow is the value of a time_window condition: "[<days>] <from>-<to> [<time zone>]", e.g.
+// "Mon-Fri 08:00-18:00 Europe/Berlin". The days are a list of days and day ranges, e.g. "Mon,Wed-Fri",
+// every day by default. A window ending before it starts ends the next day, e.g. "22:00-06:00".
+type timeWindow struct {
+	days     [7]bool
+	from, to int // minutes of the day
+	location *time.Location
+}
+
+func parseTimeWindow(value string) (timeWindow, error) {
+	window := timeWindow{location: time.UTC}
+	fields := strings.Fields(value)
+	if len(fields) > 0 && !hoursPattern.MatchString(fields[0]) {
+		if err := window.parseDays(fields[0]); err != nil {
+			return window, err
+		}
+		fields = fields[1:]
+	} else {
+		window.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	if len(fields) == 0 || len(fields) > 2 {
+		return window, fmt.Errorf("the time window '%s' is not in the form '[<days>] <HH:MM>-<HH:MM> [<time zone>]'", value)
+	}
+	hours := hoursPattern.FindStringSubmatch(fields[0])
+	if hours == nil {
+		return window, fmt.Errorf("the time window '%s' has invalid hours '%s'", value, fields[0])
+	}
+	var fromHour, fromMinute, toHour, toMinute int
+	fmt.Sscan(hours[1], &fromHour)
+	fmt.Sscan(hours[2], &fromMinute)
+	fmt.Sscan(hours[3], &toHour)
+	fmt.Sscan(hours[4], &toMinute)
+	window.from, window.to = fromHour*60+fromMinute, toHour*60+toMinute
+	if len(fields) == 2 {
+		location, err := time.LoadLocation(fields[1])
+		if err != nil {
+			return window, fmt.Errorf("the time window '%s' has an invalid time zone: %s", value, err)
+		}
+		window.location = location
+	}
+	return window, nil
+}
+
+func (w *timeWindow) parseDays(spec string) error {
+	for _, days := range strings.Split(spec, ",") {
+		bounds := strings.SplitN(strings.ToLower(days), "-", 2)
+		first, ok := weekdays[bounds[0]]
+		last, okLast := first, true
+		if len(bounds) == 2 {
+			last, okLast = weekdays[bounds[1]]
+		}
+		if !ok || !okLast {
+			return fmt.Errorf("invalid days '%s' of a time window (e.g. Mon-Fri or Sat,Sun)", spec)
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			w.days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// contains returns true if the time is within the window. The days of a window ending the next day are the days it starts.
+func (w timeWindow) contains(t time.Time) bool {
+	t = t.In(w.location)
+	minute := t.Hour()*60 + t.Minute()
+	if w.from <= w.to {
+		return w.days[t.Weekday()] && minute >= w.from && minute < w.to
+	}
+	if minute >= w.from {
+		return w.days[t.Weekday()]
+	}
+	return minute < w.to && w.days[(t.Weekday()+6)%7]
+}
+
+// anyMatch returns true if one of the values matches the pattern
+func anyMatch(re *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if value != "" && re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionType returns the type of the condition, the logical types in upper case whatever their case in the
+// configuration ("and", "Not", ...)
+func conditionType(condition Condition) string {
+	if upper := strings.ToUpper(condition.Type); ContainsString(LogicalConditionsTypes, upper) {
+		return upper
+	}
+	return condition.Type
+}
+
+// validateCondition validates the settings of the condition types that are not about tags
+func validateCondition(condition Condition) error {
+	switch condition.Type {
+	case "property_matches":
+		if condition.Property == "" {
+			return fmt.Errorf("Condition: %s has no property to match.", condition.Name)
+		}
+		fallthrough
+	case "event_user_matches":
+		if _, err := regexp.Compile(condition.Value); err != nil {
+			return fmt.Errorf("Condition: %s has an invalid pattern: %s.", condition.Name, err)
+		}
+	case "region_in", "account_in":
+		if len(condition.Values) == 0 {
+			return fmt.Errorf("Condition: %s has no values.", condition.Name)
+		}
+	case "time_window":
+		if _, err := parseTimeWindow(condition.Value); err != nil {
+			return fmt.Errorf("Condition: %s: %s.", condition.Name, err)
+		}
+	}
+	return nil
+}
+
+// integrateConditions replaces the names of the accounts of the account_in conditions with their IDs
+func integrateConditions(config *Config) {
+	var integrate func(conditions []Condition)
+	integrate = func(conditions []Condition) {
+		for i := range conditions {
+			condition := &conditions[i]
+			if condition.Type == "account_in" {
+				// the rules may share the values with other checks
+				values := make([]string, len(condition.Values))
+				for j, value := range condition.Values {
+					values[j] = value
+					for _, account := range config.Account {
+						if account.Name == value {
+							values[j] = account.AccountID
+						}
+					}
+				}
+				condition.Values = values
+			}
+			integrate(condition.Condition)
+		}
+	}
+	for _, policies := range [][]CompliancePolicy{config.SecurityGroupPolicy, config.EC2Policy, config.S3Policy, config.IAMPolicy} {
+		for _, policy := range policies {
+			for _, apicall := range policy.checkBlocks() {
+				for _, check := range apicall.Compliant {
+					integrate(check.Condition)
+				}
+			}
+		}
+	}
+}
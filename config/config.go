@@ -26,6 +26,8 @@ import (
 
 	"errors"
 	"github.com/Sirupsen/logrus"
+	"github.com/hashicorp/go-multierror"
+	"github.com/robfig/cron"
 )
 
 var Log = newLogger()
@@ -41,9 +43,10 @@ func newLogger() *logrus.Logger {
 // ***************************************************************************************************************************************
 // ***	Config METHODS
 
-// GetAPICallConfigs returns all APICall configuration objects for a given apiCall
-func (cfg Config) GetAPICallConfigs(apiCall string, accountID string, vpc string, policyType string) ([]CompliancePolicy, []APICall) {
-	Log.Debugf("Getting config for API call: %s accountID: %s, vpc: %s", apiCall, accountID, vpc)
+// GetAPICallConfigs returns all APICall configuration objects for a given apiCall, and the resource blocks
+// of the given type of resource (e.g. "ec2_instance"), which apply to all the API calls
+func (cfg Config) GetAPICallConfigs(apiCall string, accountID string, vpc string, policyType string, resourceType string) ([]CompliancePolicy, []APICall) {
+	Log.Debugf("Getting config for API call: %s resource type: %s accountID: %s, vpc: %s", apiCall, resourceType, accountID, vpc)
 	ac := []APICall{}
 	cp := []CompliancePolicy{}
 
@@ -55,6 +58,8 @@ func (cfg Config) GetAPICallConfigs(apiCall string, accountID string, vpc string
 		compliancePolicies = cfg.EC2Policy
 	case "s3":
 		compliancePolicies = cfg.S3Policy
+	case "iam":
+		compliancePolicies = cfg.IAMPolicy
 	}
 
 	// XXX: add back reference as part of the result - maybe two slices?
@@ -77,6 +82,12 @@ func (cfg Config) GetAPICallConfigs(apiCall string, accountID string, vpc string
 				cp = append(cp, cpCfg)
 			}
 		}
+		for _, resourceObj := range cpCfg.Resource {
+			if resourceType != "" && resourceObj.Name == resourceType {
+				ac = append(ac, resourceObj)
+				cp = append(cp, cpCfg)
+			}
+		}
 	}
 	return cp, ac
 }
@@ -122,6 +133,16 @@ func (cfg Config) GetS3Policy(id string) *CompliancePolicy {
 	return nil
 }
 
+func (cfg Config) GetIAMPolicy(id string) *CompliancePolicy {
+
+	for _, iam := range cfg.IAMPolicy {
+		if iam.Name == id {
+			return &iam
+		}
+	}
+	return nil
+}
+
 func (cfg Config) GetCompliancePolicy(id string) *CompliancePolicy {
 	if sg := cfg.GetSecurityGroupPolicy(id); sg != nil {
 		return sg
@@ -132,6 +153,9 @@ func (cfg Config) GetCompliancePolicy(id string) *CompliancePolicy {
 	if s3 := cfg.GetS3Policy(id); s3 != nil {
 		return s3
 	}
+	if iam := cfg.GetIAMPolicy(id); iam != nil {
+		return iam
+	}
 	return nil
 }
 
@@ -199,10 +223,69 @@ func (cfg Config) GetAccountRoleArnSession(id string) string {
 	return session
 }
 
+// GetAccountWorkerPool returns the number of workers and receivers of the account queue:
+// the account settings if defined, the worker_config ones otherwise.
+func (cfg Config) GetAccountWorkerPool(id string) (int, int) {
+	workers, receivers := cfg.WorkerConfig.Workers, cfg.WorkerConfig.Receivers
+	if account := cfg.GetAccount(id); account != nil {
+		if account.Workers > 0 {
+			workers = account.Workers
+		}
+		if account.Receivers > 0 {
+			receivers = account.Receivers
+		}
+	}
+	return workers, receivers
+}
+
+// GetAccountRegions returns the regions whose resources are checked on behalf of the account: the
+// regions setting if defined, the region of the account otherwise.
+func (cfg Config) GetAccountRegions(id string) []string {
+	account := cfg.GetAccount(id)
+	if account == nil {
+		return nil
+	}
+	if len(account.Regions) > 0 {
+		return account.Regions
+	}
+	return []string{account.Region}
+}
+
+// IsAccountRegion returns true if the events of the region are handled for the account. All the
+// regions are handled if the account does not define its regions.
+func (cfg Config) IsAccountRegion(id string, region string) bool {
+	account := cfg.GetAccount(id)
+	if account == nil {
+		return false
+	}
+	return len(account.Regions) == 0 || region == "" || ContainsString(account.Regions, region)
+}
+
 func (cfg Config) ShouldStoreOnDynamoDB() bool {
 	return cfg.DynamoDBConfig.ArebotRoleArn != "" && cfg.DynamoDBConfig.Region != ""
 }
 
+// ***************************************************************************************************************************************
+// CompliancePolicy METHODS
+
+// checkBlock is an api_call or a resource block of a policy
+type checkBlock struct {
+	kind string // "api_call" or "resource"
+	APICall
+}
+
+// checkBlocks returns the api_call blocks and then the resource blocks of the policy
+func (cp CompliancePolicy) checkBlocks() []checkBlock {
+	var blocks []checkBlock
+	for _, apicall := range cp.APICall {
+		blocks = append(blocks, checkBlock{"api_call", apicall})
+	}
+	for _, resource := range cp.Resource {
+		blocks = append(blocks, checkBlock{"resource", resource})
+	}
+	return blocks
+}
+
 // ***************************************************************************************************************************************
 // APICall METHODS
 
@@ -262,7 +345,7 @@ func (ac APICall) CheckCompliance(getResourceProperties func(string) []string, r
 
 		// VERIFY CONDITIONS
 		for _, cond := range c.Condition {
-			if !satisfyTestCondition(cond, getResourceProperties, c.Name) {
+			if !satisfyTestCondition(cond, getResourceProperties, c.Name, eventuser) {
 				continue DoCompliantChecks
 			}
 		}
@@ -274,12 +357,29 @@ func (ac APICall) CheckCompliance(getResourceProperties func(string) []string, r
 
 		Log.Debugf("config.CheckCompliance: %s: %+v mandatory=%+v", c.Name, result, c.Mandatory)
 
+		// an expression is checked once per resource, over all the properties it reads
+		if c.Expression != "" {
+			match, err := c.IsCompliantExpression(getResourceProperties)
+			if err != nil {
+				Log.Errorf("config.CheckCompliance: %s: expression `%s`: %s", c.Name, c.Expression, err)
+				continue
+			}
+			value := "missing"
+			if len(result) > 0 {
+				value = strings.Join(result, ",")
+			}
+			compliantCheckResults = append(compliantCheckResults, CompliantCheckResult{
+				EventType: ac.Name, EventUser: eventuser, ResourceId: resourceId, Region: eventuser.Region,
+				IsCompliant: match, Check: c, Value: value, CreationDate: time.Now()})
+			continue
+		}
+
 		// handle compliance that checks for missing resource properties
 		// e.g. missing tags or missing resource description
 		if c.Mandatory == true && len(result) == 0 {
 			Log.Debugf("config.CheckCompliance: mandatory property `%+v` is missing ", c.Name)
 			compliantCheckResults = append(compliantCheckResults, CompliantCheckResult{
-				EventType: ac.Name, EventUser: eventuser, ResourceId: resourceId,
+				EventType: ac.Name, EventUser: eventuser, ResourceId: resourceId, Region: eventuser.Region,
 				IsCompliant: false, Check: c, Value: "missing", CreationDate: time.Now()})
 
 		}
@@ -300,14 +400,18 @@ func (ac APICall) CheckCompliance(getResourceProperties func(string) []string, r
 						sbs = reUg.FindAllStringSubmatch(r, -1)
 					}
 
-					switch checkName[1] {
-						case "FromPort":
+					switch {
+						case c.PermissionChecks.isSet():
+							// the whole rule is checked
+							match, err = c.IsCompliantPermission(r)
+
+						case checkName[1] == "FromPort":
 							match, err = c.IsCompliant(c.Name, sbs[0][2])
 
-						case "ToPort":
+						case checkName[1] == "ToPort":
 							match, err = c.IsCompliant(c.Name, sbs[0][3])
 
-						case "IpRanges", "UserIdGroupPairs":
+						case checkName[1] == "IpRanges", checkName[1] == "UserIdGroupPairs":
 							match, err = c.IsCompliant(c.Name, sbs[0][4])
 					}
 
@@ -317,17 +421,22 @@ func (ac APICall) CheckCompliance(getResourceProperties func(string) []string, r
 			// if the result is not compliant
 			if err == nil && !match {
 				compliantCheckResults = append(compliantCheckResults, CompliantCheckResult{
-					EventType: ac.Name, EventUser: eventuser, ResourceId: resourceId,
+					EventType: ac.Name, EventUser: eventuser, ResourceId: resourceId, Region: eventuser.Region,
 					IsCompliant: false, Check: c, Value: r, CreationDate: time.Now()})
 			} else {
 				// if the result is compliant
 				compliantCheckResults = append(compliantCheckResults, CompliantCheckResult{
-					EventType: ac.Name, EventUser: eventuser, ResourceId: resourceId,
+					EventType: ac.Name, EventUser: eventuser, ResourceId: resourceId, Region: eventuser.Region,
 					IsCompliant: true, Check: c, Value: r, CreationDate: time.Now()})
 			}
 		}
 	}
 
+	for i := range compliantCheckResults {
+		check := compliantCheckResults[i].Check
+		compliantCheckResults[i].Severity, compliantCheckResults[i].Category, compliantCheckResults[i].Controls = check.Severity, check.Category, check.Controls
+	}
+
 	// return the list of CompliantCheckResult objects that have been created (without repetitions)
 	return mergeComplianceResults(compliantCheckResults)
 }
@@ -342,12 +451,12 @@ func (c CompliantCheck) IsCompliant(prop string, value string) (bool, error) {
 	if c.Name == prop {
 
 		// security group has a tag with a configuerd action
-		if len(c.Schema) > 0 {
-			// schema check
-			re := regexp.MustCompile(c.Schema)
+		if len(c.Schema) > 0 || c.Operators.isSet() {
+			// schema check and typed operators
+			match := (len(c.Schema) == 0 || c.matchSchema(c.Schema, value)) && c.satisfiedBy(value)
 			// negate pattern in schema
 			if c.Negate {
-				if re.MatchString(value) {
+				if match {
 					Log.Debugf("Compliance check for %s - %s is not compliant (negate = true)", prop, value)
 					return false, nil
 				}
@@ -355,7 +464,7 @@ func (c CompliantCheck) IsCompliant(prop string, value string) (bool, error) {
 				return true, nil
 
 			} else {
-				if re.MatchString(value) {
+				if match {
 					Log.Debugf("Compliance check for %s - %s is compliant", prop, value)
 					return true, nil
 				}
@@ -367,6 +476,27 @@ func (c CompliantCheck) IsCompliant(prop string, value string) (bool, error) {
 	return false, fmt.Errorf("Property doesn't match complancy check: %s vs. %s", prop, c.Name)
 }
 
+// ***************************************************************************************************************************************
+// ***	Action METHODS
+
+// Accepts returns true if the result passes the severity and category filters of the action
+func (a Action) Accepts(result CompliantCheckResult) bool {
+	if a.MinSeverity != "" && severityRank(result.Severity) < severityRank(a.MinSeverity) {
+		return false
+	}
+	return len(a.Categories) == 0 || ContainsString(a.Categories, result.Category)
+}
+
+// hasTimeCondition returns true if the action has a time condition of the given type (start_after/stop_after)
+func (a Action) hasTimeCondition(conditionType string) bool {
+	for _, tc := range a.Condition {
+		if tc.Type == conditionType {
+			return true
+		}
+	}
+	return false
+}
+
 // ***************************************************************************************************************************************
 // ***	CompliantCheckResult METHODS
 
@@ -402,6 +532,17 @@ func (ccres CompliantCheckResult) IsSameCheckResult(otherOne CompliantCheckResul
 	return false
 }
 
+// Status returns "compliant", "waived" or "non-compliant"
+func (ccres CompliantCheckResult) Status() string {
+	switch {
+	case ccres.IsCompliant:
+		return "compliant"
+	case ccres.Waived:
+		return "waived"
+	}
+	return "non-compliant"
+}
+
 /*	Return true if the compliant check associated with this result is related to an IpPermissions-type event; return false otherwise.
  */
 func (ccres CompliantCheckResult) IsIpPermissionsCheck() bool {
@@ -414,73 +555,195 @@ func (ccres CompliantCheckResult) IsIpPermissionsCheck() bool {
 // ***************************************************************************************************************************************
 // validation functions
 
-func validateConfigSettings(config *Config) error {
-	var err error
-	if err = validateCompliancePolicies(config.SecurityGroupPolicy, "security_group_policy"); err != nil {
-		return err
-	}
-	if err = validateCompliancePolicies(config.EC2Policy, "ec2_policy"); err != nil {
-		return err
-	}
-	if err = validateCompliancePolicies(config.S3Policy, "s3_policy"); err != nil {
-		return err
-	}
+func validateConfigSettings(config *Config, pos positions) error {
+	var errs *multierror.Error
+	errs = multierror.Append(errs, validateAccounts(config.Account, pos))
+	errs = multierror.Append(errs, validateCompliancePolicies(config.SecurityGroupPolicy, "security_group_policy", pos))
+	errs = multierror.Append(errs, validateCompliancePolicies(config.EC2Policy, "ec2_policy", pos))
+	errs = multierror.Append(errs, validateCompliancePolicies(config.S3Policy, "s3_policy", pos))
+	errs = multierror.Append(errs, validateCompliancePolicies(config.IAMPolicy, "iam_policy", pos))
+	errs = multierror.Append(errs, validateSharedActions(config, pos))
+	errs = multierror.Append(errs, validateExemptions(config.Exemption, pos))
+
+	return errs.ErrorOrNil()
+}
 
-	return nil
+func validateAccounts(accounts []Account, pos positions) error {
+	var errs *multierror.Error
+	names := map[string]string{}
+	for _, account := range accounts {
+		if other, dup := names[account.AccountID]; dup && account.AccountID != "" {
+			errs = multierror.Append(errs, pos.errorf([]string{"account", account.Name, "account_id"}, "Account: %s has the same account_id %s as the account %s.",
+				account.Name, account.AccountID, other))
+		}
+		names[account.AccountID] = account.Name
+		for _, queue := range account.RegionQueue {
+			path := []string{"account", account.Name, "region_queue", queue.Region}
+			if len(account.Regions) > 0 && !ContainsString(account.Regions, queue.Region) {
+				errs = multierror.Append(errs, pos.errorf(path, "Account: %s defines a queue for the region %s, which is not one of its regions %s.",
+					account.Name, queue.Region, account.Regions))
+			}
+			if queue.AllEventsQueue == "" {
+				errs = multierror.Append(errs, pos.errorf(path, "Account: %s defines no all_events_queue for the region %s.", account.Name, queue.Region))
+			}
+		}
+	}
+	return errs.ErrorOrNil()
 }
 
-func validateCompliancePolicies(cPolicies []CompliancePolicy, policyType string) error {
+func validateCompliancePolicies(cPolicies []CompliancePolicy, policyType string, pos positions) error {
+	var errs *multierror.Error
 	for _, cp := range cPolicies {
-		for _, ac := range cp.APICall {
-			for _, cc := range ac.Compliant {
-				if cc.PolicyName != cp.Name {
-					err := errors.New(fmt.Sprintf("CompliantCheck: %s refers to the wrong %s name (it is '%s', should be '%s').",
-						cc.Name, policyType, cc.PolicyName, cp.Name))
-					Log.Error(err.Error())
-					return err
-				}
-			}
+		cp := cp
+		path := func(keys ...string) []string {
+			return append([]string{policyType, cp.Name}, keys...)
 		}
+
+		if _, err := regexp.Compile(cp.VpcID); err != nil {
+			errs = multierror.Append(errs, pos.errorf(path("vpc"), "%s: %s has an invalid vpc pattern: %s.", policyType, cp.Name, err))
+		}
+
 		var availableActions []string
 		for _, action := range cp.Action {
 			availableActions = append(availableActions, action.Name)
+			// the shared actions are validated once, by validateSharedActions
+			if action.Shared {
+				continue
+			}
 			if err := validateAction(action); err != nil {
-				return err
+				errs = multierror.Append(errs, pos.wrap(path("action", action.Name), err))
 			}
 		}
-		for _, trigger := range cp.ActionTrigger {
-			for _, triggerAction := range trigger.Action {
-				if !ContainsString(availableActions, triggerAction) {
-					err := errors.New(fmt.Sprintf("Action: %s cannot be used as trigger action, because it is not defined in configuration file. Available actions: %s.",
-						triggerAction, availableActions))
-					Log.Error(err.Error())
-					return err
+
+		// the actions taken by the compliance checks and by the triggers
+		var checkActions, triggerActions []string
+		for _, apic := range cp.checkBlocks() {
+			if apic.kind == "resource" {
+				if IsResourceType != nil && !IsResourceType(strings.TrimSuffix(policyType, "_policy"), apic.Name) {
+					errs = multierror.Append(errs, pos.errorf(path("resource", apic.Name), "Resource: %s is not a type of resource checked by a %s.", apic.Name, policyType))
 				}
+			} else if IsHandledAPICall != nil && !IsHandledAPICall(apic.Name) {
+				errs = multierror.Append(errs, pos.errorf(path("api_call", apic.Name), "APICall: %s is not handled by AreBOT.", apic.Name))
+			}
+			// the properties of a resource block are the ones of its type of resource
+			propertyScope := strings.TrimSuffix(policyType, "_policy")
+			if apic.kind == "resource" {
+				propertyScope = apic.Name
 			}
-		}
-		for _, apic := range cp.APICall {
 			for _, comp := range apic.Compliant {
+				compPath := func(keys ...string) []string {
+					return append(path(apic.kind, apic.Name, "compliant", comp.Name), keys...)
+				}
+				if comp.PolicyName != cp.Name {
+					errs = multierror.Append(errs, pos.errorf(compPath("policy_name"), "CompliantCheck: %s refers to the wrong %s name (it is '%s', should be '%s').",
+						comp.Name, policyType, comp.PolicyName, cp.Name))
+				}
+				if _, err := regexp.Compile(comp.Schema); err != nil {
+					errs = multierror.Append(errs, pos.errorf(compPath("schema"), "CompliantCheck: %s has an invalid schema: %s.", comp.Name, err))
+				}
+				if invalid := comp.Operators.validate(); invalid != "" {
+					errs = multierror.Append(errs, pos.errorf(compPath(), "CompliantCheck: %s: %s.", comp.Name, invalid))
+				}
+				if comp.PermissionChecks.isSet() {
+					if invalid := comp.PermissionChecks.validate(); invalid != "" {
+						errs = multierror.Append(errs, pos.errorf(compPath(), "CompliantCheck: %s: %s.", comp.Name, invalid))
+					}
+					if !strings.HasPrefix(comp.Name, "IpPermissions.") {
+						errs = multierror.Append(errs, pos.errorf(compPath(), "CompliantCheck: %s: the permission checks only apply to the IpPermissions properties.", comp.Name))
+					} else if comp.Schema != "" || comp.Operators.isSet() {
+						errs = multierror.Append(errs, pos.errorf(compPath(), "CompliantCheck: %s: the permission checks cannot be combined with a schema or operators.", comp.Name))
+					}
+				}
+				if IsSupportedProperty != nil && !IsSupportedProperty(propertyScope, comp.Name) {
+					errs = multierror.Append(errs, pos.errorf(compPath(), "CompliantCheck: %s is not a property of the resources checked by a %s.", comp.Name, policyType))
+				}
+				if !ContainsString(SeverityLevels, comp.Severity) {
+					errs = multierror.Append(errs, pos.errorf(compPath("severity"), "CompliantCheck: %s has an invalid severity '%s'. Valid severities: %s.", comp.Name, comp.Severity, SeverityLevels))
+				}
+				if comp.Expression != "" {
+					if err := validateExpression(comp, propertyScope, policyType); err != nil {
+						errs = multierror.Append(errs, pos.wrap(compPath("expression"), err))
+					}
+				}
 				for _, action := range comp.Actions {
+					checkActions = append(checkActions, action)
 					if !ContainsString(availableActions, action) {
-						err := errors.New(fmt.Sprintf("Action: %s cannot be used as compliance check action, because it is not defined in config. Available actions: %s.",
+						errs = multierror.Append(errs, pos.errorf(compPath("actions"), "Action: %s cannot be used as compliance check action, because it is not defined in config. Available actions: %s.",
 							action, availableActions))
-						Log.Error(err.Error())
-						return err
 					}
 				}
 				if err := validateConditions(comp.Condition); err != nil {
-					return err
+					errs = multierror.Append(errs, pos.wrap(compPath("condition"), err))
 				}
 			}
 		}
+
+		for _, trigger := range cp.ActionTrigger {
+			if _, err := cron.Parse(trigger.Schedule); err != nil {
+				errs = multierror.Append(errs, pos.errorf(path("action_trigger", trigger.Name, "schedule"), "ActionTrigger: %s has an invalid schedule '%s': %s.",
+					trigger.Name, trigger.Schedule, err))
+			}
+			for _, triggerAction := range trigger.Action {
+				triggerActions = append(triggerActions, triggerAction)
+				if !ContainsString(availableActions, triggerAction) {
+					errs = multierror.Append(errs, pos.errorf(path("action_trigger", trigger.Name, "action"), "Action: %s cannot be used as trigger action, because it is not defined in configuration file. Available actions: %s.",
+						triggerAction, availableActions))
+				}
+			}
+		}
+
+		for _, action := range cp.Action {
+			if !ContainsString(checkActions, action.Name) && !ContainsString(triggerActions, action.Name) {
+				errs = multierror.Append(errs, pos.errorf(path("action", action.Name), "Action: %s is not used by any compliance check or action trigger.", action.Name))
+			} else if action.hasTimeCondition("start_after") && !ContainsString(triggerActions, action.Name) {
+				// the event-driven actions with a start_after condition are skipped, only the triggers take them
+				errs = multierror.Append(errs, pos.errorf(path("action", action.Name), "Action: %s has a 'start_after' condition but is not used by any action trigger.", action.Name))
+			}
+		}
 	}
 
-	return nil
+	return errs.ErrorOrNil()
+}
+
+// validateExemptions validates the exemptions of the configuration, the expired ones are only reported
+func validateExemptions(exemptions []Exemption, pos positions) error {
+	var errs *multierror.Error
+	for _, e := range exemptions {
+		if err := e.Validate(); err != nil {
+			errs = multierror.Append(errs, pos.wrap([]string{"exemption", e.Name}, err))
+		} else if !e.IsActive(time.Now()) {
+			Log.Warnf("Exemption: %s has expired on %s.", e.Name, e.Expires)
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// validateSharedActions validates the top-level actions, which must be used by one of the policies
+func validateSharedActions(config *Config, pos positions) error {
+	var errs *multierror.Error
+	for _, action := range config.Action {
+		path := []string{"action", action.Name}
+		if err := validateAction(action); err != nil {
+			errs = multierror.Append(errs, pos.wrap(path, err))
+		}
+
+		used := false
+		for _, policies := range [][]CompliancePolicy{config.SecurityGroupPolicy, config.EC2Policy, config.S3Policy, config.IAMPolicy} {
+			for _, cp := range policies {
+				if a := cp.getAction(action.Name); a != nil && a.Shared {
+					used = true
+				}
+			}
+		}
+		if !used {
+			errs = multierror.Append(errs, pos.errorf(path, "Action: %s is not used by any policy.", action.Name))
+		}
+	}
+	return errs.ErrorOrNil()
 }
 
 func validateAction(action Action) error {
 	var emailRexp = regexp.MustCompile("^[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\\.[A-Za-z]{2,64}$")
-	var re, _ = regexp.Compile("^([1-9][0-9]*) (second|minute|hour|day)s?$")
 
 	for _, rec := range action.Email.Receiver {
 		if strings.HasPrefix(rec, "{{ ") && strings.HasSuffix(rec, " }}") {
@@ -501,6 +764,12 @@ func validateAction(action Action) error {
 		}
 	}
 
+	if action.MinSeverity != "" && !ContainsString(SeverityLevels, action.MinSeverity) {
+		err := fmt.Errorf("Action: %s has an invalid min_severity '%s'. Valid severities: %s.", action.Name, action.MinSeverity, SeverityLevels)
+		Log.Error(err.Error())
+		return err
+	}
+
 	hasStartAfterCondition := false
 	hasStopAfterCondition := false
 	for i := range action.Condition {
@@ -526,7 +795,7 @@ func validateAction(action Action) error {
 			Log.Error(err.Error())
 			return err
 		}
-		if !re.MatchString(tc.Value) {
+		if _, ok := parseDuration(tc.Value); !ok {
 			err := errors.New(fmt.Sprintf("Wrong time condition '%s' for action '%s'.", tc.Value, action.Name))
 			Log.Error(err.Error())
 			return err
@@ -546,12 +815,26 @@ func validateConditions(conditions []Condition) error {
 			}
 		}
 
-		if ContainsString(LogicalConditionsTypes, condition.Type) && len(condition.Condition) < 2 {
+		logical := ContainsString(LogicalConditionsTypes, conditionType(condition))
+		if !logical && !ContainsString(ConditionTypes, condition.Type) {
+			err := fmt.Errorf("Condition: %s has an unknown type \"%s\". Valid types: %s, %s.", condition.Name, condition.Type, ConditionTypes, LogicalConditionsTypes)
+			Log.Error(err.Error())
+			return err
+		}
+		if conditionType(condition) == "NOT" && len(condition.Condition) != 1 {
+			err := fmt.Errorf("Condition: %s is a NOT condition but does not contain exactly one underlaying condition.", condition.Name)
+			Log.Error(err.Error())
+			return err
+		} else if logical && conditionType(condition) != "NOT" && len(condition.Condition) < 2 {
 			err := errors.New(fmt.Sprintf("Condition: %s is a logical condition but does not contain at least two underlaying conditions.",
 				condition.Name))
 			Log.Error(err.Error())
 			return err
 		}
+		if err := validateCondition(condition); err != nil {
+			Log.Error(err.Error())
+			return err
+		}
 		if len(condition.Condition) > 0 {
 			if err := validateConditions(condition.Condition); err != nil {
 				Log.Error(err.Error())
@@ -565,9 +848,18 @@ func validateConditions(conditions []Condition) error {
 // ***************************************************************************************************************************************
 // init functions
 
-func integrateConfigSettings(config *Config) error {
+func integrateConfigSettings(config *Config, pos positions) error {
+
+	integrateWorkerConfig(&config.WorkerConfig)
 
 	var err error
+	if err = integrateSecrets(config, pos); err != nil {
+		return err
+	}
+	if err = integrateDefinitions(config, pos); err != nil {
+		return err
+	}
+	integrateConditions(config)
 	if err = integrateCompliancePolicies(&config.SecurityGroupPolicy); err != nil {
 		return err
 	}
@@ -577,19 +869,54 @@ func integrateConfigSettings(config *Config) error {
 	if err = integrateCompliancePolicies(&config.S3Policy); err != nil {
 		return err
 	}
+	if err = integrateCompliancePolicies(&config.IAMPolicy); err != nil {
+		return err
+	}
 
 	return nil
 }
 
+// set the default values of the worker settings that are not configured
+func integrateWorkerConfig(wc *WorkerConfig) {
+	if wc.Workers <= 0 {
+		wc.Workers = 10
+	}
+	if wc.Receivers <= 0 {
+		wc.Receivers = 1
+	}
+	if wc.MaxDescribeCalls <= 0 {
+		wc.MaxDescribeCalls = 20
+	}
+	if wc.ShutdownTimeoutSeconds <= 0 {
+		wc.ShutdownTimeoutSeconds = 30
+	}
+	if wc.DedupWindowSeconds <= 0 {
+		wc.DedupWindowSeconds = 86400
+	}
+	if wc.MaxAttempts <= 0 {
+		wc.MaxAttempts = 5
+	}
+	if wc.BackoffSeconds <= 0 {
+		wc.BackoffSeconds = 30
+	}
+	if wc.MaxBackoffSeconds <= 0 || wc.MaxBackoffSeconds > 43200 {
+		wc.MaxBackoffSeconds = 43200
+	}
+}
+
 func integrateCompliancePolicies(cPolicies *[]CompliancePolicy) error {
-	re, _ := regexp.Compile("^([1-9][0-9]*) (second|minute|hour|day)s?$")
 
 	for _, policy := range *cPolicies {
 
-		for j, _ := range policy.APICall {
-			apicall := policy.APICall[j]
+		for _, apicall := range policy.checkBlocks() {
 			for k, _ := range apicall.Compliant {
-				apicall.Compliant[k].PolicyName = policy.Name
+				// a different policy_name is reported by the validation
+				if apicall.Compliant[k].PolicyName == "" {
+					apicall.Compliant[k].PolicyName = policy.Name
+				}
+				if apicall.Compliant[k].Severity == "" {
+					apicall.Compliant[k].Severity = "medium"
+				}
 			}
 		}
 
@@ -600,18 +927,12 @@ func integrateCompliancePolicies(cPolicies *[]CompliancePolicy) error {
 				tc := &action.Condition[k]
 
 				// set the Duration field of the time condition
-				subs := re.FindAllStringSubmatch(tc.Value, -1)
-				durationVal, _ := strconv.ParseInt(subs[0][1], 10, 64)
-				switch subs[0][2] {
-				case "day":
-					tc.ValueDuration = time.Duration(durationVal) * time.Hour * 24
-				case "hour":
-					tc.ValueDuration = time.Duration(durationVal) * time.Hour
-				case "minute":
-					tc.ValueDuration = time.Duration(durationVal) * time.Minute
-				case "second":
-					tc.ValueDuration = time.Duration(durationVal) * time.Second
+				duration, ok := parseDuration(tc.Value)
+				if !ok {
+					// reported by the validation
+					continue
 				}
+				tc.ValueDuration = duration
 				Log.Debugf("Duration value %v.", tc)
 			}
 		}
@@ -623,9 +944,30 @@ func integrateCompliancePolicies(cPolicies *[]CompliancePolicy) error {
 // ***************************************************************************************************************************************
 // support functions
 
-func satisfyTestCondition(condition Condition, getResourceProperties func(string) []string, checkName string) bool {
+var durationPattern = regexp.MustCompile("^([1-9][0-9]*) (second|minute|hour|day)s?$")
+
+// parseDuration parses the durations of the settings, e.g. "10 days"
+func parseDuration(value string) (time.Duration, bool) {
+	subs := durationPattern.FindStringSubmatch(value)
+	if subs == nil {
+		return 0, false
+	}
+	durationVal, _ := strconv.ParseInt(subs[1], 10, 64)
+	switch subs[2] {
+	case "day":
+		return time.Duration(durationVal) * time.Hour * 24, true
+	case "hour":
+		return time.Duration(durationVal) * time.Hour, true
+	case "minute":
+		return time.Duration(durationVal) * time.Minute, true
+	default:
+		return time.Duration(durationVal) * time.Second, true
+	}
+}
+
+func satisfyTestCondition(condition Condition, getResourceProperties func(string) []string, checkName string, eventuser EventUserInfo) bool {
 
-	switch condition.Type {
+	switch conditionType(condition) {
 	case "tag_key_exists":
 		if len(getResourceProperties("Tag."+condition.Value)) == 0 {
 			Log.Debugf("False condition for the compliance check '%s': Tag '%s' was expected (Condition: '%s')", checkName, condition.Value, condition.Name)
@@ -671,24 +1013,63 @@ func satisfyTestCondition(condition Condition, getResourceProperties func(string
 				return false
 			}
 		}
-	case "AND", "and":
+
+	case "property_matches":
+		re, err := regexp.Compile(condition.Value)
+		if err != nil || !anyMatch(re, getResourceProperties(condition.Property)) {
+			Log.Debugf("False condition for the compliance check '%s': %s matching '%s' was expected (Condition: '%s')", checkName, condition.Property, condition.Value, condition.Name)
+			return false
+		}
+
+	case "region_in":
+		if !ContainsString(condition.Values, eventuser.Region) {
+			Log.Debugf("False condition for the compliance check '%s': region %s is not in %v (Condition: '%s')", checkName, eventuser.Region, condition.Values, condition.Name)
+			return false
+		}
+
+	case "account_in":
+		if !ContainsString(condition.Values, eventuser.AccountId) {
+			Log.Debugf("False condition for the compliance check '%s': account %s is not in %v (Condition: '%s')", checkName, eventuser.AccountId, condition.Values, condition.Name)
+			return false
+		}
+
+	case "event_user_matches":
+		re, err := regexp.Compile(condition.Value)
+		if err != nil || !anyMatch(re, []string{eventuser.ARN, eventuser.Username}) {
+			Log.Debugf("False condition for the compliance check '%s': user %s (%s) does not match '%s' (Condition: '%s')", checkName, eventuser.Username, eventuser.ARN, condition.Value, condition.Name)
+			return false
+		}
+
+	case "time_window":
+		window, err := parseTimeWindow(condition.Value)
+		if err != nil || !window.contains(timeNow()) {
+			Log.Debugf("False condition for the compliance check '%s': out of the time window %s (Condition: '%s')", checkName, condition.Value, condition.Name)
+			return false
+		}
+
+	case "AND":
 		isSatisfied := true
 		for _, cond := range condition.Condition {
-			isSatisfied = isSatisfied && satisfyTestCondition(cond, getResourceProperties, checkName)
+			isSatisfied = isSatisfied && satisfyTestCondition(cond, getResourceProperties, checkName, eventuser)
 		}
 		if !isSatisfied {
 			Log.Debugf("False multiple condition (AND type) for the compliance check '%s'.", checkName)
 			return false
 		}
-	case "OR", "or":
+	case "OR":
 		isSatisfied := false
 		for _, cond := range condition.Condition {
-			isSatisfied = isSatisfied || satisfyTestCondition(cond, getResourceProperties, checkName)
+			isSatisfied = isSatisfied || satisfyTestCondition(cond, getResourceProperties, checkName, eventuser)
 		}
 		if !isSatisfied {
 			Log.Debugf("False multiple condition (OR type) for the compliance check '%s'.", checkName)
 			return false
 		}
+	case "NOT":
+		if len(condition.Condition) != 1 || satisfyTestCondition(condition.Condition[0], getResourceProperties, checkName, eventuser) {
+			Log.Debugf("False negated condition (NOT type) for the compliance check '%s'.", checkName)
+			return false
+		}
 	}
 	return true
 }
@@ -724,6 +1105,16 @@ func contains(array *[]CompliantCheckResult, isThere *CompliantCheckResult) bool
 	return result
 }
 
+// severityRank returns the position of the severity in SeverityLevels, -1 if it is unknown
+func severityRank(severity string) int {
+	for i, level := range SeverityLevels {
+		if level == severity {
+			return i
+		}
+	}
+	return -1
+}
+
 func ContainsString(s []string, e string) bool {
 	for _, a := range s {
 		if a == e {
@@ -17,28 +17,41 @@ package config
 */
 
 import (
-	"github.com/hashicorp/go-multierror"
-	"github.com/hashicorp/hcl"
 	"time"
 )
 
-var LogicalConditionsTypes = []string{"AND", "OR"}
+var LogicalConditionsTypes = []string{"AND", "OR", "NOT"}
+var ConditionTypes = []string{"tag_key_exists", "tag_key_not_exists", "tag_value_exists", "tag_value_not_exists", "tag_pair_exists",
+	"tag_pair_not_exists", "property_matches", "region_in", "account_in", "event_user_matches", "time_window"}
+var SeverityLevels = []string{"info", "low", "medium", "high", "critical"}
 var ValidEmailFieldNames = []string{"State.Creator", "APIEvent.UserIdentity.ARN", "State.Owner", "State.Operator"}
 
 // Config type
 type Config struct {
 	Region              string             `hcl:"region"`
-	AccessKey           string             `hcl:"access_key"`
-	SecretKey           string             `hcl:"secret_key"`
+	AccessKey           string             `hcl:"access_key" json:"-"` // secret: may be env:<VAR> or file:<path>
+	SecretKey           string             `hcl:"secret_key" json:"-"` // secret: may be env:<VAR> or file:<path>
+	APIToken            string             `hcl:"api_token" json:"-"`  // secret: bearer token of the HTTP API write routes, disabled without it
 	SecurityGroupPolicy []CompliancePolicy `hcl:"security_group_policy"`
 	EC2Policy           []CompliancePolicy `hcl:"ec2_policy"`
 	S3Policy            []CompliancePolicy `hcl:"s3_policy"`
+	IAMPolicy           []CompliancePolicy `hcl:"iam_policy"`
 	AreBotUserSession   string             `hcl:"arebot_user_session_name"`
 	Account             []Account          `hcl:"account"`
 	LdapConfig          LdapConfig         `hcl:"ldap_config"`
 	S3Config            S3Config           `hcl:"s3_config"`
 	SesConfig           SesConfig          `hcl:"ses_config"`
 	DynamoDBConfig      DynamoDBConfig     `hcl:"dynamodb_config"`
+	WorkerConfig        WorkerConfig       `hcl:"worker_config"`
+	// definitions shared by the policies: variables referenced as ${var.<name>} in their settings,
+	// rules referenced by their compliant blocks and actions they can use as their own
+	Variable []Variable `hcl:"variable"`
+	Rule     []Rule     `hcl:"rule"`
+	Action   []Action   `hcl:"action"`
+	// the non-compliant results to waive until they expire (see exemptions.go)
+	Exemption []Exemption `hcl:"exemption"`
+	// the config files the configuration has been read from
+	Files []string `hcl:"-"`
 }
 
 type CompliancePolicy struct {
@@ -46,6 +59,8 @@ type CompliancePolicy struct {
 	Account       string          `hcl:"account"`
 	VpcID         string          `hcl:"vpc"` // default ".*"
 	APICall       []APICall       `hcl:"api_call"`
+	// the blocks named after a type of resource, e.g. "ec2_instance", checked on every event of its resources
+	Resource      []APICall       `hcl:"resource"`
 	Action        []Action        `hcl:"action"`
 	ActionTrigger []ActionTrigger `hcl:"action_trigger"`
 }
@@ -59,6 +74,12 @@ type CompliancePolicy struct {
    DeleteSecurityGroup
    RevokeSecurityGroupEgress
    RevokeSecurityGroupIngress
+   RunInstances, StartInstances, CreateVolume, AttachVolume, CreateSnapshot
+The CloudWatch events that are not API calls are matched by their detail type:
+   EC2 Instance State-change Notification (the instances are checked once running)
+   EC2 Instance Launch Successful (Auto Scaling)
+The resource blocks have the same settings, their name is the type of resource (security_group, ec2_instance,
+ec2_volume, ec2_snapshot, s3_bucket, iam_user, iam_role). Their results have the type of resource as EventType.
 */
 type APICall struct {
 	Name      string           `hcl:",key"`
@@ -89,15 +110,59 @@ type CompliantCheck struct {
 	Description string      `hcl:"description"`
 	Condition   []Condition `hcl:"condition"`
 	Actions     []string    `hcl:"actions"`
+	// name of the shared rule completing the settings of the check
+	Rule string `hcl:"rule"`
+	// typed comparisons (min, max, in, ...), negated as well by negate
+	Operators `hcl:",squash"`
+	// semantic checks of the security group rules, instead of the schema and operators
+	PermissionChecks `hcl:",squash"`
+	// check over several properties of the resource, e.g. "Tag.Name.startsWith(Tag.ProjectName)" (see expression.go)
+	Expression string `hcl:"expression"`
+	// classification of the check: one of SeverityLevels (default "medium"), a free category
+	// and the IDs of the controls it implements, e.g. "CIS 4.1"
+	Severity string   `hcl:"severity"`
+	Category string   `hcl:"category"`
+	Controls []string `hcl:"controls"`
+}
+
+/* Rule is a compliance check shared by several compliant blocks, which reference it by name.
+The settings of the compliant block take precedence over the ones of the rule, whose conditions are added
+to the conditions of the block.
+*/
+type Rule struct {
+	Name        string      `hcl:",key"`
+	Schema      string      `hcl:"schema"`
+	Negate      bool        `hcl:"negate"`
+	Mandatory   bool        `hcl:"mandatory"`
+	Description string      `hcl:"description"`
+	Condition   []Condition `hcl:"condition"`
+	Actions     []string    `hcl:"actions"`
+	Operators        `hcl:",squash"`
+	PermissionChecks `hcl:",squash"`
+	Expression  string      `hcl:"expression"`
+	Severity    string      `hcl:"severity"`
+	Category    string      `hcl:"category"`
+	Controls    []string    `hcl:"controls"`
+}
+
+// Variable is a value shared by the settings of the policies, rules and actions, which reference it as ${var.<name>}
+type Variable struct {
+	Name  string `hcl:",key"`
+	Value string `hcl:"value"`
 }
 
 type Condition struct {
 	//tag_key_not_exists/tag_value_not_exists vs. tag_key_exists/tag_value_exists
 	//Type can be set to 'AND'/'OR' values and then
+	//'NOT' negates its single nested condition
 	Name      string      `hcl:",key"`
 	Type      string      `hcl:"type"`
 	Value     string      `hcl:"value"`
 	Condition []Condition `hcl:"condition"`
+	// property_matches: the property whose values are matched with the pattern in value
+	Property string `hcl:"property"`
+	// region_in, account_in: the regions, the account IDs or names of the event
+	Values []string `hcl:"values"`
 }
 
 //type CheckCondition struct {
@@ -115,6 +180,11 @@ type Action struct {
 	Email     EmailNotification   `hcl:"email"`
 	Condition []TimeCondition     `hcl:"condition"`
 	Operation []ResourceOperation `hcl:"operation"`
+	// optional filters of the check results handled by the action: the lowest severity and the categories
+	MinSeverity string   `hcl:"min_severity"`
+	Categories  []string `hcl:"categories"`
+	// set for the top-level actions added to the policies that use them
+	Shared bool `hcl:"-"`
 }
 
 type EmailNotification struct {
@@ -145,15 +215,29 @@ type Account struct {
 	AccountID       string `hcl:"account_id"`
 	Region          string `hcl:"region"`
 	ArebotRoleArn   string `hcl:"arebot_role_arn"`
-	AllEventsQueue  string `hcl:"all_events_queue"`
+	AllEventsQueue  string `hcl:"all_events_queue"` // optional if the events are forwarded to the queue of another account
+	DeadLetterQueue string `hcl:"dead_letter_queue"` // optional: where messages that keep failing are moved
 	RoleSessionName string `hcl:"role_session_name"`
+	// optional: override the worker_config pool settings for the queue of this account
+	Workers   int `hcl:"workers"`
+	Receivers int `hcl:"receivers"`
+	// optional: the monitored regions (default: all). The resources are described in the region of their event.
+	Regions     []string      `hcl:"regions"`
+	RegionQueue []RegionQueue `hcl:"region_queue"`
+}
+
+// RegionQueue is an additional queue of an account, receiving the events of a region
+type RegionQueue struct {
+	Region          string `hcl:",key"`
+	AllEventsQueue  string `hcl:"all_events_queue"`
+	DeadLetterQueue string `hcl:"dead_letter_queue"`
 }
 
 type LdapConfig struct {
 	LdapHost     string `hcl:"ldap_host"`
 	LdapPort     string `hcl:"ldap_port"`
 	BindUsername string `hcl:"bind_username"`
-	BindPassword string `hcl:"bind_password"`
+	BindPassword string `hcl:"bind_password" json:"-"` // secret: may be env:<VAR> or file:<path>
 	SearchBase   string `hcl:"search_base"`
 }
 
@@ -177,43 +261,72 @@ type DynamoDBConfig struct {
 	ArebotRoleArn string `hcl:"arebot_role_arn"`
 }
 
+/* WorkerConfig defines how the SQS worker processes the messages of the account queues.
+Each queue is polled by `receivers` goroutines and its messages are handled by a pool of `workers`
+goroutines (both can be overridden in the account block). At most `max_describe_calls` AWS describe calls
+are in flight at the same time, over all the accounts. On SIGTERM/SIGINT, AreBOT stops receiving and gives the
+in-flight messages and periodic checks `shutdown_timeout_seconds` to complete before cancelling them.
+A CloudTrail event delivered again within `dedup_window_seconds` after it has been processed is acknowledged
+without being checked again.
+The visibility timeout of a failed message grows exponentially (backoff_seconds * 2^(attempt-1), up to
+max_backoff_seconds). After max_attempts the message is moved to the dead_letter_queue of its account or,
+if none is configured, saved into the dead_letter_folder. Without either, the messages that cannot be decoded
+are logged and deleted, the others are left to the redrive policy of the queue.
+*/
+type WorkerConfig struct {
+	Workers                int    `hcl:"workers"`                  // default 10
+	Receivers              int    `hcl:"receivers"`                // default 1
+	MaxDescribeCalls       int    `hcl:"max_describe_calls"`       // default 20
+	ShutdownTimeoutSeconds int64  `hcl:"shutdown_timeout_seconds"` // default 30
+	DedupWindowSeconds     int64  `hcl:"dedup_window_seconds"`     // default 86400
+	MaxAttempts            int    `hcl:"max_attempts"`             // default 5
+	BackoffSeconds         int64  `hcl:"backoff_seconds"`          // default 30
+	MaxBackoffSeconds      int64  `hcl:"max_backoff_seconds"`      // default 43200 (12 hours, the SQS maximum)
+	DeadLetterFolder       string `hcl:"dead_letter_folder"`
+}
+
 type CompliantCheckResult struct {
 	IsCompliant          bool
 	Check                CompliantCheck
 	EventUser            EventUserInfo
 	EventType            string
 	Value, ResourceId    string
+	Region               string
 	DateAndTypeComposite string
 	CreationDate         time.Time
+	// the classification of the check when the result has been created
+	Severity, Category string
+	Controls           []string
+	// a non-compliant result waived by the named exemption, which suppresses its actions
+	Waived    bool
+	Exemption string
 }
 
 type EventUserInfo struct {
 	AccountId, Username, EmailAddress, Region string
+	// the ARN of the user identity of the API call
+	ARN string
 }
 
 // ParseConfig parse the given HCL string into a Config struct.
 func ParseConfig(hclText string) (*Config, error) {
-	Log.Debugf("Parsing config: %s", hclText)
-	result := &Config{}
-	var errors *multierror.Error
-
-	hclParseTree, err := hcl.Parse(hclText)
-	if err != nil {
-		return nil, err
-	}
+	return parseConfig("", hclText)
+}
 
-	if err := hcl.DecodeObject(&result, hclParseTree); err != nil {
+// ParseConfigFile parse the config files at the given path into a Config struct. The path is a file, a directory
+// (all its .cfg files) or a glob pattern; the files may include other files. The errors report their position in the files.
+func ParseConfigFile(path string) (*Config, error) {
+	loader := newConfigLoader()
+	if err := loader.loadFiles(path); err != nil {
 		return nil, err
 	}
+	return loader.decode()
+}
 
-	if err = integrateConfigSettings(result); err != nil {
+func parseConfig(filename string, hclText string) (*Config, error) {
+	loader := newConfigLoader()
+	if err := loader.parse(filename, hclText); err != nil {
 		return nil, err
 	}
-	if err = validateConfigSettings(result); err != nil {
-		return nil, err
-	}
-
-	Log.Infof("Starting with config %v", result)
-
-	return result, errors.ErrorOrNil()
+	return loader.decode()
 }
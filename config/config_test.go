@@ -17,11 +17,17 @@ package config
 */
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/hashicorp/go-multierror"
 )
 
 func TestMain(m *testing.M) {
@@ -42,19 +48,19 @@ func TestConfigParsingNew(t *testing.T) {
 		panic(err)
 	}
 
-	_, apicallsConfigs := config.GetAPICallConfigs("CreateSecurityGroup", "222233334444", "vpc-12345678", "security_group")
+	_, apicallsConfigs := config.GetAPICallConfigs("CreateSecurityGroup", "222233334444", "vpc-12345678", "security_group", "")
 	if len(apicallsConfigs) != 1 {
 		t.Errorf("apicallsConfigs == %d expected 1", len(apicallsConfigs))
 	}
-	_, apicallsConfigs = config.GetAPICallConfigs("CreateSecurityGroup", "222233334444", "vpc-11111111", "security_group")
+	_, apicallsConfigs = config.GetAPICallConfigs("CreateSecurityGroup", "222233334444", "vpc-11111111", "security_group", "")
 	if len(apicallsConfigs) != 1 {
 		t.Errorf("apicallsConfigs == %d expected 1", len(apicallsConfigs))
 	}
-	_, apicallsConfigs = config.GetAPICallConfigs("CreateSecurityGroup", "222233334444", "vpc-12345679", "security_group")
+	_, apicallsConfigs = config.GetAPICallConfigs("CreateSecurityGroup", "222233334444", "vpc-12345679", "security_group", "")
 	if len(apicallsConfigs) != 0 {
 		t.Errorf("apicallsConfigs == %d expected 0", len(apicallsConfigs))
 	}
-	_, apicallsConfigs = config.GetAPICallConfigs("CreateSecurityGroup", "355291122841", "vpc-12345678", "security_group")
+	_, apicallsConfigs = config.GetAPICallConfigs("CreateSecurityGroup", "355291122841", "vpc-12345678", "security_group", "")
 	if len(apicallsConfigs) != 0 {
 		t.Errorf("apicallsConfigs == %d expected 0", len(apicallsConfigs))
 	}
@@ -88,6 +94,201 @@ func TestConfigRoleSession(t *testing.T) {
 	}
 }
 
+func TestConfigWorkerPool(t *testing.T) {
+
+	config, err := ParseConfig(roleSessionConfigTestFixure)
+	if err != nil {
+		t.Error(err)
+	}
+	if workers, receivers := config.GetAccountWorkerPool("000000000000"); workers != 10 || receivers != 1 {
+		t.Errorf("default worker pool == %d/%d expected 10/1", workers, receivers)
+	}
+
+	config, err = ParseConfig(roleSessionConfigTestFixure + workerPoolConfig)
+	if err != nil {
+		t.Error(err)
+	}
+	if workers, receivers := config.GetAccountWorkerPool("222222222222"); workers != 4 || receivers != 2 {
+		t.Errorf("account worker pool == %d/%d expected 4/2", workers, receivers)
+	}
+	if workers, receivers := config.GetAccountWorkerPool("111111111111"); workers != 4 || receivers != 3 {
+		t.Errorf("account worker pool == %d/%d expected 4/3", workers, receivers)
+	}
+	if config.WorkerConfig.MaxDescribeCalls != 20 {
+		t.Errorf("max_describe_calls == %d expected 20", config.WorkerConfig.MaxDescribeCalls)
+	}
+}
+
+func TestConfigRegions(t *testing.T) {
+
+	config, err := ParseConfig(roleSessionConfigTestFixure + regionsConfig)
+	if err != nil {
+		t.Error(err)
+	}
+	if regions := config.GetAccountRegions("000000000000"); !reflect.DeepEqual(regions, []string{"eu-central-1"}) {
+		t.Errorf("default account regions == %+v expected the account region", regions)
+	}
+	if !config.IsAccountRegion("000000000000", "us-east-1") {
+		t.Error("all the regions should be monitored by default")
+	}
+	if regions := config.GetAccountRegions("333333333333"); !reflect.DeepEqual(regions, []string{"eu-central-1", "eu-west-1"}) {
+		t.Errorf("account regions == %+v expected [eu-central-1 eu-west-1]", regions)
+	}
+	if !config.IsAccountRegion("333333333333", "eu-west-1") || config.IsAccountRegion("333333333333", "us-east-1") {
+		t.Error("only the regions of the account should be monitored")
+	}
+
+	_, err = ParseConfig(roleSessionConfigTestFixure + strings.Replace(regionsConfig, `region_queue "eu-west-1"`, `region_queue "us-east-1"`, 1))
+	if err == nil {
+		t.Error("a queue of a region that is not monitored should be rejected")
+	}
+}
+
+func TestConfigLint(t *testing.T) {
+
+	IsHandledAPICall = func(apiCall string) bool { return apiCall == "CreateSecurityGroup" }
+	IsSupportedProperty = func(policyType string, key string) bool { return key == "GroupName" }
+	defer func() {
+		IsHandledAPICall, IsSupportedProperty = nil, nil
+	}()
+
+	_, err := parseConfig("lint.cfg", lintConfig)
+	merr, ok := err.(*multierror.Error)
+	if !ok {
+		t.Fatalf("expected the errors of all the mistakes, got %v", err)
+	}
+	expected := []string{
+		"lint.cfg:3:3: security_group_policy: lint has an invalid vpc pattern",
+		"lint.cfg:8:7: CompliantCheck: GroupName refers to the wrong security_group_policy name",
+		"lint.cfg:7:7: CompliantCheck: GroupName has an invalid schema",
+		"lint.cfg:12:3: APICall: CreateSecurityGroups is not handled by AreBOT.",
+		"lint.cfg:13:5: CompliantCheck: groupName is not a property",
+		"lint.cfg:29:5: ActionTrigger: daily has an invalid schedule",
+		"lint.cfg:19:3: Action: later has a 'start_after' condition",
+		"lint.cfg:26:3: Action: unused is not used",
+	}
+	if len(merr.Errors) != len(expected) {
+		t.Errorf("%d errors expected %d: %v", len(merr.Errors), len(expected), merr)
+	}
+	for i, e := range merr.Errors {
+		if i < len(expected) && !strings.HasPrefix(e.Error(), expected[i]) {
+			t.Errorf("error %d == %q expected %q", i, e, expected[i])
+		}
+	}
+
+	// the policies are correct once the mistakes are fixed
+	fixed := strings.NewReplacer(`vpc = "vpc-(1234"`, `vpc = "vpc-1234"`, `schema = "[A-Z"`, `schema = "[A-Z]"`,
+		`policy_name = "other"`, `policy_name = "lint"`, `"CreateSecurityGroups"`, `"CreateSecurityGroup"`,
+		`compliant "groupName"`, `compliant "GroupName"`, `schedule = "every day"`, `schedule = "@daily"`,
+		`action = [ "notify" ]`, `action = [ "notify", "later", "unused" ]`).Replace(lintConfig)
+	if _, err := ParseConfig(fixed); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConfigFiles(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "arebot-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "teams"), 0755)
+	write := func(name string, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("arebot.cfg", "include = [ \"teams/*.cfg\" ]\n"+roleSessionConfigTestFixure)
+	write("teams/a.cfg", strings.Replace(negation, `"tag"`, `"team_a"`, 1))
+	write("teams/b.cfg", strings.Replace(negation, `"tag"`, `"team_b"`, 1))
+
+	for _, path := range []string{filepath.Join(dir, "arebot.cfg"), dir, filepath.Join(dir, "*.cfg")} {
+		config, err := ParseConfigFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(config.SecurityGroupPolicy) != 2 || config.GetCompliancePolicy("team_a") == nil || config.GetCompliancePolicy("team_b") == nil {
+			t.Errorf("%s: policies == %+v expected the policies of both teams", path, config.SecurityGroupPolicy)
+		}
+		if len(config.Account) != 2 || len(config.Files) != 3 {
+			t.Errorf("%s: %d accounts read from %v expected 2 accounts from 3 files", path, len(config.Account), config.Files)
+		}
+	}
+
+	// the same policy is defined by both teams
+	write("teams/b.cfg", strings.Replace(negation, `"tag"`, `"team_a"`, 1))
+	_, err = ParseConfigFile(dir)
+	expected := fmt.Sprintf(`%s:2:1: security_group_policy "team_a" is already defined at %s:2:1.`,
+		filepath.Join(dir, "teams/b.cfg"), filepath.Join(dir, "teams/a.cfg"))
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("error == %v expected %s", err, expected)
+	}
+
+	if _, err = ParseConfigFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("a missing config file should be reported")
+	}
+}
+
+func TestConfigDefinitions(t *testing.T) {
+
+	config, err := ParseConfig(definitionsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := config.SecurityGroupPolicy[0].APICall[0].Compliant[0]
+	if check.Schema != "^Proj-[0-9]{3}$" || !check.Mandatory || !reflect.DeepEqual(check.Actions, []string{"notify"}) {
+		t.Errorf("check == %+v expected the settings of the rule", check)
+	}
+	if len(check.Condition) != 2 || check.Condition[0].Name != "from_rule" {
+		t.Errorf("conditions == %+v expected the conditions of the rule and of the check", check.Condition)
+	}
+	if check := config.SecurityGroupPolicy[0].APICall[0].Compliant[1]; check.Schema != "^[a-z]+$" || check.Mandatory {
+		t.Errorf("check == %+v expected its own settings", check)
+	}
+
+	action := config.GetActionByIdAndPolicyName("notify", "sg_policy")
+	if action == nil || !action.Shared || !reflect.DeepEqual(action.Email.Receiver, []string{"admins@example.com"}) {
+		t.Errorf("action == %+v expected the shared action with the expanded receiver", action)
+	}
+	action = config.GetActionByIdAndPolicyName("notify", "ec2_policy")
+	if action == nil || action.Shared || len(action.Condition) != 1 {
+		t.Errorf("action == %+v expected the action of the policy", action)
+	}
+
+	for _, mistake := range []struct{ old, new, expected string }{
+		{`rule = "project"`, `rule = "projects"`, "refers to the undefined rule projects"},
+		{`${var.admins}`, `${var.admin}`, "Variable: admin is not defined"},
+		{`actions = [ "notify" ]`, `actions = [ "ignore" ]`, "Action: notify is not used by any policy"},
+	} {
+		_, err := ParseConfig(strings.Replace(definitionsConfig, mistake.old, mistake.new, 1))
+		if err == nil || !strings.Contains(err.Error(), mistake.expected) {
+			t.Errorf("error == %v expected %s", err, mistake.expected)
+		}
+	}
+}
+
+func TestSwapWaitsForHolders(t *testing.T) {
+
+	release := Hold()
+	swapped := make(chan struct{})
+	go Swap(func() {
+		close(swapped)
+	})
+
+	select {
+	case <-swapped:
+		t.Error("the configuration should not be swapped while it is held")
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	select {
+	case <-swapped:
+	case <-time.After(time.Second):
+		t.Error("the configuration should be swapped once released")
+	}
+}
+
 func TestParsingAdditionalSettings(t *testing.T) {
 
 	config, err := ParseConfig(additionalSettings)
@@ -108,6 +309,464 @@ func TestNegation(t *testing.T) {
 	}
 }
 
+func TestOperators(t *testing.T) {
+
+	config, err := ParseConfig(operatorsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := map[string]CompliantCheck{}
+	for _, c := range config.EC2Policy[0].APICall[0].Compliant {
+		checks[c.Name] = c
+	}
+
+	old := time.Now().Add(-100 * 24 * time.Hour)
+	for _, test := range []struct {
+		check, value string
+		compliant    bool
+	}{
+		{"Size", "500", true},
+		{"Size", "501", false},
+		{"Size", "large", false},
+		{"Iops", "100", true},
+		{"Iops", "99", false},
+		{"Iops", "3001", false},
+		{"VolumeType", "GP2", true},
+		{"VolumeType", "io1", false},
+		{"AvailabilityZone", "eu-central-1a", true},
+		{"AvailabilityZone", "us-east-1a", false},
+		{"Status", "AVAILABLE", true},
+		{"CreateTime", old.String(), false},
+		{"CreateTime", time.Now().Add(-time.Hour).String(), true},
+		{"Tag.Backup", old.Format(time.RFC3339), true},
+		{"Tag.Backup", "yesterday", false},
+	} {
+		compliant, err := checks[test.check].IsCompliant(test.check, test.value)
+		if err != nil {
+			t.Error(err)
+		}
+		if compliant != test.compliant {
+			t.Errorf("%s = %s: compliant == %t expected %t", test.check, test.value, compliant, test.compliant)
+		}
+	}
+
+	if _, err := ParseConfig(strings.Replace(operatorsConfig, "min = 100", "min = 5000", 1)); err == nil {
+		t.Error("min greater than max should be rejected")
+	}
+	if _, err := ParseConfig(strings.Replace(operatorsConfig, `"90 days"`, `"90 d"`, 1)); err == nil {
+		t.Error("an invalid duration should be rejected")
+	}
+}
+
+func TestPermissionChecks(t *testing.T) {
+
+	ssh := PermissionChecks{DenyWorld: true, ForbiddenPorts: []string{"22"}}
+	internal := PermissionChecks{AllowedNetworks: []string{"10.0.0.0/8", "fd00::/8"}}
+	noDNS := PermissionChecks{ForbiddenPorts: []string{"udp:53", "6000-6010"}}
+
+	for _, test := range []struct {
+		checks    PermissionChecks
+		rule      string
+		compliant bool
+	}{
+		{ssh, "P:tcp;FP:22;TP:22;IP:0.0.0.0/0", false},
+		{ssh, "P:tcp;FP:0;TP:1024;IP:0.0.0.0/0", false},
+		{ssh, "P:-1;FP:-1;TP:-1;IP:::/0", false},
+		{ssh, "P:6;FP:-1;TP:-1;IP:0.0.0.0/0", false},
+		{ssh, "P:tcp;FP:22;TP:22;IP:10.0.0.0/8", true},
+		{ssh, "P:tcp;FP:80;TP:443;IP:0.0.0.0/0", true},
+		{ssh, "P:icmp;FP:-1;TP:-1;IP:0.0.0.0/0", true},
+		{ssh, "P:tcp;FP:22;TP:22;UG:000000000000/sg-0011aabb", true},
+		{internal, "P:tcp;FP:22;TP:22;IP:10.1.0.0/16", true},
+		{internal, "P:tcp;FP:22;TP:22;IP:fd12::/64", true},
+		{internal, "P:tcp;FP:22;TP:22;IP:0.0.0.0/0", false},
+		{internal, "P:tcp;FP:22;TP:22;IP:::/0", false},
+		{internal, "P:tcp;FP:22;TP:22;IP:192.168.0.0/16", false},
+		{noDNS, "P:udp;FP:50;TP:60;IP:10.0.0.0/8", false},
+		{noDNS, "P:tcp;FP:53;TP:53;IP:10.0.0.0/8", true},
+		{noDNS, "P:tcp;FP:6010;TP:7000;UG:000000000000/sg-0011aabb", false},
+	} {
+		compliant, err := CompliantCheck{PermissionChecks: test.checks}.IsCompliantPermission(test.rule)
+		if err != nil {
+			t.Error(err)
+		}
+		if compliant != test.compliant {
+			t.Errorf("%+v %s: compliant == %t expected %t", test.checks, test.rule, compliant, test.compliant)
+		}
+	}
+
+	if _, err := ParseConfig(strings.Replace(permissionsConfig, `"22"`, `"22-20"`, 1)); err == nil {
+		t.Error("an invalid port range should be rejected")
+	}
+	if _, err := ParseConfig(strings.Replace(permissionsConfig, `IpPermissions.IpRanges`, `GroupName`, 1)); err == nil {
+		t.Error("the permission checks of another property should be rejected")
+	}
+	config, err := ParseConfig(permissionsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check := config.SecurityGroupPolicy[0].APICall[0].Compliant[0]; !check.DenyWorld || len(check.AllowedNetworks) != 1 {
+		t.Errorf("check == %+v expected the permission checks", check)
+	}
+}
+
+func TestExpressions(t *testing.T) {
+
+	properties := map[string][]string{
+		"InstanceType":        {"x1.16xlarge"},
+		"Tag.Name":            {"Proj-042-web"},
+		"Tag.ProjectName":     {"Proj-042"},
+		"Tag.cost-center":     {"4711"},
+		"Size":                {"500"},
+		"IpPermissions.Ports": {"22", "443"},
+	}
+	getProperties := func(key string) []string {
+		return properties[key]
+	}
+
+	for _, test := range []struct {
+		expression string
+		compliant  bool
+	}{
+		{"Tag.Name.startsWith(Tag.ProjectName)", true},
+		{"startsWith(Tag.ProjectName, Tag.Name)", false},
+		{"!InstanceType.matches('^x1\\.') || has(Tag.CostApproval)", false},
+		{`InstanceType.matches("^t2\\.") ? has(Tag.CostApproval) : true`, true},
+		{"Size > 100 && Size <= 500", true},
+		{"Size == 500.0 && Size != '500.0'", true},
+		{"-Size < 0", true},
+		{"prop('Tag.cost-center') in ['4711', '4712']", true},
+		{"'443' in IpPermissions.Ports && !('80' in IpPermissions.Ports)", true},
+		{"IpPermissions.Ports.contains('2') && size(IpPermissions.Ports) == 2", true},
+		{"Tag.Missing == null && !has(Tag.Missing) && !Tag.Missing.endsWith('x')", true},
+		{"Tag.Missing > 1 || Tag.Missing <= 1", false},
+		{"lower(Tag.ProjectName) == 'proj-042' && Tag.ProjectName.size() == 8", true},
+	} {
+		compliant, err := CompliantCheck{Expression: test.expression}.IsCompliantExpression(getProperties)
+		if err != nil {
+			t.Errorf("%s: %s", test.expression, err)
+		}
+		if compliant != test.compliant {
+			t.Errorf("%s: compliant == %t expected %t", test.expression, compliant, test.compliant)
+		}
+	}
+
+	for _, invalid := range []string{"Size >", "has(Size", "Size.length()", "exec('rm')", "has(Size, 1)", "Size.matches('(')",
+		"'unterminated", "Size # 1", "prop(Size)"} {
+		if _, err := parseExpression(invalid); err == nil {
+			t.Errorf("%s should be an invalid expression", invalid)
+		}
+	}
+	for _, failing := range []string{"Size", "Size && true", "!Tag.Name", "-Tag.Name > 0"} {
+		if _, err := (CompliantCheck{Expression: failing}).IsCompliantExpression(getProperties); err == nil {
+			t.Errorf("%s should fail to evaluate", failing)
+		}
+	}
+
+	config, err := ParseConfig(expressionConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := config.EC2Policy[0].APICall[0].CheckCompliance(getProperties, "i-0011aabb", EventUserInfo{})
+	if len(results) != 1 || !results[0].IsCompliant || results[0].Value != "Proj-042-web" {
+		t.Errorf("results == %+v expected a compliant Tag.Name", results)
+	}
+	if _, err := ParseConfig(strings.Replace(expressionConfig, `mandatory = false`, `mandatory = true`, 1)); err == nil {
+		t.Error("an expression combined with mandatory should be rejected")
+	}
+	if _, err := ParseConfig(strings.Replace(expressionConfig, `startsWith`, `beginsWith`, 1)); err == nil {
+		t.Error("an unknown method should be rejected")
+	}
+}
+
+func TestConditions(t *testing.T) {
+
+	config, err := ParseConfig(conditionsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conditions := map[string]Condition{}
+	for _, c := range config.EC2Policy[0].APICall[0].Compliant[0].Condition {
+		conditions[c.Name] = c
+	}
+	if values := conditions["production"].Values; len(values) != 2 || values[0] != "111122223333" || values[1] != "444455556666" {
+		t.Errorf("account_in values == %v expected the account IDs", values)
+	}
+
+	defer func() { timeNow = time.Now }()
+	// a Wednesday
+	timeNow = func() time.Time { return time.Date(2017, 6, 7, 10, 30, 0, 0, time.UTC) }
+
+	properties := map[string][]string{"InstanceType": {"m5.large"}}
+	getProperties := func(key string) []string {
+		return properties[key]
+	}
+	user := EventUserInfo{AccountId: "111122223333", Region: "eu-central-1", Username: "jane", ARN: "arn:aws:iam::111122223333:user/jane"}
+	for _, test := range []struct {
+		condition string
+		user      EventUserInfo
+		satisfied bool
+	}{
+		{"m5", user, true},
+		{"production", user, true},
+		{"production", EventUserInfo{AccountId: "000000000000"}, false},
+		{"europe", user, true},
+		{"europe", EventUserInfo{Region: "us-east-1"}, false},
+		{"developers", user, true},
+		{"developers", EventUserInfo{Username: "john", ARN: "arn:aws:sts::111122223333:assumed-role/admin/john"}, false},
+		{"business_hours", user, true},
+		{"night", user, false},
+		{"not_weekend", user, true},
+		{"combined", user, true},
+		{"combined", EventUserInfo{AccountId: "444455556666", Region: "us-east-1"}, false},
+	} {
+		if satisfied := satisfyTestCondition(conditions[test.condition], getProperties, "InstanceType", test.user); satisfied != test.satisfied {
+			t.Errorf("%s with %+v: satisfied == %t expected %t", test.condition, test.user, satisfied, test.satisfied)
+		}
+	}
+
+	// the case of the logical types does not matter
+	mixedCase := strings.Replace(strings.Replace(conditionsConfig, `type = "NOT"`, `type = "Not"`, -1), `type = "OR"`, `type = "Or"`, 1)
+	mixedConfig, err := ParseConfig(mixedCase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range mixedConfig.EC2Policy[0].APICall[0].Compliant[0].Condition {
+		if c.Name != "combined" {
+			continue
+		}
+		if !satisfyTestCondition(c, getProperties, "InstanceType", user) ||
+			satisfyTestCondition(c, getProperties, "InstanceType", EventUserInfo{AccountId: "444455556666", Region: "us-east-1"}) {
+			t.Errorf("%s should be evaluated as an Or of a Not condition", c.Name)
+		}
+	}
+
+	for _, test := range []struct {
+		window string
+		time   time.Time
+		inside bool
+	}{
+		{"22:00-06:00", time.Date(2017, 6, 7, 23, 0, 0, 0, time.UTC), true},
+		{"22:00-06:00", time.Date(2017, 6, 7, 5, 59, 0, 0, time.UTC), true},
+		{"22:00-06:00", time.Date(2017, 6, 7, 6, 0, 0, 0, time.UTC), false},
+		{"Fri 22:00-06:00", time.Date(2017, 6, 10, 3, 0, 0, 0, time.UTC), true},
+		{"Fri 22:00-06:00", time.Date(2017, 6, 9, 3, 0, 0, 0, time.UTC), false},
+		{"Sat,Sun 00:00-24:00", time.Date(2017, 6, 11, 12, 0, 0, 0, time.UTC), true},
+		{"Fri-Mon 08:00-18:00", time.Date(2017, 6, 5, 9, 0, 0, 0, time.UTC), true},
+		{"Fri-Mon 08:00-18:00", time.Date(2017, 6, 6, 9, 0, 0, 0, time.UTC), false},
+	} {
+		window, err := parseTimeWindow(test.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inside := window.contains(test.time); inside != test.inside {
+			t.Errorf("%s contains %s == %t expected %t", test.window, test.time, inside, test.inside)
+		}
+	}
+
+	for _, invalid := range []string{`type = "NOT"`, `type = "tag_exists"`, `type = "time_window"`} {
+		if _, err := ParseConfig(strings.Replace(conditionsConfig, `type = "OR"`, invalid, 1)); err == nil {
+			t.Errorf("a condition with %s should be rejected", invalid)
+		}
+	}
+	for _, invalid := range []string{"Mon-Fri 8:00-18:00", "Weekdays 08:00-18:00", "08:00-18:00 Mars/Olympus", ""} {
+		if _, err := parseTimeWindow(invalid); err == nil {
+			t.Errorf("%s should be an invalid time window", invalid)
+		}
+	}
+}
+
+func TestSeverity(t *testing.T) {
+
+	config, err := ParseConfig(severityConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := config.SecurityGroupPolicy[0].APICall[0].Compliant
+	if checks[0].Severity != "critical" || checks[0].Category != "network" || len(checks[0].Controls) != 2 {
+		t.Errorf("check == %+v expected the classification of the rule", checks[0])
+	}
+	if checks[1].Severity != "medium" {
+		t.Errorf("severity == %s expected the default medium", checks[1].Severity)
+	}
+
+	properties := map[string][]string{"IpPermissions.IpRanges": {"P:tcp;FP:22;TP:22;IP:0.0.0.0/0"}}
+	results := config.SecurityGroupPolicy[0].APICall[0].CheckCompliance(func(key string) []string {
+		return properties[key]
+	}, "sg-0011aabb", EventUserInfo{})
+	if len(results) != 2 {
+		t.Fatalf("results == %+v expected 2 results", results)
+	}
+	page, notify := config.SecurityGroupPolicy[0].getAction("page"), config.SecurityGroupPolicy[0].getAction("notify")
+	for _, result := range results {
+		critical := result.Check.Name == "IpPermissions.IpRanges"
+		if critical != (result.Severity == "critical") || critical != (result.Category == "network") || critical != (len(result.Controls) == 2) {
+			t.Errorf("result == %+v expected the classification of its check", result)
+		}
+		if page.Accepts(result) != critical {
+			t.Errorf("page accepts %s == %t", result.Check.Name, !critical)
+		}
+		if !notify.Accepts(result) {
+			t.Errorf("notify should accept %s", result.Check.Name)
+		}
+	}
+	if (Action{Categories: []string{"tagging"}}).Accepts(results[0]) == (Action{Categories: []string{"tagging"}}).Accepts(results[1]) {
+		t.Error("the category filter should only accept the tagging result")
+	}
+
+	if _, err := ParseConfig(strings.Replace(severityConfig, `"critical"`, `"urgent"`, 1)); err == nil {
+		t.Error("an invalid severity should be rejected")
+	}
+	if _, err := ParseConfig(strings.Replace(severityConfig, `"high"`, `"severe"`, 1)); err == nil {
+		t.Error("an invalid min_severity should be rejected")
+	}
+}
+
+func TestExemptions(t *testing.T) {
+
+	config, err := ParseConfig(exemptionsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := map[string][]string{"Tag.Role": {"bastion"}}
+	getProperties := func(key string) []string {
+		return tags[key]
+	}
+	result := func(resourceId string, account string, check string, compliant bool) CompliantCheckResult {
+		return CompliantCheckResult{ResourceId: resourceId, IsCompliant: compliant, EventUser: EventUserInfo{AccountId: account},
+			Check: CompliantCheck{Name: check, PolicyName: "sg"}}
+	}
+	results := config.WaiveExemptedResults([]CompliantCheckResult{
+		result("sg-bastion", "111122223333", "IpPermissions.IpRanges", false),
+		result("sg-bastion", "444455556666", "IpPermissions.IpRanges", false),
+		result("sg-bastion", "111122223333", "Tag.Owner", false),
+		result("sg-bastion", "111122223333", "IpPermissions.IpRanges", true),
+		result("sg-legacy", "444455556666", "Tag.Owner", false),
+		result("sg-expired", "444455556666", "Tag.Owner", false),
+	}, getProperties, []Exemption{
+		{Name: "api", ResourceIds: []string{"sg-legacy"}, Reason: "migration", Approver: "cto@company.com", Expires: "2099-01-01"},
+		{Name: "expired", ResourceIds: []string{"sg-expired"}, Reason: "migration", Approver: "cto@company.com", Expires: "2017-01-01"},
+	})
+	for i, expected := range []string{"non-compliant", "non-compliant", "non-compliant", "compliant", "waived", "non-compliant"} {
+		if status := results[i].Status(); status != expected {
+			t.Errorf("result %d status == %s expected %s", i, status, expected)
+		}
+	}
+	results = config.WaiveExemptedResults([]CompliantCheckResult{result("sg-bastion", "111122223333", "IpPermissions.IpRanges", false)}, func(key string) []string {
+		return map[string][]string{"Tag.Role": {"bastion"}, "Tag.Approved": {"yes"}}[key]
+	}, nil)
+	if !results[0].Waived || results[0].Exemption != "bastion_ssh" {
+		t.Errorf("result == %+v expected waived by bastion_ssh", results[0])
+	}
+
+	for _, invalid := range [][]string{{`"2099-12-31"`, `"next year"`}, {`approver = "security@company.com"`, ``}} {
+		if _, err := ParseConfig(strings.Replace(exemptionsConfig, invalid[0], invalid[1], 1)); err == nil {
+			t.Errorf("an exemption with %s replaced by '%s' should be rejected", invalid[0], invalid[1])
+		}
+	}
+	if (Exemption{Name: "all", Reason: "none", Approver: "nobody", Expires: "2099-12-31"}).Validate() == nil {
+		t.Error("an exemption without criteria should be rejected")
+	}
+}
+
+func TestResourcePolicies(t *testing.T) {
+
+	config, err := ParseConfig(resourceConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the resource block applies to any API call on the instances, the api_call block only to its own
+	_, apicalls := config.GetAPICallConfigs("ModifyInstanceAttribute", "", "", "ec2", "ec2_instance")
+	if len(apicalls) != 1 || apicalls[0].Name != "ec2_instance" {
+		t.Errorf("api calls == %+v expected the ec2_instance resource block", apicalls)
+	}
+	_, apicalls = config.GetAPICallConfigs("RunInstances", "", "", "ec2", "ec2_instance")
+	if len(apicalls) != 2 {
+		t.Errorf("api calls == %+v expected the RunInstances and ec2_instance blocks", apicalls)
+	}
+	_, apicalls = config.GetAPICallConfigs("CreateVolume", "", "", "ec2", "ec2_volume")
+	if len(apicalls) != 0 {
+		t.Errorf("api calls == %+v expected none for the volumes", apicalls)
+	}
+	policies, apicalls := config.GetAPICallConfigs("CreateAccessKey", "", "", "iam", "iam_user")
+	if len(apicalls) != 1 || policies[0].Name != "users" || config.GetCompliancePolicy("users") == nil {
+		t.Errorf("api calls == %+v expected the iam_user resource block", apicalls)
+	}
+	// the resource blocks are only found by their type of resource, not by an API call of the same name
+	_, apicalls = config.GetAPICallConfigs("ec2_instance", "", "", "ec2", "")
+	if len(apicalls) != 0 {
+		t.Errorf("api calls == %+v expected no block for the API call ec2_instance", apicalls)
+	}
+
+	check := config.EC2Policy[0].Resource[0].Compliant[0]
+	if check.Schema != "^[a-z]+$" || check.PolicyName != "instances" || check.Severity != "high" {
+		t.Errorf("check == %+v expected the settings of the rule and the defaults", check)
+	}
+	results := config.EC2Policy[0].Resource[0].CheckCompliance(func(key string) []string {
+		return nil
+	}, "i-0011aabb", EventUserInfo{})
+	if len(results) != 1 || results[0].EventType != "ec2_instance" || results[0].IsCompliant {
+		t.Errorf("results == %+v expected a non-compliant ec2_instance result", results)
+	}
+
+	IsResourceType = func(policyType string, name string) bool {
+		return (policyType == "ec2" && name == "ec2_instance") || (policyType == "iam" && name == "iam_user")
+	}
+	defer func() {
+		IsResourceType = nil
+	}()
+	if _, err := ParseConfig(resourceConfig); err != nil {
+		t.Error(err)
+	}
+	_, err = parseConfig("resource.cfg", strings.Replace(resourceConfig, `resource "ec2_instance"`, `resource "security_group"`, 1))
+	if err == nil || !strings.Contains(err.Error(), "resource.cfg:9:3: Resource: security_group is not a type of resource checked by a ec2_policy") {
+		t.Errorf("err == %v expected the security group resource block to be rejected", err)
+	}
+}
+
+func TestSecrets(t *testing.T) {
+
+	file, err := ioutil.TempFile("", "arebot-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("ldap-pass\n")
+	file.Close()
+	os.Setenv("AREBOT_TEST_SECRET_KEY", "aws-secret")
+	defer os.Unsetenv("AREBOT_TEST_SECRET_KEY")
+
+	hclText := strings.Replace(secretsConfig, "/path/to/secret", file.Name(), 1)
+	var logged bytes.Buffer
+	Log.Out = &logged
+	config, err := ParseConfig(hclText)
+	Log.Out = os.Stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.AccessKey != "AKIAEXAMPLE" || config.SecretKey != "aws-secret" || config.LdapConfig.BindPassword != "ldap-pass" {
+		t.Errorf("secrets == %q, %q, %q expected the settings and the values they refer to", config.AccessKey, config.SecretKey, config.LdapConfig.BindPassword)
+	}
+
+	// the secrets never reach the log output
+	for _, text := range []string{config.String(), fmt.Sprintf("%v", config), logged.String()} {
+		for _, secret := range []string{"AKIAEXAMPLE", "aws-secret", "api-token", "ldap-pass", "AREBOT_TEST_SECRET_KEY"} {
+			if strings.Contains(text, secret) {
+				t.Errorf("%q should not contain %s", text, secret)
+			}
+		}
+	}
+	if !strings.Contains(config.String(), "ldap.company.com") || config.LdapConfig.BindPassword != "ldap-pass" {
+		t.Error("only the secrets should be redacted, on a copy of the configuration")
+	}
+
+	_, err = parseConfig("secrets.cfg", strings.Replace(hclText, "AREBOT_TEST_SECRET_KEY", "AREBOT_TEST_UNSET", 1))
+	if err == nil || !strings.Contains(err.Error(), "secrets.cfg:3:1: the environment variable AREBOT_TEST_UNSET is not set") {
+		t.Errorf("err == %v expected the unset variable", err)
+	}
+}
+
 func TestComplianceMissing(t *testing.T) {
 
 	config, err := ParseConfig(missingCompliance)
@@ -252,6 +911,33 @@ account "account-prod" {
 }
 `
 
+const workerPoolConfig = `
+account "account-dev" {
+  account_id = "222222222222"
+  region = "eu-central-1"
+  arebot_role_arn = "arn:aws:iam::222222222222:role/arebot"
+  all_events_queue = "all_events"
+  receivers = 2
+}
+worker_config {
+  workers = 4
+  receivers = 3
+}
+`
+
+const regionsConfig = `
+account "account-regions" {
+  account_id = "333333333333"
+  region = "eu-central-1"
+  regions = ["eu-central-1", "eu-west-1"]
+  arebot_role_arn = "arn:aws:iam::333333333333:role/arebot"
+  all_events_queue = "all_events"
+  region_queue "eu-west-1" {
+    all_events_queue = "all_events"
+  }
+}
+`
+
 const additionalSettings = `
 arebot_config {
   region = "eu-west-1"
@@ -408,3 +1094,298 @@ security_group_policy "XXX" {
   }
   action "doNothing" {}
 }`
+
+const lintConfig = `
+security_group_policy "lint" {
+  vpc = "vpc-(1234"
+
+  api_call "CreateSecurityGroup" {
+    compliant "GroupName" {
+      schema = "[A-Z"
+      policy_name = "other"
+      actions = [ "later" ]
+    }
+  }
+  api_call "CreateSecurityGroups" {
+    compliant "groupName" {
+      schema = ".*"
+      actions = [ "notify" ]
+    }
+  }
+
+  action "later" {
+    condition "after_one_day" {
+      type = "start_after"
+      value = "1 day"
+    }
+  }
+  action "notify" {}
+  action "unused" {}
+
+  action_trigger "daily" {
+    schedule = "every day"
+    action = [ "notify" ]
+  }
+}
+`
+
+const definitionsConfig = `
+variable "admins" {
+  value = "admins@example.com"
+}
+
+rule "project" {
+  schema = "^Proj-[0-9]{3}$"
+  mandatory = true
+  actions = [ "notify" ]
+  condition "from_rule" {
+    type = "tag_key_not_exists"
+    value = "Ignore"
+  }
+}
+
+action "notify" {
+  email { receiver = [ "${var.admins}" ] }
+}
+
+security_group_policy "sg_policy" {
+  api_call "CreateSecurityGroup" {
+    compliant "Tag.ProjectName" {
+      rule = "project"
+      condition "from_check" {
+        type = "tag_key_not_exists"
+        value = "Skip"
+      }
+    }
+    compliant "Tag.Name" {
+      rule = "project"
+      schema = "^[a-z]+$"
+      mandatory = false
+      actions = [ "ignore" ]
+    }
+  }
+
+  action "ignore" {}
+}
+
+ec2_policy "ec2_policy" {
+  api_call "RunInstances" {
+    compliant "Tag.ProjectName" {
+      rule = "project"
+    }
+  }
+
+  action "notify" {
+    email { receiver = [ "${var.admins}" ] }
+    condition "stop_reminders" {
+      type = "stop_after"
+      value = "10 days"
+    }
+  }
+}
+`
+
+const operatorsConfig = `
+ec2_policy "volumes" {
+  api_call "CreateVolume" {
+    compliant "Size" {
+      max = 500
+    }
+    compliant "Iops" {
+      min = 100
+      max = 3000
+    }
+    compliant "VolumeType" {
+      in = [ "gp2", "gp3" ]
+      ignore_case = true
+    }
+    compliant "AvailabilityZone" {
+      schema = "^eu-"
+      not_in = [ "eu-west-3a" ]
+    }
+    compliant "Status" {
+      equals = "available"
+      ignore_case = true
+    }
+    compliant "CreateTime" {
+      newer_than = "1 day"
+    }
+    compliant "Tag.Backup" {
+      older_than = "90 days"
+    }
+  }
+}
+`
+
+const permissionsConfig = `
+security_group_policy "ingress" {
+  api_call "AuthorizeSecurityGroupIngress" {
+    compliant "IpPermissions.IpRanges" {
+      allowed_networks = [ "10.0.0.0/8" ]
+      deny_world = true
+      forbidden_ports = [ "22" ]
+    }
+  }
+}
+`
+
+const expressionConfig = `
+ec2_policy "naming" {
+  api_call "RunInstances" {
+    compliant "Tag.Name" {
+      expression = "Tag.Name.startsWith(Tag.ProjectName)"
+      mandatory = false
+    }
+  }
+}
+`
+
+const conditionsConfig = `
+account "prod" {
+  account_id = "111122223333"
+}
+
+ec2_policy "instances" {
+  api_call "RunInstances" {
+    compliant "InstanceType" {
+      schema = "^m5\\.(large|xlarge)$"
+      condition "m5" {
+        type = "property_matches"
+        property = "InstanceType"
+        value = "^m5\\."
+      }
+      condition "production" {
+        type = "account_in"
+        values = [ "prod", "444455556666" ]
+      }
+      condition "europe" {
+        type = "region_in"
+        values = [ "eu-central-1", "eu-west-1" ]
+      }
+      condition "developers" {
+        type = "event_user_matches"
+        value = "^arn:aws:iam::[0-9]+:user/|^jenkins$"
+      }
+      condition "business_hours" {
+        type = "time_window"
+        value = "Mon-Fri 08:00-18:00"
+      }
+      condition "night" {
+        type = "time_window"
+        value = "22:00-06:00 UTC"
+      }
+      condition "not_weekend" {
+        type = "NOT"
+        condition "weekend" {
+          type = "time_window"
+          value = "Sat,Sun 00:00-24:00"
+        }
+      }
+      condition "combined" {
+        type = "OR"
+        condition "eu" {
+          type = "region_in"
+          values = [ "eu-central-1" ]
+        }
+        condition "not_production" {
+          type = "NOT"
+          condition "production" {
+            type = "account_in"
+            values = [ "prod", "444455556666" ]
+          }
+        }
+      }
+    }
+  }
+}
+`
+
+const severityConfig = `
+rule "no_world_ssh" {
+  deny_world = true
+  forbidden_ports = [ "22" ]
+  severity = "critical"
+  category = "network"
+  controls = [ "CIS 4.1", "ISO 27001 A.13.1.1" ]
+  actions = [ "page", "notify" ]
+}
+
+security_group_policy "sg" {
+  api_call "AuthorizeSecurityGroupIngress" {
+    compliant "IpPermissions.IpRanges" {
+      rule = "no_world_ssh"
+    }
+    compliant "Tag.Owner" {
+      mandatory = true
+      category = "tagging"
+      actions = [ "notify" ]
+    }
+  }
+  action "page" {
+    min_severity = "high"
+    email { receiver = [ "oncall@company.com" ] }
+  }
+  action "notify" {
+    email { receiver = [ "admins@company.com" ] }
+  }
+}
+`
+
+const exemptionsConfig = `
+account "prod" {
+  account_id = "111122223333"
+}
+
+exemption "bastion_ssh" {
+  tag_key = "Approved"
+  accounts = [ "prod" ]
+  policy = "sg"
+  checks = [ "IpPermissions.IpRanges" ]
+  reason = "SSH bastion of the VPC"
+  approver = "security@company.com"
+  expires = "2099-12-31"
+}
+`
+
+const resourceConfig = `
+rule "owner" {
+  schema = "^[a-z]+$"
+  mandatory = true
+  severity = "high"
+}
+
+ec2_policy "instances" {
+  resource "ec2_instance" {
+    compliant "Tag.Owner" {
+      rule = "owner"
+    }
+  }
+  api_call "RunInstances" {
+    compliant "Tag.Name" {
+      mandatory = true
+    }
+  }
+}
+
+iam_policy "users" {
+  resource "iam_user" {
+    compliant "MFADevices" {
+      mandatory = true
+    }
+  }
+}
+`
+
+const secretsConfig = `
+access_key = "AKIAEXAMPLE"
+secret_key = "env:AREBOT_TEST_SECRET_KEY"
+api_token = <<EOF
+api-token
+EOF
+
+ldap_config {
+  ldap_host = "ldap.company.com"
+  bind_username = "cn=arebot"
+  bind_password = "file:/path/to/secret"
+}
+`
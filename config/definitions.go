@@ -0,0 +1,181 @@
+package config
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+var variableReference = regexp.MustCompile(`\$\{var\.([A-Za-z0-9_-]+)\}`)
+
+// definitions shared by the compliance policies
+type definitions struct {
+	variables map[string]string
+	rules     map[string]Rule
+	actions   map[string]Action
+	pos       positions
+	errs      *multierror.Error
+}
+
+// integrateDefinitions expands the variables in the settings of the policies, rules and shared actions. Then
+// it completes the compliant blocks with their rule and adds to each policy the shared actions it uses.
+func integrateDefinitions(config *Config, pos positions) error {
+	defs := definitions{variables: map[string]string{}, rules: map[string]Rule{}, actions: map[string]Action{}, pos: pos}
+	for _, variable := range config.Variable {
+		defs.variables[variable.Name] = variable.Value
+	}
+	for i := range config.Rule {
+		defs.expand([]string{"rule", config.Rule[i].Name}, &config.Rule[i])
+		defs.rules[config.Rule[i].Name] = config.Rule[i]
+	}
+	for i := range config.Action {
+		defs.expand([]string{"action", config.Action[i].Name}, &config.Action[i])
+		config.Action[i].Shared = true
+		defs.actions[config.Action[i].Name] = config.Action[i]
+	}
+
+	defs.integratePolicies(config.SecurityGroupPolicy, "security_group_policy")
+	defs.integratePolicies(config.EC2Policy, "ec2_policy")
+	defs.integratePolicies(config.S3Policy, "s3_policy")
+	defs.integratePolicies(config.IAMPolicy, "iam_policy")
+
+	return defs.errs.ErrorOrNil()
+}
+
+func (defs *definitions) integratePolicies(cPolicies []CompliancePolicy, policyType string) {
+	for i := range cPolicies {
+		policy := &cPolicies[i]
+		defs.expand([]string{policyType, policy.Name}, policy)
+
+		var usedActions []string
+		for _, apicall := range policy.checkBlocks() {
+			for k := range apicall.Compliant {
+				check := &apicall.Compliant[k]
+				if check.Rule != "" {
+					compliantPath := []string{policyType, policy.Name, apicall.kind, apicall.Name, "compliant", check.Name}
+					rule, ok := defs.rules[check.Rule]
+					if !ok {
+						defs.errs = multierror.Append(defs.errs, defs.pos.errorf(append(compliantPath, "rule"),
+							"CompliantCheck: %s refers to the undefined rule %s.", check.Name, check.Rule))
+						continue
+					}
+					rule.applyTo(check, func(setting string) bool {
+						_, ok := defs.pos[strings.Join(append(compliantPath, setting), "/")]
+						return ok
+					})
+				}
+				usedActions = append(usedActions, check.Actions...)
+			}
+		}
+		for _, trigger := range policy.ActionTrigger {
+			usedActions = append(usedActions, trigger.Action...)
+		}
+
+		// the actions of the policy take precedence over the shared ones with the same name
+		for _, name := range usedActions {
+			action, ok := defs.actions[name]
+			if ok && policy.getAction(name) == nil {
+				action.Condition = append([]TimeCondition{}, action.Condition...)
+				policy.Action = append(policy.Action, action)
+			}
+		}
+	}
+}
+
+// expand replaces the variable references in the strings of v, a pointer to a struct
+func (defs *definitions) expand(path []string, v interface{}) {
+	var undefined []string
+	expandVariables(reflect.ValueOf(v), defs.variables, &undefined)
+	for _, name := range undefined {
+		defs.errs = multierror.Append(defs.errs, defs.pos.errorf(path, "Variable: %s is not defined.", name))
+	}
+}
+
+func expandVariables(v reflect.Value, variables map[string]string, undefined *[]string) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		expandVariables(v.Elem(), variables, undefined)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				expandVariables(v.Field(i), variables, undefined)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			expandVariables(v.Index(i), variables, undefined)
+		}
+	case reflect.String:
+		v.SetString(variableReference.ReplaceAllStringFunc(v.String(), func(ref string) string {
+			name := variableReference.FindStringSubmatch(ref)[1]
+			value, ok := variables[name]
+			if !ok && !ContainsString(*undefined, name) {
+				*undefined = append(*undefined, name)
+			}
+			return value
+		}))
+	}
+}
+
+// applyTo completes the settings of the compliant block with the ones of the rule. isSet tells
+// whether a setting is in the block, since a false or empty value may be set on purpose.
+func (r Rule) applyTo(check *CompliantCheck, isSet func(setting string) bool) {
+	if !isSet("schema") {
+		check.Schema = r.Schema
+	}
+	if !isSet("description") {
+		check.Description = r.Description
+	}
+	if !isSet("actions") {
+		check.Actions = r.Actions
+	}
+	if !isSet("negate") {
+		check.Negate = r.Negate
+	}
+	if !isSet("mandatory") {
+		check.Mandatory = r.Mandatory
+	}
+	if !isSet("expression") {
+		check.Expression = r.Expression
+	}
+	if !isSet("severity") {
+		check.Severity = r.Severity
+	}
+	if !isSet("category") {
+		check.Category = r.Category
+	}
+	if !isSet("controls") {
+		check.Controls = r.Controls
+	}
+	r.Operators.applyTo(&check.Operators, isSet)
+	r.PermissionChecks.applyTo(&check.PermissionChecks, isSet)
+	check.Condition = append(append([]Condition{}, r.Condition...), check.Condition...)
+}
+
+// getAction returns the action of the policy with the given name
+func (cp CompliancePolicy) getAction(name string) *Action {
+	for i := range cp.Action {
+		if cp.Action[i].Name == name {
+			return &cp.Action[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package config
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"time"
+)
+
+// layouts of the expiry of the exemptions: a date (the exemption expires at its end, UTC) or a time
+var expiryLayouts = []string{"2006-01-02", time.RFC3339}
+
+// Exemption waives the non-compliant results of the resources it matches until it expires. It is defined
+// by an exemption block of the configuration or managed through the HTTP API. A result is matched if it
+// satisfies all the criteria that are set.
+type Exemption struct {
+	Name        string   `hcl:",key" json:"name"`
+	ResourceIds []string `hcl:"resource_ids" json:"resource_ids,omitempty"`
+	// the tag of the resource; any value if tag_value is not set
+	TagKey   string `hcl:"tag_key" json:"tag_key,omitempty"`
+	TagValue string `hcl:"tag_value" json:"tag_value,omitempty"`
+	// the account IDs or names of the results
+	Accounts []string `hcl:"accounts" json:"accounts,omitempty"`
+	// the policy and the names of the checks, e.g. "IpPermissions.IpRanges"
+	Policy string   `hcl:"policy" json:"policy,omitempty"`
+	Checks []string `hcl:"checks" json:"checks,omitempty"`
+	// why and by whom the exemption has been granted, and until when, e.g. "2017-12-31"
+	Reason   string `hcl:"reason" json:"reason"`
+	Approver string `hcl:"approver" json:"approver"`
+	Expires  string `hcl:"expires" json:"expires"`
+}
+
+// ExpiresAt returns the time the exemption expires, or an error if the expiry is invalid
+func (e Exemption) ExpiresAt() (time.Time, error) {
+	for _, layout := range expiryLayouts {
+		if t, err := time.Parse(layout, e.Expires); err == nil {
+			if layout == expiryLayouts[0] {
+				t = t.Add(24 * time.Hour)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Exemption: %s has an invalid expiry '%s' (e.g. 2017-12-31 or 2017-12-31T18:00:00Z).", e.Name, e.Expires)
+}
+
+// IsActive returns true if the exemption has not expired at the given time
+func (e Exemption) IsActive(now time.Time) bool {
+	expiry, err := e.ExpiresAt()
+	return err == nil && now.Before(expiry)
+}
+
+// Validate returns an error if the exemption has no criteria, no reason, no approver or an invalid expiry
+func (e Exemption) Validate() error {
+	switch {
+	case e.Name == "":
+		return fmt.Errorf("Exemption: the name is missing.")
+	case len(e.ResourceIds) == 0 && e.TagKey == "" && len(e.Accounts) == 0 && e.Policy == "" && len(e.Checks) == 0:
+		return fmt.Errorf("Exemption: %s matches every resource. Set resource_ids, tag_key, accounts, policy or checks.", e.Name)
+	case e.TagValue != "" && e.TagKey == "":
+		return fmt.Errorf("Exemption: %s has a tag_value without tag_key.", e.Name)
+	case e.Reason == "" || e.Approver == "":
+		return fmt.Errorf("Exemption: %s must have a reason and an approver.", e.Name)
+	}
+	_, err := e.ExpiresAt()
+	return err
+}
+
+// matches returns true if the result satisfies the criteria of the exemption
+func (e Exemption) matches(result CompliantCheckResult, getResourceProperties func(string) []string, accountIds []string) bool {
+	if len(e.ResourceIds) > 0 && !ContainsString(e.ResourceIds, result.ResourceId) {
+		return false
+	}
+	if e.TagKey != "" {
+		values := getResourceProperties("Tag." + e.TagKey)
+		if len(values) == 0 || (e.TagValue != "" && !ContainsString(values, e.TagValue)) {
+			return false
+		}
+	}
+	if len(accountIds) > 0 && !ContainsString(accountIds, result.EventUser.AccountId) {
+		return false
+	}
+	if e.Policy != "" && e.Policy != result.Check.PolicyName {
+		return false
+	}
+	return len(e.Checks) == 0 || ContainsString(e.Checks, result.Check.Name)
+}
+
+// WaiveExemptedResults marks the non-compliant results matched by an active exemption as waived. The exemptions
+// are the ones of the configuration and the given ones, e.g. managed through the HTTP API.
+func (cfg Config) WaiveExemptedResults(results []CompliantCheckResult, getResourceProperties func(string) []string, exemptions []Exemption) []CompliantCheckResult {
+	exemptions = append(append([]Exemption{}, cfg.Exemption...), exemptions...)
+	now := time.Now()
+	for i := range results {
+		if results[i].IsCompliant {
+			continue
+		}
+		for _, e := range exemptions {
+			if !e.IsActive(now) {
+				continue
+			}
+			// the accounts may be named after the account blocks
+			var accountIds []string
+			for _, account := range e.Accounts {
+				if a := cfg.getAccountByName(account); a != nil {
+					account = a.AccountID
+				}
+				accountIds = append(accountIds, account)
+			}
+			if e.matches(results[i], getResourceProperties, accountIds) {
+				Log.Infof("Non-compliant %s of %s waived by the exemption %s (approved by %s until %s: %s)", results[i].Check.Name,
+					results[i].ResourceId, e.Name, e.Approver, e.Expires, e.Reason)
+				results[i].Waived, results[i].Exemption = true, e.Name
+				break
+			}
+		}
+	}
+	return results
+}
+
+func (cfg Config) getAccountByName(name string) *Account {
+	for i := range cfg.Account {
+		if cfg.Account[i].Name == name {
+			return &cfg.Account[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,723 @@
+package config
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// An expression checks several properties of a resource at once, e.g.
+//
+//	!InstanceType.matches('^x1\.') || has(Tag.CostApproval)
+//	Tag.Name.startsWith(Tag.ProjectName)
+//
+// The identifiers are the property keys of GetProperties; prop('Tag.cost-center') reads the keys that are not
+// identifiers. A property evaluates to null if it is missing, to its value, or to the list of its values.
+// The language has no loops and no side effects: the literals (strings in single or double quotes, numbers,
+// true, false, null and lists), the operators ! && || == != < <= > >= in and ?:, and the functions below.
+// The string functions can also be called as methods, and they are true if any value of a list satisfies them.
+var expressionFunctions = map[string]int{
+	"has": 1, "size": 1, "lower": 1, "matches": 2, "startsWith": 2, "endsWith": 2, "contains": 2,
+}
+
+var expressionMethods = []string{"size", "lower", "matches", "startsWith", "endsWith", "contains"}
+
+// expression is a parsed expression with the property keys it reads
+type expression struct {
+	root       exprNode
+	properties []string
+}
+
+type exprNode interface {
+	eval(props func(string) []string) (interface{}, error)
+}
+
+type (
+	exprLiteral struct{ value interface{} }
+	exprList    struct{ items []exprNode }
+	exprProp    struct{ key string }
+	exprUnary   struct {
+		op string
+		x  exprNode
+	}
+	exprBinary struct {
+		op   string
+		x, y exprNode
+	}
+	exprCond struct{ cond, then, otherwise exprNode }
+	exprCall struct {
+		name string
+		args []exprNode
+	}
+)
+
+// IsCompliantExpression returns true, nil if the resource satisfies the expression of the check,
+// false, nil if it does not, false, error if the expression cannot be evaluated
+func (c CompliantCheck) IsCompliantExpression(getResourceProperties func(string) []string) (bool, error) {
+	expr, err := parseExpression(c.Expression)
+	if err != nil {
+		return false, err
+	}
+	compliant, err := expr.evalBool(getResourceProperties)
+	if err != nil {
+		return false, err
+	}
+	if c.Negate {
+		compliant = !compliant
+	}
+	Log.Debugf("Compliance check for %s - %s is compliant: %t", c.Name, c.Expression, compliant)
+	return compliant, nil
+}
+
+// validateExpression returns the first mistake of the expression of the check, whose properties are
+// the ones of propertyScope, the type of policy or of resource
+func validateExpression(c CompliantCheck, propertyScope string, policyType string) error {
+	expr, err := parseExpression(c.Expression)
+	if err != nil {
+		return fmt.Errorf("CompliantCheck: %s has an invalid expression: %s.", c.Name, err)
+	}
+	if c.Schema != "" || c.Operators.isSet() || c.PermissionChecks.isSet() || c.Mandatory {
+		return fmt.Errorf("CompliantCheck: %s: the expression cannot be combined with a schema, operators, permission checks or mandatory (see has()).", c.Name)
+	}
+	for _, key := range expr.properties {
+		if IsSupportedProperty != nil && !IsSupportedProperty(propertyScope, key) {
+			return fmt.Errorf("CompliantCheck: %s: the expression reads %s, which is not a property of the resources checked by a %s.", c.Name, key, policyType)
+		}
+	}
+	return nil
+}
+
+func (e *expression) evalBool(props func(string) []string) (bool, error) {
+	value, err := e.root.eval(props)
+	if err != nil {
+		return false, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("the expression is %s, not a boolean", describeValue(value))
+	}
+	return b, nil
+}
+
+// *** parser
+
+type exprToken struct {
+	kind string // "ident", "string", "number" or the operator itself; "" at the end
+	text string
+	pos  int
+}
+
+type exprParser struct {
+	tokens []exprToken
+	next   int
+	expr   *expression
+}
+
+// parseExpression parses the text of an expression and checks its functions and regular expressions
+func parseExpression(text string) (*expression, error) {
+	tokens, err := scanExpression(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, expr: &expression{}}
+	root, err := p.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "" {
+		return nil, p.unexpected(t)
+	}
+	p.expr.root = root
+	return p.expr, nil
+}
+
+var exprOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "?", ":", "(", ")", "[", "]", ",", ".", "-"}
+
+func scanExpression(text string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(text); {
+		c := rune(text[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			// a backslash escapes the quote and the backslash, other backslashes are kept for the regular expressions
+			var value []byte
+			j := i + 1
+			for ; j < len(text) && rune(text[j]) != c; j++ {
+				if text[j] == '\\' && j+1 < len(text) && (rune(text[j+1]) == c || text[j+1] == '\\') {
+					j++
+				}
+				value = append(value, text[j])
+			}
+			if j == len(text) {
+				return nil, fmt.Errorf("unterminated string at %d", i+1)
+			}
+			tokens = append(tokens, exprToken{"string", string(value), i})
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(text) && (text[j] >= '0' && text[j] <= '9' || text[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, exprToken{"number", text[i:j], i})
+			i = j
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(text) && (text[j] == '_' || unicode.IsLetter(rune(text[j])) || unicode.IsDigit(rune(text[j]))) {
+				j++
+			}
+			tokens = append(tokens, exprToken{"ident", text[i:j], i})
+			i = j
+		default:
+			op := ""
+			for _, o := range exprOperators {
+				if strings.HasPrefix(text[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected '%c' at %d", c, i+1)
+			}
+			tokens = append(tokens, exprToken{op, op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, exprToken{pos: len(text)}), nil
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.next]
+}
+
+func (p *exprParser) accept(kind string) bool {
+	if p.peek().kind == kind {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(kind string) (exprToken, error) {
+	t := p.peek()
+	if t.kind != kind {
+		return t, p.unexpected(t)
+	}
+	p.next++
+	return t, nil
+}
+
+func (p *exprParser) unexpected(t exprToken) error {
+	if t.kind == "" {
+		return fmt.Errorf("unexpected end of the expression")
+	}
+	return fmt.Errorf("unexpected '%s' at %d", t.text, t.pos+1)
+}
+
+// conditional: or [ "?" conditional ":" conditional ]
+func (p *exprParser) parseConditional() (exprNode, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil || !p.accept("?") {
+		return cond, err
+	}
+	then, err := p.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+	return exprCond{cond, then, otherwise}, nil
+}
+
+// the binary operators by increasing precedence
+var exprPrecedence = [][]string{{"||"}, {"&&"}, {"==", "!=", "<", "<=", ">", ">=", "in"}}
+
+func (p *exprParser) parseBinary(level int) (exprNode, error) {
+	if level == len(exprPrecedence) {
+		return p.parseUnary()
+	}
+	x, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		op := t.kind
+		if t.kind == "ident" && t.text == "in" {
+			op = "in"
+		}
+		if !ContainsString(exprPrecedence[level], op) {
+			return x, nil
+		}
+		p.next++
+		y, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = exprBinary{op, x, y}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	for _, op := range []string{"!", "-"} {
+		if p.accept(op) {
+			x, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return exprUnary{op, x}, nil
+		}
+	}
+	return p.parsePostfix()
+}
+
+// postfix: primary { "." method "(" args ")" }
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(".") {
+		t, err := p.expect("ident")
+		if err != nil {
+			return nil, err
+		}
+		if !ContainsString(expressionMethods, t.text) || p.peek().kind != "(" {
+			return nil, fmt.Errorf("unknown method '%s' at %d", t.text, t.pos+1)
+		}
+		if x, err = p.parseCall(t, x); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.peek()
+	p.next++
+	switch t.kind {
+	case "string":
+		return exprLiteral{t.text}, nil
+	case "number":
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s' at %d", t.text, t.pos+1)
+		}
+		return exprLiteral{n}, nil
+	case "(":
+		x, err := p.parseConditional()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.expect(")")
+		return x, err
+	case "[":
+		var list exprList
+		for !p.accept("]") {
+			if len(list.items) > 0 {
+				if _, err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			item, err := p.parseConditional()
+			if err != nil {
+				return nil, err
+			}
+			list.items = append(list.items, item)
+		}
+		return list, nil
+	case "ident":
+		switch t.text {
+		case "true", "false":
+			return exprLiteral{t.text == "true"}, nil
+		case "null":
+			return exprLiteral{nil}, nil
+		}
+		if p.peek().kind == "(" {
+			return p.parseCall(t, nil)
+		}
+		return p.parseProperty(t)
+	}
+	p.next--
+	return nil, p.unexpected(t)
+}
+
+// parseProperty reads a dotted property key, up to the method called on the property if any
+func (p *exprParser) parseProperty(t exprToken) (exprNode, error) {
+	key := t.text
+	for p.peek().kind == "." && p.tokens[p.next+1].kind == "ident" {
+		name := p.tokens[p.next+1].text
+		if ContainsString(expressionMethods, name) && p.tokens[p.next+2].kind == "(" {
+			break
+		}
+		key += "." + name
+		p.next += 2
+	}
+	return p.property(key), nil
+}
+
+func (p *exprParser) property(key string) exprNode {
+	if !ContainsString(p.expr.properties, key) {
+		p.expr.properties = append(p.expr.properties, key)
+	}
+	return exprProp{key}
+}
+
+// parseCall parses the arguments of a function, or of a method called on receiver
+func (p *exprParser) parseCall(t exprToken, receiver exprNode) (exprNode, error) {
+	p.next++ // (
+	var args []exprNode
+	if receiver != nil {
+		args = append(args, receiver)
+	}
+	for first := true; !p.accept(")"); first = false {
+		if !first {
+			if _, err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseConditional()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	if t.text == "prop" {
+		if key, ok := literalString(args); ok && receiver == nil {
+			return p.property(key), nil
+		}
+		return nil, fmt.Errorf("prop at %d takes a single string literal", t.pos+1)
+	}
+	arity, ok := expressionFunctions[t.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function '%s' at %d", t.text, t.pos+1)
+	}
+	if len(args) != arity {
+		return nil, fmt.Errorf("%s at %d takes %d argument(s), not %d", t.text, t.pos+1, arity, len(args))
+	}
+	if t.text == "matches" {
+		if pattern, ok := literalString(args[1:]); ok {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return nil, fmt.Errorf("invalid pattern at %d: %s", t.pos+1, err)
+			}
+		}
+	}
+	return exprCall{t.text, args}, nil
+}
+
+func literalString(args []exprNode) (string, bool) {
+	if len(args) != 1 {
+		return "", false
+	}
+	literal, ok := args[0].(exprLiteral)
+	if !ok {
+		return "", false
+	}
+	s, ok := literal.value.(string)
+	return s, ok
+}
+
+// *** evaluation
+
+func (n exprLiteral) eval(props func(string) []string) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n exprList) eval(props func(string) []string) (interface{}, error) {
+	var list []interface{}
+	for _, item := range n.items {
+		value, err := item.eval(props)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+	return list, nil
+}
+
+func (n exprProp) eval(props func(string) []string) (interface{}, error) {
+	values := props(n.key)
+	switch len(values) {
+	case 0:
+		return nil, nil
+	case 1:
+		return values[0], nil
+	}
+	var list []interface{}
+	for _, v := range values {
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+func (n exprUnary) eval(props func(string) []string) (interface{}, error) {
+	x, err := n.x.eval(props)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		b, err := toBool(x, n.op)
+		return !b, err
+	}
+	f, ok := toNumber(x)
+	if !ok {
+		return nil, fmt.Errorf("- is applied to %s, not a number", describeValue(x))
+	}
+	return -f, nil
+}
+
+func (n exprBinary) eval(props func(string) []string) (interface{}, error) {
+	x, err := n.x.eval(props)
+	if err != nil {
+		return nil, err
+	}
+	// && and || only evaluate their right operand if needed
+	if n.op == "&&" || n.op == "||" {
+		b, err := toBool(x, n.op)
+		if err != nil || b == (n.op == "||") {
+			return b, err
+		}
+		y, err := n.y.eval(props)
+		if err != nil {
+			return nil, err
+		}
+		return toBool(y, n.op)
+	}
+	y, err := n.y.eval(props)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return valuesEqual(x, y), nil
+	case "!=":
+		return !valuesEqual(x, y), nil
+	case "in":
+		if list, ok := y.([]interface{}); ok {
+			for _, item := range list {
+				if valuesEqual(x, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+		// a property with a single value
+		return y != nil && valuesEqual(x, y), nil
+	}
+	cmp, ok := compareValues(x, y)
+	if !ok {
+		return false, nil
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+func (n exprCond) eval(props func(string) []string) (interface{}, error) {
+	cond, err := n.cond.eval(props)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toBool(cond, "?")
+	if err != nil {
+		return nil, err
+	}
+	if b {
+		return n.then.eval(props)
+	}
+	return n.otherwise.eval(props)
+}
+
+func (n exprCall) eval(props func(string) []string) (interface{}, error) {
+	var args []interface{}
+	for _, arg := range n.args {
+		value, err := arg.eval(props)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, value)
+	}
+
+	switch n.name {
+	case "has":
+		if list, ok := args[0].([]interface{}); ok {
+			return len(list) > 0, nil
+		}
+		return args[0] != nil, nil
+	case "size":
+		switch x := args[0].(type) {
+		case []interface{}:
+			return float64(len(x)), nil
+		case nil:
+			return float64(0), nil
+		default:
+			return float64(len(toString(x))), nil
+		}
+	case "lower":
+		if list, ok := args[0].([]interface{}); ok {
+			var lower []interface{}
+			for _, item := range list {
+				lower = append(lower, strings.ToLower(toString(item)))
+			}
+			return lower, nil
+		}
+		if args[0] == nil {
+			return nil, nil
+		}
+		return strings.ToLower(toString(args[0])), nil
+	}
+
+	// the string functions
+	var test func(s string) bool
+	arg := toString(args[1])
+	switch n.name {
+	case "matches":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %s", arg, err)
+		}
+		test = re.MatchString
+	case "startsWith":
+		test = func(s string) bool { return strings.HasPrefix(s, arg) }
+	case "endsWith":
+		test = func(s string) bool { return strings.HasSuffix(s, arg) }
+	case "contains":
+		test = func(s string) bool { return strings.Contains(s, arg) }
+	}
+	if args[1] == nil {
+		return false, nil
+	}
+	switch x := args[0].(type) {
+	case nil:
+		return false, nil
+	case []interface{}:
+		for _, item := range x {
+			if test(toString(item)) {
+				return true, nil
+			}
+		}
+		return false, nil
+	default:
+		return test(toString(x)), nil
+	}
+}
+
+// *** values
+
+func toBool(value interface{}, op string) (bool, error) {
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s is applied to %s, not a boolean", op, describeValue(value))
+	}
+	return b, nil
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch x := value.(type) {
+	case float64:
+		return x, true
+	case string:
+		f, err := strconv.ParseFloat(x, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toString(value interface{}) string {
+	switch x := value.(type) {
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// valuesEqual compares the numbers numerically (the property values are strings), and the other values as strings
+func valuesEqual(x, y interface{}) bool {
+	if x == nil || y == nil {
+		return x == nil && y == nil
+	}
+	if _, ok := x.([]interface{}); ok {
+		return false
+	}
+	if _, ok := y.([]interface{}); ok {
+		return false
+	}
+	_, xNumber := x.(float64)
+	_, yNumber := y.(float64)
+	if xNumber || yNumber {
+		fx, okx := toNumber(x)
+		fy, oky := toNumber(y)
+		return okx && oky && fx == fy
+	}
+	return toString(x) == toString(y)
+}
+
+// compareValues compares numbers, or strings if either of them is not a number (e.g. RFC 3339 times).
+// Null and the lists cannot be compared.
+func compareValues(x, y interface{}) (int, bool) {
+	if fx, ok := toNumber(x); ok {
+		if fy, ok := toNumber(y); ok {
+			switch {
+			case fx < fy:
+				return -1, true
+			case fx > fy:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	sx, okx := x.(string)
+	sy, oky := y.(string)
+	if !okx || !oky {
+		return 0, false
+	}
+	return strings.Compare(sx, sy), true
+}
+
+func describeValue(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case []interface{}:
+		return "a list"
+	case string:
+		return fmt.Sprintf("the string '%s'", value)
+	}
+	return toString(value)
+}
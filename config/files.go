@@ -0,0 +1,192 @@
+package config
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/token"
+)
+
+// ConfigFiles returns the config files at the given path: the file itself, the .cfg files of the
+// directory or the files matching the glob pattern, sorted by name.
+func ConfigFiles(path string) ([]string, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, "*.cfg")
+	}
+	files, err := filepath.Glob(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No config file found at %s", path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// configLoader merges the config files and the files they include into a single HCL tree
+type configLoader struct {
+	items  []*ast.ObjectItem
+	pos    positions
+	files  []string
+	loaded map[string]bool
+	errs   *multierror.Error
+}
+
+func newConfigLoader() *configLoader {
+	return &configLoader{pos: positions{}, loaded: map[string]bool{}}
+}
+
+// loadFiles loads the config files at the given path (see ConfigFiles)
+func (l *configLoader) loadFiles(path string) error {
+	files, err := ConfigFiles(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := l.loadFile(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadFile loads a config file, unless it has already been loaded
+func (l *configLoader) loadFile(filename string) error {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	if l.loaded[abs] {
+		return nil
+	}
+	l.loaded[abs] = true
+
+	hclText, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return l.parse(filename, string(hclText))
+}
+
+// parse adds the items of the HCL text to the tree. The include directives are replaced by the items of the
+// included files, found relative to the directory of the file.
+func (l *configLoader) parse(filename string, hclText string) error {
+	// the HCL text is not logged, it holds the secrets: decode logs the configuration without them
+	if filename != "" {
+		Log.Debugf("Parsing config file: %s", filename)
+		l.files = append(l.files, filename)
+	}
+
+	hclParseTree, err := hcl.Parse(hclText)
+	if err != nil {
+		return fileError(filename, err)
+	}
+	list, ok := hclParseTree.Node.(*ast.ObjectList)
+	if !ok {
+		return fileError(filename, fmt.Errorf("root should be an object"))
+	}
+
+	var items []*ast.ObjectItem
+	for _, item := range list.Items {
+		if len(item.Keys) == 1 && item.Keys[0].Token.Text == "include" {
+			patterns, err := includePatterns(item)
+			if err != nil {
+				return fileError(filename, err)
+			}
+			for _, pattern := range patterns {
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(filepath.Dir(filename), pattern)
+				}
+				if err := l.loadFiles(pattern); err != nil {
+					return NewConfigError(position(filename, item), err.Error())
+				}
+			}
+			continue
+		}
+		items = append(items, item)
+	}
+
+	for _, err := range l.pos.index(filename, "", &ast.ObjectList{Items: items}) {
+		l.errs = multierror.Append(l.errs, err)
+	}
+	l.items = append(l.items, items...)
+	return nil
+}
+
+// decode returns the Config of the merged files, validated
+func (l *configLoader) decode() (*Config, error) {
+	if err := l.errs.ErrorOrNil(); err != nil {
+		return nil, err
+	}
+	result := &Config{}
+	if err := hcl.DecodeObject(&result, &ast.File{Node: &ast.ObjectList{Items: l.items}}); err != nil {
+		return nil, err
+	}
+	result.Files = l.files
+
+	if err := integrateConfigSettings(result, l.pos); err != nil {
+		return nil, err
+	}
+	if err := validateConfigSettings(result, l.pos); err != nil {
+		return nil, err
+	}
+
+	Log.Infof("Starting with config %v", result)
+
+	return result, nil
+}
+
+// includePatterns returns the files of an include directive: a string or a list of strings
+func includePatterns(item *ast.ObjectItem) ([]string, error) {
+	values := []ast.Node{item.Val}
+	if list, ok := item.Val.(*ast.ListType); ok {
+		values = list.List
+	}
+	var patterns []string
+	for _, value := range values {
+		lit, ok := value.(*ast.LiteralType)
+		if !ok || lit.Token.Type != token.STRING {
+			return nil, fmt.Errorf("At %s: include expects a file name or a list of file names", value.Pos())
+		}
+		patterns = append(patterns, lit.Token.Value().(string))
+	}
+	return patterns, nil
+}
+
+// position returns the position of the item in the file
+func position(filename string, item *ast.ObjectItem) token.Pos {
+	p := item.Pos()
+	p.Filename = filename
+	return p
+}
+
+// fileError adds the name of the config file to the HCL errors, which only report the line
+func fileError(filename string, err error) error {
+	if filename == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %s", filename, err)
+}
@@ -0,0 +1,118 @@
+package config
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/token"
+)
+
+// The policies are checked against the API calls and the resource properties known to AreBOT.
+// These functions are set by the cloudwatch package, from its registry; the checks are skipped
+// while they are not set.
+var (
+	// IsHandledAPICall returns true if AreBOT handles the events of the API call
+	IsHandledAPICall func(apiCall string) bool
+	// IsSupportedProperty returns true if the property key is supported by the resources of the
+	// policy type ("security_group", "ec2", "s3", "iam")
+	IsSupportedProperty func(policyType string, key string) bool
+	// IsResourceType returns true if the name is a type of resource checked by the policies of the policy type
+	IsResourceType func(policyType string, name string) bool
+)
+
+// ConfigError is a mistake in the configuration, at the given position of the configuration file
+type ConfigError struct {
+	Pos token.Pos
+	msg string
+}
+
+func (e ConfigError) Error() string {
+	if !e.Pos.IsValid() {
+		return e.msg
+	}
+	return fmt.Sprintf("%s: %s", e.Pos, e.msg)
+}
+
+// NewConfigError create new ConfigError
+func NewConfigError(pos token.Pos, msg string) ConfigError {
+	return ConfigError{Pos: pos, msg: msg}
+}
+
+// positions of the blocks and attributes of a configuration file, by path: the keys of the
+// enclosing blocks joined with "/", e.g. "ec2_policy/ec2_policy_1/action_trigger/daily/schedule"
+type positions map[string]token.Pos
+
+// index adds the positions of the items of a file. It returns an error for each top-level setting, policy,
+// account or action defined twice, in the same file or in another one.
+func (pos positions) index(filename string, parent string, list *ast.ObjectList) []error {
+	var errs []error
+	for _, item := range list.Items {
+		path := parent
+		var keys []string
+		for _, key := range item.Keys {
+			name := key.Token.Text
+			if key.Token.Type == token.STRING {
+				name = key.Token.Value().(string)
+			}
+			if path != "" {
+				path += "/"
+			}
+			path += name
+			keys = append(keys, key.Token.Text)
+		}
+		p := position(filename, item)
+		if first, dup := pos[path]; dup {
+			if parent == "" || (len(keys) > 1 && keys[0] == "action") {
+				err := NewConfigError(p, fmt.Sprintf("%s is already defined at %s.", strings.Join(keys, " "), first))
+				Log.Error(err.Error())
+				errs = append(errs, err)
+				continue
+			}
+		} else {
+			pos[path] = p
+		}
+		if obj, ok := item.Val.(*ast.ObjectType); ok {
+			errs = append(errs, pos.index(filename, path, obj.List)...)
+		}
+	}
+	return errs
+}
+
+// at returns the position of the block or attribute, or of its closest enclosing block if it is not in the file
+func (pos positions) at(path ...string) token.Pos {
+	for i := len(path); i > 0; i-- {
+		if p, ok := pos[strings.Join(path[:i], "/")]; ok {
+			return p
+		}
+	}
+	return token.Pos{}
+}
+
+// errorf returns a ConfigError at the position of the path, after logging it
+func (pos positions) errorf(path []string, format string, args ...interface{}) error {
+	err := NewConfigError(pos.at(path...), fmt.Sprintf(format, args...))
+	Log.Error(err.Error())
+	return err
+}
+
+// wrap returns the error of a validation function as a ConfigError at the position of the path
+func (pos positions) wrap(path []string, err error) error {
+	return NewConfigError(pos.at(path...), err.Error())
+}
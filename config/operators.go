@@ -0,0 +1,164 @@
+package config
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// layouts of the time properties: the format of time.Time.String() used by GetProperties, and RFC 3339
+var timeLayouts = []string{"2006-01-02 15:04:05.999999999 -0700 MST", time.RFC3339}
+
+// Operators are the typed comparisons of a compliance check, in addition to its schema.
+// The property value must satisfy all the operators that are set.
+type Operators struct {
+	Equals string   `hcl:"equals"`
+	In     []string `hcl:"in"`
+	NotIn  []string `hcl:"not_in"`
+	// bounds of a numeric property, e.g. the Size of a volume
+	Min *float64 `hcl:"min"`
+	Max *float64 `hcl:"max"`
+	// age of a time property, e.g. "90 days" for the StartTime of a snapshot
+	OlderThan string `hcl:"older_than"`
+	NewerThan string `hcl:"newer_than"`
+	// compare the schema, equals, in and not_in values regardless of the case
+	IgnoreCase bool `hcl:"ignore_case"`
+}
+
+// isSet returns true if at least one of the operators is set
+func (op Operators) isSet() bool {
+	return op.Equals != "" || len(op.In) > 0 || len(op.NotIn) > 0 || op.Min != nil || op.Max != nil ||
+		op.OlderThan != "" || op.NewerThan != ""
+}
+
+// satisfiedBy returns true if the value satisfies all the operators that are set
+func (op Operators) satisfiedBy(value string) bool {
+	equal := func(a, b string) bool {
+		return a == b || (op.IgnoreCase && strings.EqualFold(a, b))
+	}
+	contains := func(values []string) bool {
+		for _, v := range values {
+			if equal(v, value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if op.Equals != "" && !equal(op.Equals, value) {
+		return false
+	}
+	if len(op.In) > 0 && !contains(op.In) {
+		return false
+	}
+	if len(op.NotIn) > 0 && contains(op.NotIn) {
+		return false
+	}
+
+	if op.Min != nil || op.Max != nil {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			Log.Debugf("Compliance check: %s is not a number", value)
+			return false
+		}
+		if (op.Min != nil && number < *op.Min) || (op.Max != nil && number > *op.Max) {
+			return false
+		}
+	}
+
+	if op.OlderThan != "" || op.NewerThan != "" {
+		date, ok := parseTime(value)
+		if !ok {
+			Log.Debugf("Compliance check: %s is not a time", value)
+			return false
+		}
+		age := time.Since(date)
+		if d, ok := parseDuration(op.OlderThan); ok && age <= d {
+			return false
+		}
+		if d, ok := parseDuration(op.NewerThan); ok && age >= d {
+			return false
+		}
+	}
+	return true
+}
+
+// matchSchema returns true if the value matches the schema pattern
+func (op Operators) matchSchema(schema string, value string) bool {
+	if op.IgnoreCase {
+		schema = "(?i)" + schema
+	}
+	return regexp.MustCompile(schema).MatchString(value)
+}
+
+// validate returns the description of the first invalid operator, or an empty string
+func (op Operators) validate() string {
+	if op.Min != nil && op.Max != nil && *op.Min > *op.Max {
+		return "min is greater than max"
+	}
+	if _, ok := parseDuration(op.OlderThan); op.OlderThan != "" && !ok {
+		return "invalid older_than duration '" + op.OlderThan + "'"
+	}
+	if _, ok := parseDuration(op.NewerThan); op.NewerThan != "" && !ok {
+		return "invalid newer_than duration '" + op.NewerThan + "'"
+	}
+	return ""
+}
+
+// applyTo copies the operators that are not set in dst, according to isSet
+func (op Operators) applyTo(dst *Operators, isSet func(setting string) bool) {
+	if !isSet("equals") {
+		dst.Equals = op.Equals
+	}
+	if !isSet("in") {
+		dst.In = op.In
+	}
+	if !isSet("not_in") {
+		dst.NotIn = op.NotIn
+	}
+	if !isSet("min") {
+		dst.Min = op.Min
+	}
+	if !isSet("max") {
+		dst.Max = op.Max
+	}
+	if !isSet("older_than") {
+		dst.OlderThan = op.OlderThan
+	}
+	if !isSet("newer_than") {
+		dst.NewerThan = op.NewerThan
+	}
+	if !isSet("ignore_case") {
+		dst.IgnoreCase = op.IgnoreCase
+	}
+}
+
+func parseTime(value string) (time.Time, bool) {
+	// the monotonic clock reading of time.Time.String()
+	if i := strings.Index(value, " m="); i > 0 {
+		value = value[:i]
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,211 @@
+package config
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// format of the security group rules returned by the IpPermissions properties
+var permissionPattern = regexp.MustCompile("^P:([^;]*);FP:([^;]*);TP:([^;]*);(IP|UG):(.*)$")
+
+// names of the protocol numbers used in the rules
+var protocolNames = map[string]string{"6": "tcp", "17": "udp", "1": "icmp", "58": "icmpv6"}
+
+// PermissionChecks are the semantic checks of the security group rules, for the IpPermissions
+// compliance checks. A rule is not compliant if its source is not in the allowed networks, or if
+// it is exposed: open to the world (deny_world) and/or opening a forbidden port, whichever are set.
+type PermissionChecks struct {
+	// networks that must contain the source of the rules, IPv4 or IPv6 CIDRs
+	AllowedNetworks []string `hcl:"allowed_networks"`
+	// report the rules open to 0.0.0.0/0 or ::/0
+	DenyWorld bool `hcl:"deny_world"`
+	// ports that must not be opened: "22", "6000-6010", "udp:53"; without protocol, tcp and udp.
+	// The rules of protocol -1 open all the ports.
+	ForbiddenPorts []string `hcl:"forbidden_ports"`
+}
+
+// permission is a security group rule
+type permission struct {
+	protocol string
+	from, to int64
+	// source CIDR, nil for the rules of a user/group pair
+	source *net.IPNet
+}
+
+// portRange is a range of ports of a protocol ("" for tcp and udp)
+type portRange struct {
+	protocol string
+	from, to int64
+}
+
+// isSet returns true if at least one of the checks is set
+func (pc PermissionChecks) isSet() bool {
+	return len(pc.AllowedNetworks) > 0 || pc.DenyWorld || len(pc.ForbiddenPorts) > 0
+}
+
+// IsCompliantPermission returns true, nil if the security group rule satisfies the permission checks,
+// false, nil if it does not, false, error if the rule cannot be parsed
+func (c CompliantCheck) IsCompliantPermission(rule string) (bool, error) {
+	p, err := parsePermission(rule)
+	if err != nil {
+		return false, err
+	}
+	compliant := c.PermissionChecks.allow(p)
+	if c.Negate {
+		compliant = !compliant
+	}
+	Log.Debugf("Compliance check for %s - %s is compliant: %t", c.Name, rule, compliant)
+	return compliant, nil
+}
+
+func (pc PermissionChecks) allow(p permission) bool {
+	if len(pc.AllowedNetworks) > 0 && p.source != nil {
+		allowed := false
+		for _, network := range pc.AllowedNetworks {
+			if _, n, err := net.ParseCIDR(network); err == nil && containsNetwork(n, p.source) {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	if !pc.DenyWorld && len(pc.ForbiddenPorts) == 0 {
+		return true
+	}
+	exposed := !pc.DenyWorld || (p.source != nil && isWorld(p.source))
+	if exposed && len(pc.ForbiddenPorts) > 0 {
+		opened := false
+		for _, spec := range pc.ForbiddenPorts {
+			if ports, err := parsePortRange(spec); err == nil && p.opens(ports) {
+				opened = true
+			}
+		}
+		exposed = opened
+	}
+	return !exposed
+}
+
+// opens returns true if the rule opens at least one of the ports
+func (p permission) opens(ports portRange) bool {
+	if p.protocol == "-1" {
+		return true
+	}
+	if ports.protocol == "" && p.protocol != "tcp" && p.protocol != "udp" {
+		return false
+	}
+	if ports.protocol != "" && ports.protocol != p.protocol {
+		return false
+	}
+	from, to := p.from, p.to
+	if from < 0 {
+		from, to = 0, 65535
+	}
+	return from <= ports.to && to >= ports.from
+}
+
+func parsePermission(rule string) (permission, error) {
+	subs := permissionPattern.FindStringSubmatch(rule)
+	if subs == nil {
+		return permission{}, fmt.Errorf("Invalid security group rule: %s", rule)
+	}
+	p := permission{protocol: strings.ToLower(subs[1]), from: -1, to: -1}
+	if name, ok := protocolNames[p.protocol]; ok {
+		p.protocol = name
+	}
+	if from, err := strconv.ParseInt(subs[2], 10, 64); err == nil {
+		p.from = from
+	}
+	if to, err := strconv.ParseInt(subs[3], 10, 64); err == nil {
+		p.to = to
+	}
+	if subs[4] == "IP" {
+		_, source, err := net.ParseCIDR(subs[5])
+		if err != nil {
+			return permission{}, fmt.Errorf("Invalid security group rule: %s: %s", rule, err)
+		}
+		p.source = source
+	}
+	return p, nil
+}
+
+func parsePortRange(spec string) (portRange, error) {
+	ports := portRange{}
+	if i := strings.Index(spec, ":"); i >= 0 {
+		ports.protocol = strings.ToLower(spec[:i])
+		spec = spec[i+1:]
+	}
+	bounds := strings.SplitN(spec, "-", 2)
+	from, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil {
+		return ports, fmt.Errorf("invalid port %s", spec)
+	}
+	ports.from, ports.to = from, from
+	if len(bounds) == 2 {
+		if ports.to, err = strconv.ParseInt(bounds[1], 10, 64); err != nil || ports.to < from {
+			return ports, fmt.Errorf("invalid port range %s", spec)
+		}
+	}
+	return ports, nil
+}
+
+// containsNetwork returns true if the network contains all the addresses of the other one
+func containsNetwork(network *net.IPNet, other *net.IPNet) bool {
+	ones, bits := network.Mask.Size()
+	otherOnes, otherBits := other.Mask.Size()
+	return bits == otherBits && ones <= otherOnes && network.Contains(other.IP)
+}
+
+// isWorld returns true for 0.0.0.0/0 and ::/0
+func isWorld(network *net.IPNet) bool {
+	ones, _ := network.Mask.Size()
+	return ones == 0
+}
+
+// applyTo copies the checks that are not set in dst, according to isSet
+func (pc PermissionChecks) applyTo(dst *PermissionChecks, isSet func(setting string) bool) {
+	if !isSet("allowed_networks") {
+		dst.AllowedNetworks = pc.AllowedNetworks
+	}
+	if !isSet("deny_world") {
+		dst.DenyWorld = pc.DenyWorld
+	}
+	if !isSet("forbidden_ports") {
+		dst.ForbiddenPorts = pc.ForbiddenPorts
+	}
+}
+
+// validate returns the description of the first invalid check, or an empty string
+func (pc PermissionChecks) validate() string {
+	for _, network := range pc.AllowedNetworks {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			return "invalid allowed network " + network
+		}
+	}
+	for _, spec := range pc.ForbiddenPorts {
+		if _, err := parsePortRange(spec); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,38 @@
+package config
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import "sync"
+
+// swapMu guards the Cfg globals of all the packages while the configuration is reloaded
+var swapMu sync.RWMutex
+
+// Hold prevents a configuration swap until the returned function is called. The event handlers hold
+// the configuration while they run, so that they never see a partially swapped one. Hold must not be
+// called again by the holder before it released the configuration.
+func Hold() func() {
+	swapMu.RLock()
+	return swapMu.RUnlock
+}
+
+// Swap waits for the holders of the configuration to release it and runs setGlobals, which assigns
+// the new configuration to the Cfg globals of the packages
+func Swap(setGlobals func()) {
+	swapMu.Lock()
+	defer swapMu.Unlock()
+	setGlobals()
+}
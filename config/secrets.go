@@ -0,0 +1,92 @@
+package config
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// redacted replaces the secret settings in the log output
+const redacted = "********"
+
+// secret is a setting that may be a reference to an environment variable (env:LDAP_PASSWORD) or to a
+// file (file:/run/secrets/ldap_password) instead of the secret itself
+type secret struct {
+	path  []string
+	value *string
+}
+
+func (cfg *Config) secrets() []secret {
+	return []secret{
+		{[]string{"access_key"}, &cfg.AccessKey},
+		{[]string{"secret_key"}, &cfg.SecretKey},
+		{[]string{"api_token"}, &cfg.APIToken},
+		{[]string{"ldap_config", "bind_password"}, &cfg.LdapConfig.BindPassword},
+	}
+}
+
+// integrateSecrets replaces the references of the secret settings with the value they refer to
+func integrateSecrets(config *Config, pos positions) error {
+	var errs *multierror.Error
+	for _, s := range config.secrets() {
+		value, err := resolveSecret(*s.value)
+		if err != nil {
+			errs = multierror.Append(errs, pos.wrap(s.path, err))
+			continue
+		}
+		*s.value = value
+	}
+	return errs.ErrorOrNil()
+}
+
+// resolveSecret returns the value of the environment variable or the content of the file the setting
+// refers to, without the trailing newline. Other values are returned as they are.
+func resolveSecret(setting string) (string, error) {
+	switch {
+	case strings.HasPrefix(setting, "env:"):
+		name := strings.TrimPrefix(setting, "env:")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("the environment variable %s is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(setting, "file:"):
+		content, err := ioutil.ReadFile(strings.TrimPrefix(setting, "file:"))
+		if err != nil {
+			return "", fmt.Errorf("cannot read the secret: %s", err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+	return setting, nil
+}
+
+// String returns the configuration without its secrets, for the log output
+func (cfg Config) String() string {
+	// the conversion drops the String method, which would call itself
+	type plain Config
+	for _, s := range cfg.secrets() {
+		if *s.value != "" {
+			*s.value = redacted
+		}
+	}
+	return fmt.Sprintf("%+v", plain(cfg))
+}
@@ -0,0 +1,96 @@
+package core
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/kreuzwerker/arebot/cloudwatch"
+	"github.com/kreuzwerker/arebot/storeresults"
+)
+
+// DeduplicateEvents enables the deduplication of the events handled by HandleEvent: an event already
+// processed within the dedup_window_seconds is acknowledged without checking the resources again
+var DeduplicateEvents = true
+
+var (
+	inFlightMu     sync.Mutex
+	inFlightEvents = map[string]bool{}
+)
+
+// InFlightEventError is returned while another delivery of the same event is being handled
+type InFlightEventError struct {
+	eventId string
+}
+
+func (e InFlightEventError) Error() string {
+	return fmt.Sprintf("event %s is already being handled", e.eventId)
+}
+
+// handleAWSEventOnce handles the event unless it has already been processed
+func handleAWSEventOnce(ctx context.Context, event cloudwatch.AWSEvent) error {
+	id := eventId(event)
+	if id == "" {
+		return HandleAWSEvent(ctx, event)
+	}
+
+	// the concurrent deliveries of the event are retried, and then acknowledged as duplicates
+	inFlightMu.Lock()
+	if inFlightEvents[id] {
+		inFlightMu.Unlock()
+		return InFlightEventError{eventId: id}
+	}
+	inFlightEvents[id] = true
+	inFlightMu.Unlock()
+	defer func() {
+		inFlightMu.Lock()
+		delete(inFlightEvents, id)
+		inFlightMu.Unlock()
+	}()
+
+	// checked once the event is registered as in flight: a delivery completing in the meantime has recorded
+	// the event as processed before releasing it
+	if storeresults.IsEventProcessed(id) {
+		Log.Printf("Ignoring duplicate delivery of event %s (%s)", id, event.ApiCall)
+		return nil
+	}
+
+	if err := HandleAWSEvent(ctx, event); err != nil {
+		return err
+	}
+	if err := storeresults.MarkEventProcessed(id); err != nil {
+		Log.Errorf("Cannot record event %s as processed: %s", id, err)
+	}
+	return nil
+}
+
+// eventId returns the CloudTrail event ID or, for the events not delivered by CloudTrail, the CloudWatch event ID
+func eventId(event cloudwatch.AWSEvent) string {
+	if event.ApiDetail.EventID != "" {
+		return event.ApiDetail.EventID
+	}
+	return event.Event.ID
+}
+
+// resourceEventId returns the ID recording that the resource of the event has been processed, the resource
+// ID is escaped as it may be an ARN
+func resourceEventId(eventId string, resourceId string) string {
+	return eventId + "_" + url.PathEscape(resourceId)
+}
@@ -17,12 +17,17 @@ package core
 */
 
 import (
+	"context"
+	"fmt"
 	"regexp"
 
-	"strings"
+	"github.com/hashicorp/go-multierror"
 
 	"github.com/kreuzwerker/arebot/cloudwatch"
+	// the resource packages also register their resource types and API calls
 	"github.com/kreuzwerker/arebot/resource/ec2"
+	"github.com/kreuzwerker/arebot/resource/iam"
+	"github.com/kreuzwerker/arebot/resource/s3bucket"
 	"github.com/kreuzwerker/arebot/resource/securitygroup"
 
 	"github.com/kreuzwerker/arebot/action"
@@ -33,224 +38,163 @@ import (
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
-func HandleEvent(msg *sqs.Message) error {
+// HandleEvent handles a message whatever the queue it has been received from, e.g. a replayed message
+func HandleEvent(ctx context.Context, msg *sqs.Message) error {
+	return handleMessage(ctx, msg, "")
+}
+
+// AccountEventHandler returns the handler of the messages received from the queue of an account. The events
+// of another account (forwarded by EventBridge or SNS) are handled if that account is configured too.
+func AccountEventHandler(accountId string) func(ctx context.Context, msg *sqs.Message) error {
+	return func(ctx context.Context, msg *sqs.Message) error {
+		return handleMessage(ctx, msg, accountId)
+	}
+}
+
+func handleMessage(ctx context.Context, msg *sqs.Message, queueAccountId string) error {
+	// the configuration is not reloaded while the event is handled
+	defer config.Hold()()
+
 	Log.Println(aws.StringValue(msg.Body))
 
-	event, err := cloudwatch.DecodeEvent([]byte(*msg.Body))
+	data, topics, err := cloudwatch.UnwrapMessage([]byte(aws.StringValue(msg.Body)))
+	if err != nil {
+		return err
+	}
+	event, err := cloudwatch.DecodeEvent(data)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	event.Topics = topics
 
 	if ignore, _ := ignoreEvent(event); ignore {
 		return nil
 	}
+	if err := checkEventOrigin(event, queueAccountId); err != nil {
+		Log.Warnf("Ignoring event %s: %s", eventId(event), err.Error())
+		return nil
+	}
 
-	return HandleAWSEvent(event)
+	if DeduplicateEvents {
+		return handleAWSEventOnce(ctx, event)
+	}
+	return HandleAWSEvent(ctx, event)
 }
 
-func HandleAWSEvent(event cloudwatch.AWSEvent) error {
+func HandleAWSEvent(ctx context.Context, event cloudwatch.AWSEvent) error {
 	Log.Printf("Received API call: %s", event.ApiCall)
+
+	decoder, ok := cloudwatch.GetEventDecoder(event.ApiCall)
+	if !ok || decoder.ResourceIds == nil {
+		Log.Warnf("%s API call not supported yet.", event.ApiCall)
+		return nil
+	}
+
 	// create a new object storing information about the user that has determined the event
-	eventUser := createEventUserInfo(event.ApiDetail)
+	eventUser := createEventUserInfo(event)
 
-	switch event.ApiCall {
-	case "AuthorizeSecurityGroupIngress", "RevokeSecurityGroupIngress":
-		id := event.RequestParameter.(*cloudwatch.SecurityGroupPolicyRequestParameters).GroupId
-		sg, err := securitygroup.NewSecurityGroupWithStatus(id, eventUser.AccountId)
-		if err != nil {
-			return err
-		}
-		Log.Printf("%+v changed (security group)", sg)
-		handleSecurityGroupEvent(event, eventUser, sg)
+	ids, err := decoder.ResourceIds(event)
+	if err != nil {
+		return err
+	}
 
-	case "AuthorizeSecurityGroupEgress", "RevokeSecurityGroupEgress":
-		id := event.RequestParameter.(*cloudwatch.SecurityGroupPolicyRequestParameters).GroupId
-		sg, err := securitygroup.NewSecurityGroupWithStatus(id, eventUser.AccountId)
-		if err != nil {
-			return err
-		}
-		Log.Printf("%+v changed (security group)", sg)
-		handleSecurityGroupEvent(event, eventUser, sg)
+	// the resources of an event are recorded as processed one by one, so that the delivery that follows a
+	// partial failure only checks (and runs the actions of) the resources that failed
+	progressId := ""
+	if DeduplicateEvents && len(ids) > 1 {
+		progressId = eventId(event)
+	}
 
-	case "CreateSecurityGroup":
-		_ /* uid */, err := event.ApiDetail.ParseUserIdentity()
-		if err != nil {
-			//Log.Errorf("%s", err)
-			return err
-		}
-		resp := event.ApiDetail.ResponseElements.(map[string]interface{})
-		id := resp["groupId"].(string)
-		sg, err := securitygroup.NewSecurityGroupWithStatus(id, eventUser.AccountId)
-		if err != nil {
-			return err
+	// every resource is handled even if some of them fail: the message is retried if any did
+	var errs *multierror.Error
+	for _, id := range ids {
+		if progressId != "" && storeresults.IsEventProcessed(resourceEventId(progressId, id)) {
+			Log.Printf("Resource %s of event %s already processed", id, progressId)
+			continue
 		}
-		Log.Printf("%+v created (security group)", sg)
-		//sg.TagCreator(uid)
-		handleSecurityGroupEvent(event, eventUser, sg)
-
-	case "CreateTags":
-		for _, item := range event.RequestParameter.(*cloudwatch.CreateTagsRequestParameters).ResourcesSet.Items {
-			rid := item["resourceId"]
-
-			if strings.HasPrefix(rid, "sg-") { // SecurityGroups
-				sg, err := securitygroup.NewSecurityGroupWithStatus(rid, eventUser.AccountId)
-				if err != nil {
-					return err
-				}
-				Log.Printf("%+v tagged (security group)", sg)
-				handleSecurityGroupEvent(event, eventUser, sg)
-			} else if strings.HasPrefix(rid, "i-") { // EC2 instances
-				e, err := ec2instance.NewEC2WithStatus(rid, eventUser.AccountId)
-				if err != nil {
-					return err
-				}
-				Log.Printf("%+v tagged (ec2 instance)", e)
-				handleEC2Event(event, eventUser, e)
+		if err := handleResource(ctx, event, decoder, eventUser, id); err != nil {
+			if !isIgnoredError(ctx, err) {
+				Log.Errorf("%s event: handling of resource %s failed: %s", event.ApiCall, id, err.Error())
+				errs = multierror.Append(errs, fmt.Errorf("%s: %s", id, err))
+				continue
 			}
+			Log.Warnf("Ignoring error of resource %s: %s", id, err.Error())
 		}
-
-	case "DeleteTags":
-		for _, item := range event.RequestParameter.(*cloudwatch.CreateTagsRequestParameters).ResourcesSet.Items {
-			rid := item["resourceId"]
-
-			if strings.HasPrefix(rid, "sg-") { // SecurityGroups
-				sg, err := securitygroup.NewSecurityGroupWithStatus(rid, eventUser.AccountId)
-				if err != nil {
-					return err
-				}
-				//Log.Printf("%+v deleted tags: %+v", sg, item)
-				for _, item := range event.RequestParameter.(*cloudwatch.CreateTagsRequestParameters).TagSet.Items {
-					if strings.HasPrefix(item["key"], "AreBOT.") {
-						sg.Tag(item["key"], item["value"])
-					}
-					Log.Printf("%+v deleted tag: %+v (security group)", sg, item)
-				}
-
-			} else if strings.HasPrefix(rid, "i-") { // EC2 instances
-				e, err := ec2instance.NewEC2WithStatus(rid, eventUser.AccountId)
-				if err != nil {
-					return err
-				}
-				for _, item := range event.RequestParameter.(*cloudwatch.CreateTagsRequestParameters).TagSet.Items {
-					// if strings.HasPrefix(item["key"], "AreBOT.") {
-					// 	e.Tag(item["key"], item["value"])
-					// }
-					Log.Printf("%+v deleted tag: %+v (ec2 instance)", e, item)
-				}
+		if progressId != "" {
+			if err := storeresults.MarkEventProcessed(resourceEventId(progressId, id)); err != nil {
+				Log.Errorf("Cannot record resource %s of event %s as processed: %s", id, progressId, err)
 			}
 		}
+	}
 
-	case "DeleteSecurityGroup":
-		id := event.RequestParameter.(*cloudwatch.SecurityGroupPolicyRequestParameters).GroupId
-		//sg := SecurityGroup.NewSecurityGroupWithStatus(id, event.ApiDetail.UserIdentity.AccountID)
-		Log.Printf("Security group ID: %s", id)
-		Log.Printf("%s deleted (security group)", id)
-		storeresults.DeleteCheckResultsByResourceId(id)
-		return nil
+	return errs.ErrorOrNil()
+}
 
-	case "RunInstances", "StartInstances":
-		_, err := event.ApiDetail.ParseUserIdentity()
-		if err != nil {
-			return err
-		}
-		resp := event.ApiDetail.ResponseElements.(map[string]interface{})
-		id, err := util.ParseEC2ResponseRunStartInstance(resp, "instanceId")
-		if err != nil {
-			return err
-		}
-		e, err := ec2instance.NewEC2WithStatus(id, eventUser.AccountId)
-		if err != nil {
-			return err
-		}
-		Log.Printf("%+v started (ec2 instance)", e)
-		handleEC2Event(event, eventUser, e)
-
-	case "CreateVolume":
-		resp := event.ApiDetail.ResponseElements.(map[string]interface{})
-		id := resp["volumeId"].(string)
-		v, err := ec2instance.NewVolumeWithStatus(id, eventUser.AccountId)
-		if err != nil {
-			return err
-		}
-		Log.Printf("%+v created (volume)", v)
-		handleVolumeEvent(event, eventUser, v)
-
-	case "AttachVolume":
-		resp := event.ApiDetail.ResponseElements.(map[string]interface{})
-		volumeId := resp["volumeId"].(string)
-		instanceId := resp["instanceId"].(string)
-		v, err := ec2instance.NewVolumeWithStatus(volumeId, eventUser.AccountId)
-		if err != nil {
-			return err
-		}
-		Log.Printf("%+v attached volume to instance %s", v, instanceId)
-		handleVolumeEvent(event, eventUser, v)
-
-	case "CreateSnapshot":
-		resp := event.ApiDetail.ResponseElements.(map[string]interface{})
-		snapId := resp["snapshotId"].(string)
-		volumeId := resp["volumeId"].(string)
-		s, err := ec2instance.NewSnapshotWithStatus(snapId, eventUser.AccountId)
-		if err != nil {
-			return err
+// handleResource runs the compliance checks of one of the resources concerned by the event
+func handleResource(ctx context.Context, event cloudwatch.AWSEvent, decoder cloudwatch.EventDecoder, eventUser config.EventUserInfo, id string) error {
+	if decoder.DeletesResources(event) {
+		Log.Printf("%s deleted (%s)", id, event.ApiCall)
+		if err := storeresults.DeleteCheckResultsByResourceId(id); err != nil {
+			Log.Debugf("Cannot delete the check results of %s: %s", id, err)
 		}
-		Log.Printf("%+v snapshot created from volume %s", s, volumeId)
-		handleSnapshotEvent(event, eventUser, s)
-
-	case "DeleteVolume":
-		id := event.RequestParameter.(*cloudwatch.VolumeRequestParameters).VolumeId
-		Log.Printf("Volume ID: %s", id)
-		Log.Printf("%s deleted (volume)", id)
-		storeresults.DeleteCheckResultsByResourceId(id)
-
-		return nil
-
-	case "DeleteSnapshot":
-		id := event.RequestParameter.(*cloudwatch.SnapshotRequestParameters).SnapshotId
-		Log.Printf("Snapshot ID: %s", id)
-		Log.Printf("%s deleted (snapshot)", id)
-		storeresults.DeleteCheckResultsByResourceId(id)
-
 		return nil
+	}
 
-	case "PutBucketTagging":
-		Log.Warn("PutBucketTagging API call not supported yet.")
+	rt, ok := cloudwatch.GetResourceType(id)
+	if !ok {
+		Log.Debugf("Ignoring resource %s of %s: unsupported resource type", id, event.ApiCall)
 		return nil
 	}
+	resource, err := rt.New(ctx, id, eventUser.AccountId, eventUser.Region)
+	if err != nil {
+		return err
+	}
 
+	if decoder.Handle != nil {
+		if err := decoder.Handle(ctx, event, resource); err != nil {
+			return err
+		}
+	}
+	Log.Printf("%+v changed by %s (%s)", resource, event.ApiCall, rt.Name)
+	handleResourceEvent(ctx, event, eventUser, rt, resource)
 	return nil
 }
 
-func handleSecurityGroupEvent(event cloudwatch.AWSEvent, eventuser config.EventUserInfo, sg securitygroup.SecurityGroup) {
-	_, apicallsConfigs := Cfg.GetAPICallConfigs(event.ApiCall, eventuser.AccountId, *sg.State.VpcId, "security_group")
-	execCompliantChecks(&sg, apicallsConfigs, eventuser)
-}
-
-func handleEC2Event(event cloudwatch.AWSEvent, eventuser config.EventUserInfo, e ec2instance.EC2inst) {
-	_, apicallsConfigs := Cfg.GetAPICallConfigs(event.ApiCall, eventuser.AccountId, *e.State.VpcId, "ec2")
-	execCompliantChecks(&e, apicallsConfigs, eventuser)
-}
-
-func handleVolumeEvent(event cloudwatch.AWSEvent, eventuser config.EventUserInfo, v ec2instance.Volume) {
-	_, apicallsConfigs := Cfg.GetAPICallConfigs(event.ApiCall, eventuser.AccountId, "", "ec2")
-	execCompliantChecks(&v, apicallsConfigs, eventuser)
+// isIgnoredError returns true for the errors of the resources that cannot be checked anymore, e.g. because they have been
+// deleted. The errors of a cancelled handling are retried, whatever the resource error says.
+func isIgnoredError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	switch e := err.(type) {
+	case securitygroup.SecurityGroupError:
+		return e.Ignore
+	case ec2instance.EC2Error:
+		return e.Ignore
+	case s3bucket.BucketError:
+		return e.Ignore
+	case iam.IAMError:
+		return e.Ignore
+	}
+	return false
 }
 
-func handleSnapshotEvent(event cloudwatch.AWSEvent, eventuser config.EventUserInfo, s ec2instance.Snapshot) {
-	_, apicallsConfigs := Cfg.GetAPICallConfigs(event.ApiCall, eventuser.AccountId, "", "ec2")
-	execCompliantChecks(&s, apicallsConfigs, eventuser)
+func handleResourceEvent(ctx context.Context, event cloudwatch.AWSEvent, eventuser config.EventUserInfo, rt cloudwatch.ResourceType, resource util.AwsResourceType) {
+	_, apicallsConfigs := Cfg.GetAPICallConfigs(event.ApiCall, eventuser.AccountId, util.GetVpcId(resource), rt.PolicyType, rt.ConfigName)
+	execCompliantChecks(ctx, resource, apicallsConfigs, eventuser)
 }
 
-func execCompliantChecks(resource util.AwsResourceType, apicallsConfigs []config.APICall, eventuser config.EventUserInfo) {
+func execCompliantChecks(ctx context.Context, resource util.AwsResourceType, apicallsConfigs []config.APICall, eventuser config.EventUserInfo) {
 	for _, apicallCfg := range apicallsConfigs {
 		Log.Debugf("event_handler.execCompliantChecks: checking compliance %+v", apicallCfg)
 		// apply compliance checks based on configuration
 		results := apicallCfg.CheckCompliance(resource.GetProperties, resource.GetId(), eventuser)
+		results = action.WaiveExemptedResults(results, resource.GetProperties)
 
 		for _, result := range results {
 			result := result
 			if !result.IsCompliant {
-				action.HandleAction(result, true)
+				action.HandleAction(ctx, result, true)
 			}
 		}
 
@@ -262,12 +206,26 @@ func execCompliantChecks(resource util.AwsResourceType, apicallsConfigs []config
 
 func ignoreEvent(event cloudwatch.AWSEvent) (bool, error) {
 
-	user, err := event.ApiDetail.ParseUserIdentity()
-
 	if Cfg.GetAccount(event.Event.Account) == nil {
 		Log.Printf("Error getting account configuration for account %s, event type: %s, source: %s", event.Event.Account, event.Event.DetailType, event.Event.Source)
-		return true, err
+		return true, nil
+	}
+
+	if !Cfg.IsAccountRegion(event.Event.Account, event.Event.Region) {
+		Log.Printf("Ignoring event of the region %s, not monitored for account %s, event type: %s", event.Event.Region, event.Event.Account, event.Event.DetailType)
+		return true, nil
 	}
+
+	// the other CloudWatch events (EC2 state changes, Auto Scaling) have no user identity
+	if !event.IsAPICall() {
+		if _, ok := cloudwatch.GetEventDecoder(event.ApiCall); !ok {
+			Log.Printf("Ignoring unhandled event type: %s, source: %s", event.Event.DetailType, event.Event.Source)
+			return true, nil
+		}
+		return false, nil
+	}
+
+	user, err := event.ApiDetail.ParseUserIdentity()
 	if err != nil {
 		Log.Printf("Error parsing user identity: %s event type: %s, source: %s", err, event.Event.DetailType, event.Event.Source)
 		return true, err
@@ -285,7 +243,7 @@ func ignoreEvent(event cloudwatch.AWSEvent) (bool, error) {
 	}
 
 	// exit for unhandled event types
-	if event.Event.DetailType != "AWS API Call via CloudTrail" || !(event.Event.Source == "aws.ec2" || event.Event.Source == "aws.s3") {
+	if !(event.Event.Source == "aws.ec2" || event.Event.Source == "aws.s3" || event.Event.Source == "aws.iam") {
 		Log.Printf("Ignoring unhandled event type: %s, source: %s", event.Event.DetailType, event.Event.Source)
 		return true, nil
 	}
@@ -298,11 +256,39 @@ func ignoreEvent(event cloudwatch.AWSEvent) (bool, error) {
 	return false, nil
 }
 
+// checkEventOrigin returns an error if the event has been delivered through an SNS topic or forwarded from an
+// account whose account block is missing. The account of the event has been checked by ignoreEvent already.
+func checkEventOrigin(event cloudwatch.AWSEvent, queueAccountId string) error {
+	for _, topic := range event.Topics {
+		if Cfg.GetAccount(cloudwatch.ArnAccount(topic)) == nil {
+			return fmt.Errorf("published to the SNS topic %s of an unknown account", topic)
+		}
+	}
+	if queueAccountId != "" && event.Event.Account != queueAccountId {
+		Log.Printf("Event %s of account %s forwarded to the queue of account %s", eventId(event), event.Event.Account, queueAccountId)
+	}
+	// the resources of API calls are described in the account of the user (see createEventUserInfo)
+	if event.IsAPICall() && event.ApiDetail.UserIdentity.AccountID != event.Event.Account &&
+		Cfg.GetAccount(event.ApiDetail.UserIdentity.AccountID) == nil {
+		return fmt.Errorf("API call of the unknown account %s", event.ApiDetail.UserIdentity.AccountID)
+	}
+	return nil
+}
+
 // Retrieve user information from the API detail and return a new EventUserInfo object
-func createEventUserInfo(apidetail cloudwatch.APIDetail) config.EventUserInfo {
+func createEventUserInfo(event cloudwatch.AWSEvent) config.EventUserInfo {
 	var eventUserInfo config.EventUserInfo
 
+	// the events that are not API calls are not caused by a user
+	if !event.IsAPICall() {
+		eventUserInfo.AccountId = event.Event.Account
+		eventUserInfo.Region = event.Event.Region
+		return eventUserInfo
+	}
+	apidetail := event.ApiDetail
+
 	eventUserInfo.AccountId = apidetail.UserIdentity.AccountID
+	eventUserInfo.ARN = apidetail.UserIdentity.ARN
 
 	// retrieve user information from the API detail
 	userIdentity, parseErr := apidetail.ParseUserIdentity()
@@ -17,13 +17,20 @@ package core
 */
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/kreuzwerker/arebot/cloudwatch"
 	"github.com/kreuzwerker/arebot/config"
+	"github.com/kreuzwerker/arebot/resource/ec2"
 	"github.com/kreuzwerker/arebot/resource/securitygroup"
+	"github.com/kreuzwerker/arebot/storeresults"
 	"github.com/kreuzwerker/arebot/util"
 
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -178,9 +185,327 @@ func TestHandleEvent(t *testing.T) {
 	}
 	securitygroup.Cfg = Cfg
 	util.Cfg = Cfg
-	HandleAWSEvent(awsEvent)
+	HandleAWSEvent(context.Background(), awsEvent)
 }
 
+type fakeResource struct {
+	id string
+}
+
+func (r *fakeResource) GetId() string                 { return r.id }
+func (r *fakeResource) GetProperties(string) []string { return nil }
+
+func TestHandleEventResources(t *testing.T) {
+	var handled []string
+	cloudwatch.RegisterResourceType(cloudwatch.ResourceType{
+		Name:       "fake resource",
+		IdPrefix:   "fake",
+		PolicyType: "fake",
+		New: func(ctx context.Context, id string, accountId string, region string) (util.AwsResourceType, error) {
+			if id == "fake-missing" {
+				return nil, errors.New("resource not found")
+			}
+			handled = append(handled, id)
+			return &fakeResource{id: id}, nil
+		},
+	})
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames: []string{"RunFakes"},
+		ResourceIds: func(e cloudwatch.AWSEvent) ([]string, error) {
+			return []string{"fake-1", "fake-missing", "fake-2"}, nil
+		},
+	})
+
+	Cfg, _ = config.ParseConfig(handleEventsConfigTestFixure1)
+	awsEvent := cloudwatch.AWSEvent{
+		ApiCall: "RunFakes",
+		ApiDetail: cloudwatch.APIDetail{
+			EventName:    "RunFakes",
+			UserIdentity: cloudwatch.UserIdentity{AccountID: "000000000000"},
+		},
+	}
+	err := HandleAWSEvent(context.Background(), awsEvent)
+	if len(handled) != 2 {
+		t.Errorf("the resources after the failing one should be handled too: %+v", handled)
+	}
+	if err == nil || !strings.Contains(err.Error(), "fake-missing") {
+		t.Errorf("the error should report the failing resource: %v", err)
+	}
+
+	// the delivery that follows the failure only handles the failing resource
+	folder, err := ioutil.TempDir("", "arebot-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	Cfg.S3Config.LocalFolder = folder
+	storeresults.InitVars(Log, Cfg)
+	awsEvent.ApiDetail.EventID = "793fc880-58cc-4126-86df-67045515f912"
+	for _, expected := range [][]string{{"fake-1", "fake-2"}, nil} {
+		handled = nil
+		if err := HandleAWSEvent(context.Background(), awsEvent); err == nil {
+			t.Error("the failing resource should still fail")
+		}
+		if !reflect.DeepEqual(handled, expected) {
+			t.Errorf("handled == %+v, expected %+v", handled, expected)
+		}
+	}
+}
+
+func TestHandleEventResourceErrors(t *testing.T) {
+	cloudwatch.RegisterResourceType(cloudwatch.ResourceType{
+		Name:       "flaky resource",
+		IdPrefix:   "flaky",
+		PolicyType: "fake",
+		New: func(ctx context.Context, id string, accountId string, region string) (util.AwsResourceType, error) {
+			if id == "flaky-gone" {
+				return nil, ec2instance.NewEC2Error(id, "InvalidInstanceID.NotFound", true)
+			}
+			return nil, ec2instance.NewEC2Error(id, "Throttling: Rate exceeded", false)
+		},
+	})
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames: []string{"RunFlakies"},
+		ResourceIds: func(e cloudwatch.AWSEvent) ([]string, error) {
+			return []string{"flaky-throttled", "flaky-gone"}, nil
+		},
+	})
+
+	Cfg, _ = config.ParseConfig(handleEventsConfigTestFixure1)
+	awsEvent := cloudwatch.AWSEvent{
+		ApiCall: "RunFlakies",
+		ApiDetail: cloudwatch.APIDetail{
+			EventName:    "RunFlakies",
+			UserIdentity: cloudwatch.UserIdentity{AccountID: "000000000000"},
+		},
+	}
+	// the throttled resource is retried, the deleted one is not
+	err := HandleAWSEvent(context.Background(), awsEvent)
+	if err == nil || !strings.Contains(err.Error(), "flaky-throttled") || strings.Contains(err.Error(), "flaky-gone") {
+		t.Errorf("err == %v expected the throttled resource only", err)
+	}
+
+	// a cancelled handling is retried whatever the error
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = HandleAWSEvent(ctx, awsEvent)
+	if err == nil || !strings.Contains(err.Error(), "flaky-gone") {
+		t.Errorf("err == %v expected the deleted resource to be retried once cancelled", err)
+	}
+}
+
+// taggedResource records the restored tags and the compliance checks, which look up its VPC
+type taggedResource struct {
+	fakeResource
+	tags    map[string]string
+	checked bool
+	tagErr  error
+}
+
+func (r *taggedResource) GetVpcId() string {
+	r.checked = true
+	return ""
+}
+
+func (r *taggedResource) Tag(ctx context.Context, key string, value string) error {
+	if r.tagErr != nil {
+		return r.tagErr
+	}
+	r.tags[key] = value
+	return nil
+}
+
+func TestHandleDeleteTags(t *testing.T) {
+	resource := &taggedResource{fakeResource: fakeResource{id: "tagged-1"}, tags: map[string]string{}}
+	cloudwatch.RegisterResourceType(cloudwatch.ResourceType{
+		Name:       "tagged resource",
+		IdPrefix:   "tagged",
+		PolicyType: "fake",
+		New: func(ctx context.Context, id string, accountId string, region string) (util.AwsResourceType, error) {
+			return resource, nil
+		},
+	})
+
+	Cfg, _ = config.ParseConfig(handleEventsConfigTestFixure1)
+	params := &cloudwatch.CreateTagsRequestParameters{}
+	params.ResourcesSet.Items = []map[string]string{{"resourceId": "tagged-1"}}
+	params.TagSet.Items = []map[string]string{{"key": "AreBOT.Owner", "value": "bob"}, {"key": "Name", "value": "web"}}
+	awsEvent := cloudwatch.AWSEvent{
+		ApiCall: "DeleteTags",
+		ApiDetail: cloudwatch.APIDetail{
+			EventName:    "DeleteTags",
+			UserIdentity: cloudwatch.UserIdentity{AccountID: "000000000000"},
+		},
+		RequestParameter: params,
+	}
+
+	// the AreBOT tags are restored, and the resource is checked like for the other events
+	if err := HandleAWSEvent(context.Background(), awsEvent); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resource.tags, map[string]string{"AreBOT.Owner": "bob"}) {
+		t.Errorf("tags == %+v expected the AreBOT tag only", resource.tags)
+	}
+	if !resource.checked {
+		t.Error("the compliance checks should run after the tags are restored")
+	}
+
+	// the event is retried if a tag cannot be restored
+	resource.tagErr = errors.New("Throttling: Rate exceeded")
+	if err := HandleAWSEvent(context.Background(), awsEvent); err == nil || !strings.Contains(err.Error(), "AreBOT.Owner") {
+		t.Errorf("err == %v expected the tag that cannot be restored", err)
+	}
+}
+
+func TestParseEC2ResponseRunStartInstances(t *testing.T) {
+	resp := map[string]interface{}{
+		"instancesSet": map[string]interface{}{
+			"items": []interface{}{
+				map[string]interface{}{"instanceId": "i-00000001"},
+				map[string]interface{}{"instanceId": "i-00000002"},
+				map[string]interface{}{"instanceId": "i-00000003"},
+			},
+		},
+	}
+	ids, err := util.ParseEC2ResponseRunStartInstances(resp, "instanceId")
+	if err != nil || len(ids) != 3 || ids[2] != "i-00000003" {
+		t.Errorf("all the instances should be returned: %+v %v", ids, err)
+	}
+	if _, err := util.ParseEC2ResponseRunStartInstances(map[string]interface{}{}, "instanceId"); err == nil {
+		t.Error("a response without instancesSet should return an error")
+	}
+}
+
+func TestCheckEventOrigin(t *testing.T) {
+	Cfg, _ = config.ParseConfig(handleEventsConfigTestFixure1)
+	event, _ := cloudwatch.DecodeEvent([]byte(ec2StateRunningEvent))
+
+	// forwarded from account 000000000000 to the queue of account 111111111111
+	if err := checkEventOrigin(event, "111111111111"); err != nil {
+		t.Errorf("events of configured accounts should be accepted: %s", err)
+	}
+	event.Topics = []string{"arn:aws:sns:eu-central-1:000000000000:arebot-events"}
+	if err := checkEventOrigin(event, "000000000000"); err != nil {
+		t.Errorf("events published to a topic of a configured account should be accepted: %s", err)
+	}
+	event.Topics = []string{"arn:aws:sns:eu-central-1:999999999999:arebot-events"}
+	if err := checkEventOrigin(event, "000000000000"); err == nil {
+		t.Error("events published to a topic of an unknown account should be rejected")
+	}
+}
+
+func TestNonAPIEvents(t *testing.T) {
+	Cfg, _ = config.ParseConfig(handleEventsConfigTestFixure1)
+
+	expected := map[string]struct {
+		ids     []string
+		deletes bool
+	}{
+		ec2StateRunningEvent:    {[]string{"i-aaaabbbbccccdddd0"}, false},
+		ec2StateStoppingEvent:   {nil, false},
+		ec2StateTerminatedEvent: {[]string{"i-aaaabbbbccccdddd0"}, true},
+		autoScalingLaunchEvent:  {[]string{"i-00001111222233334"}, false},
+	}
+	for data, exp := range expected {
+		event, err := cloudwatch.DecodeEvent([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ignore, _ := ignoreEvent(event); ignore {
+			t.Errorf("%s event shouldn't be ignored", event.ApiCall)
+		}
+		decoder, _ := cloudwatch.GetEventDecoder(event.ApiCall)
+		ids, err := decoder.ResourceIds(event)
+		if err != nil || !reflect.DeepEqual(ids, exp.ids) {
+			t.Errorf("%s event: expected resources %+v, got %+v (%v)", event.ApiCall, exp.ids, ids, err)
+		}
+		if decoder.DeletesResources(event) != exp.deletes {
+			t.Errorf("%s event: expected deletion %t", event.ApiCall, exp.deletes)
+		}
+		if user := createEventUserInfo(event); user.AccountId != "000000000000" {
+			t.Errorf("%s event: the account should be taken from the event: %+v", event.ApiCall, user)
+		}
+	}
+
+	event, _ := cloudwatch.DecodeEvent([]byte(strings.Replace(ec2StateRunningEvent, "EC2 Instance State-change Notification", "EBS Snapshot Notification", 1)))
+	if ignore, _ := ignoreEvent(event); !ignore {
+		t.Errorf("unhandled event types should be ignored: %+v", event)
+	}
+}
+
+const ec2StateRunningEvent = `{
+	"version": "0",
+	"id": "551cfe96-8e0c-4cbe-bd84-63fd6884d015",
+	"detail-type": "EC2 Instance State-change Notification",
+	"source": "aws.ec2",
+	"account": "000000000000",
+	"time": "2017-04-22T07:01:03Z",
+	"region": "eu-central-1",
+	"resources": ["arn:aws:ec2:eu-central-1:000000000000:instance/i-aaaabbbbccccdddd0"],
+	"detail": {
+		"instance-id": "i-aaaabbbbccccdddd0",
+		"state": "running"
+	}
+}`
+
+const ec2StateStoppingEvent = `{
+	"version": "0",
+	"id": "551cfe96-8e0c-4cbe-bd84-63fd6884d016",
+	"detail-type": "EC2 Instance State-change Notification",
+	"source": "aws.ec2",
+	"account": "000000000000",
+	"time": "2017-04-22T07:01:03Z",
+	"region": "eu-central-1",
+	"resources": ["arn:aws:ec2:eu-central-1:000000000000:instance/i-aaaabbbbccccdddd0"],
+	"detail": {
+		"instance-id": "i-aaaabbbbccccdddd0",
+		"state": "stopping"
+	}
+}`
+
+const ec2StateTerminatedEvent = `{
+	"version": "0",
+	"id": "551cfe96-8e0c-4cbe-bd84-63fd6884d017",
+	"detail-type": "EC2 Instance State-change Notification",
+	"source": "aws.ec2",
+	"account": "000000000000",
+	"time": "2017-04-22T07:01:03Z",
+	"region": "eu-central-1",
+	"resources": ["arn:aws:ec2:eu-central-1:000000000000:instance/i-aaaabbbbccccdddd0"],
+	"detail": {
+		"instance-id": "i-aaaabbbbccccdddd0",
+		"state": "terminated"
+	}
+}`
+
+const autoScalingLaunchEvent = `{
+	"version": "0",
+	"id": "3e3c153a-8339-4e30-8c35-687ebef853fe",
+	"detail-type": "EC2 Instance Launch Successful",
+	"source": "aws.autoscaling",
+	"account": "000000000000",
+	"time": "2017-04-22T07:03:37Z",
+	"region": "eu-central-1",
+	"resources": [
+		"arn:aws:autoscaling:eu-central-1:000000000000:autoScalingGroup:eb56d16b-bbf0-401d-b893-d5978ed4a025:autoScalingGroupName/sampleLuanchSucASG",
+		"arn:aws:ec2:eu-central-1:000000000000:instance/i-00001111222233334"
+	],
+	"detail": {
+		"StatusCode": "InProgress",
+		"AutoScalingGroupName": "sampleLuanchSucASG",
+		"ActivityId": "9cabb81f-42de-417d-8aa7-ce16bf026590",
+		"Details": {
+			"Availability Zone": "eu-central-1b",
+			"Subnet ID": "subnet-95bfcebe"
+		},
+		"RequestId": "9cabb81f-42de-417d-8aa7-ce16bf026590",
+		"EndTime": "2017-04-22T07:03:37.241Z",
+		"EC2InstanceId": "i-00001111222233334",
+		"StartTime": "2017-04-22T07:03:04.436Z",
+		"Cause": "At 2017-04-22T07:03:01Z a user request created an AutoScalingGroup changing the desired capacity from 0 to 1."
+	}
+}`
+
 func TestCompliantCheckCondition(t *testing.T) {
 
 	Cfg, _ := config.ParseConfig(string(configCheckConditions[:]))
@@ -189,7 +514,7 @@ func TestCompliantCheckCondition(t *testing.T) {
 		t.Error(err)
 	}
 
-	sgFalseCondition, _ := securitygroup.NewSecurityGroupWithStatus("sg-11bbcc22", "222233334444", func(id string, account string) (*ec2.DescribeSecurityGroupsOutput, error) {
+	sgFalseCondition, _ := securitygroup.NewSecurityGroupWithStatus(context.Background(), "sg-11bbcc22", "222233334444", "eu-central-1", func(ctx context.Context, id string, account string, region string) (*ec2.DescribeSecurityGroupsOutput, error) {
 		return &ec2.DescribeSecurityGroupsOutput{
 			SecurityGroups: []*ec2.SecurityGroup{
 				{
@@ -211,7 +536,7 @@ func TestCompliantCheckCondition(t *testing.T) {
 		}, nil
 	})
 
-	sgTrueCondition, _ := securitygroup.NewSecurityGroupWithStatus("sg-11bbcc22", "222233334444", func(id string, account string) (*ec2.DescribeSecurityGroupsOutput, error) {
+	sgTrueCondition, _ := securitygroup.NewSecurityGroupWithStatus(context.Background(), "sg-11bbcc22", "222233334444", "eu-central-1", func(ctx context.Context, id string, account string, region string) (*ec2.DescribeSecurityGroupsOutput, error) {
 		return &ec2.DescribeSecurityGroupsOutput{
 			SecurityGroups: []*ec2.SecurityGroup{
 				{
@@ -233,7 +558,7 @@ func TestCompliantCheckCondition(t *testing.T) {
 
 	// CHECK FALSE CONDITION
 	Log.Debugf("Checking false AND condition.")
-	_, apicallsCfg := Cfg.GetAPICallConfigs(event.ApiCall, eventuser.AccountId, *sgFalseCondition.State.VpcId, "security_group")
+	_, apicallsCfg := Cfg.GetAPICallConfigs(event.ApiCall, eventuser.AccountId, *sgFalseCondition.State.VpcId, "security_group", "")
 	Log.Debugf("event_handler.handleSecurityGroupEvent: checking compliance %+v", apicallsCfg[0])
 	results := apicallsCfg[0].CheckCompliance(sgFalseCondition.GetProperties, *sgFalseCondition.State.GroupId, eventuser)
 	if len(results) > 0 {
@@ -242,7 +567,7 @@ func TestCompliantCheckCondition(t *testing.T) {
 
 	// CHECK TRUE CONDITION
 	Log.Debugf("Checking true AND condition.")
-	_, apicallsCfg = Cfg.GetAPICallConfigs(event.ApiCall, eventuser.AccountId, *sgTrueCondition.State.VpcId, "security_group")
+	_, apicallsCfg = Cfg.GetAPICallConfigs(event.ApiCall, eventuser.AccountId, *sgTrueCondition.State.VpcId, "security_group", "")
 	Log.Debugf("event_handler.handleSecurityGroupEvent: checking compliance %+v", apicallsCfg[0])
 	results = apicallsCfg[0].CheckCompliance(sgTrueCondition.GetProperties, *sgTrueCondition.State.GroupId, eventuser)
 	if len(results) == 0 {
@@ -17,20 +17,27 @@ package httpserver
 */
 
 import (
+	"crypto/subtle"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
+	"github.com/kreuzwerker/arebot/config"
 	"github.com/kreuzwerker/arebot/resource/securitygroup"
+	"github.com/kreuzwerker/arebot/storeresults"
 	"github.com/kreuzwerker/arebot/storeresults/filesystem"
 )
 
 var (
 	// Log Logger for this package
 	Log = newLogger()
+	Cfg *config.Config
 )
 
 func newLogger() *logrus.Logger {
@@ -48,6 +55,9 @@ func Http_server(port string) {
 	router.HandleFunc("/", handler)
 	router.HandleFunc("/findAll", findAllSecGroups)
 	router.HandleFunc("/status/{id}", stateHandler)
+	router.HandleFunc("/exemptions", listExemptions).Methods("GET")
+	router.HandleFunc("/exemptions", authorized(createExemption)).Methods("POST")
+	router.HandleFunc("/exemptions/{name}", authorized(deleteExemption)).Methods("DELETE")
 
 	err := http.ListenAndServe(":" + port, router)
 
@@ -62,6 +72,8 @@ func handler(w http.ResponseWriter, r *http.Request) {
 }
 
 func stateHandler(w http.ResponseWriter, r *http.Request) {
+	defer config.Hold()()
+
 	vars := mux.Vars(r)
 	key := vars["id"]
 	grp, err := filesystem.GetState(key)
@@ -74,8 +86,10 @@ func stateHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func findAllSecGroups(w http.ResponseWriter, r *http.Request) {
+	defer config.Hold()()
+
 	var result []string
-	groups, err := securitygroup.FindAllSecGroupsWithTag("AreBOT.ComplianceNotMet", "")
+	groups, err := securitygroup.FindAllSecGroupsWithTag(r.Context(), "AreBOT.ComplianceNotMet", "")
 	if err != nil {
 		Log.Errorf("Error while fetching security grops: %s", err.Error())
 	}
@@ -84,3 +98,83 @@ func findAllSecGroups(w http.ResponseWriter, r *http.Request) {
 	}
 	fmt.Fprint(w, result)
 }
+
+// authorized restricts the handler to the requests bearing the api_token of the configuration
+// ("Authorization: Bearer <token>"). The handler is disabled if no api_token is configured.
+func authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// the handler holds the configuration itself
+		release := config.Hold()
+		token := Cfg.APIToken
+		release()
+
+		if token == "" {
+			http.Error(w, "Set the api_token of the configuration to enable this route.", http.StatusForbidden)
+			return
+		}
+		bearer := r.Header.Get("Authorization")
+		if !strings.HasPrefix(bearer, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(bearer, "Bearer ")), []byte(token)) != 1 {
+			Log.Warnf("Unauthorized %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// listExemptions returns the exemptions managed through the API (not the ones of the configuration)
+func listExemptions(w http.ResponseWriter, r *http.Request) {
+	defer config.Hold()()
+
+	exemptions := storeresults.GetExemptions()
+	if exemptions == nil {
+		exemptions = []config.Exemption{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(exemptions)
+}
+
+// createExemption stores the exemption of the request body, or replaces the one with the same name
+func createExemption(w http.ResponseWriter, r *http.Request) {
+	defer config.Hold()()
+
+	var exemption config.Exemption
+	if err := json.NewDecoder(r.Body).Decode(&exemption); err != nil {
+		http.Error(w, "Invalid exemption: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := exemption.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !exemption.IsActive(time.Now()) {
+		http.Error(w, fmt.Sprintf("Exemption: %s has already expired.", exemption.Name), http.StatusBadRequest)
+		return
+	}
+	if err := storeresults.StoreExemption(exemption); err != nil {
+		Log.Errorf("Error while storing the exemption %s: %s", exemption.Name, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	Log.Infof("Exemption %s approved by %s until %s stored from %s: %s", exemption.Name, exemption.Approver, exemption.Expires, r.RemoteAddr, exemption.Reason)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(exemption)
+}
+
+func deleteExemption(w http.ResponseWriter, r *http.Request) {
+	defer config.Hold()()
+
+	name := mux.Vars(r)["name"]
+	if err := storeresults.DeleteExemption(name); err != nil {
+		status := http.StatusInternalServerError
+		if err == storeresults.ErrExemptionNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	Log.Infof("Exemption %s deleted from %s", name, r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
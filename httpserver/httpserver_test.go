@@ -0,0 +1,58 @@
+package httpserver
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kreuzwerker/arebot/config"
+)
+
+func TestAuthorized(t *testing.T) {
+	handled := false
+	h := authorized(func(w http.ResponseWriter, r *http.Request) {
+		handled = true
+	})
+
+	expected := []struct {
+		token         string
+		authorization string
+		status        int
+	}{
+		{"", "", http.StatusForbidden},
+		{"", "Bearer ", http.StatusForbidden},
+		{"s3cr3t", "", http.StatusUnauthorized},
+		{"s3cr3t", "Bearer other", http.StatusUnauthorized},
+		{"s3cr3t", "s3cr3t", http.StatusUnauthorized},
+		{"s3cr3t", "Bearer s3cr3t", http.StatusOK},
+	}
+	for _, e := range expected {
+		Cfg = &config.Config{APIToken: e.token}
+		handled = false
+		r := httptest.NewRequest("DELETE", "/exemptions/legacy", nil)
+		if e.authorization != "" {
+			r.Header.Set("Authorization", e.authorization)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != e.status || handled != (e.status == http.StatusOK) {
+			t.Errorf("token %q, authorization %q: status == %d, handled == %v expected %d", e.token, e.authorization, w.Code, handled, e.status)
+		}
+	}
+}
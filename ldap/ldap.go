@@ -47,7 +47,7 @@ func LdapLookup(ldaphost string, ldapport int,
 	Log.Warn("Dial successful")
 	defer l.Close()
 	// First bind with a read only user
-	Log.Warnf("unam: %s, pwd: %s", bindusername, bindpassword)
+	Log.Debugf("Binding as %s", bindusername)
 	err = l.Bind(bindusername, bindpassword)
 	if err != nil {
 		Log.Warn(err.Error())
@@ -0,0 +1,158 @@
+package policytest
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+/*
+Package policytest checks the compliance policies of a configuration offline, against the test cases of
+a folder. A test case is a JSON file with the state of a resource, as returned by the AWS API, the event
+that changed it and the expected results of the checks:
+
+	{
+	  "resource_type": "security_group",
+	  "event": "AuthorizeSecurityGroupIngress",
+	  "resource": { "GroupId": "sg-0011aabb", "IpPermissions": [ ... ] },
+	  "expected": { "IpPermissions.IpRanges": "non-compliant", "Tag.Owner": "compliant" }
+	}
+
+The expected results are "compliant", "non-compliant" or "waived" (by an exemption of the configuration),
+the checks not listed are ignored.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kreuzwerker/arebot/cloudwatch"
+	"github.com/kreuzwerker/arebot/config"
+	"github.com/kreuzwerker/arebot/util"
+)
+
+// TestCase is a resource state checked by the policies, with the expected status of each check
+type TestCase struct {
+	// name of the case in the output, default: the path of its file in the folder
+	Name string `json:"name"`
+	// type of the resource, as in the resource blocks of the policies, e.g. "ec2_instance"
+	ResourceType string `json:"resource_type"`
+	// the API call that changed the resource, or its type for the resource blocks only
+	Event string `json:"event"`
+	// the event user, for the conditions of the checks (optional)
+	AccountId string `json:"account_id"`
+	Region    string `json:"region"`
+	Username  string `json:"username"`
+	// state of the resource in the JSON format of the AWS API, e.g. an ec2.Instance
+	Resource json.RawMessage `json:"resource"`
+	// expected status of the checks by name
+	Expected map[string]string `json:"expected"`
+}
+
+// Result is the outcome of a test case, it passed if it has no failures
+type Result struct {
+	Case     string
+	Failures []string
+}
+
+// Passed returns true if the results of the checks are the expected ones
+func (r Result) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// LoadCases returns the test cases of the .json files of the folder, walked recursively
+func LoadCases(dir string) ([]TestCase, error) {
+	var cases []TestCase
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var tc TestCase
+		if err := json.Unmarshal(data, &tc); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		if tc.Name == "" {
+			tc.Name, _ = filepath.Rel(dir, path)
+		}
+		cases = append(cases, tc)
+		return nil
+	})
+	return cases, err
+}
+
+// Run checks the resource of the test case with the policies of the configuration, as the event
+// handler would, and compares the results with the expected ones
+func Run(cfg *config.Config, tc TestCase) Result {
+	result := Result{Case: tc.Name}
+	fail := func(format string, args ...interface{}) Result {
+		result.Failures = append(result.Failures, fmt.Sprintf(format, args...))
+		return result
+	}
+
+	rt, ok := cloudwatch.GetResourceTypeByConfigName(tc.ResourceType)
+	if !ok || rt.FromState == nil {
+		return fail("%q is not a type of resource that can be tested", tc.ResourceType)
+	}
+	resource, err := rt.FromState(tc.Resource)
+	if err != nil {
+		return fail("invalid %s state: %s", tc.ResourceType, err)
+	}
+
+	eventuser := config.EventUserInfo{AccountId: tc.AccountId, Region: tc.Region, Username: tc.Username}
+	statuses := map[string][]string{}
+	_, apicalls := cfg.GetAPICallConfigs(tc.Event, tc.AccountId, util.GetVpcId(resource), rt.PolicyType, rt.ConfigName)
+	for _, apicall := range apicalls {
+		results := apicall.CheckCompliance(resource.GetProperties, resource.GetId(), eventuser)
+		for _, r := range cfg.WaiveExemptedResults(results, resource.GetProperties, nil) {
+			statuses[r.Check.Name] = append(statuses[r.Check.Name], r.Status())
+		}
+	}
+
+	var checks []string
+	for check := range tc.Expected {
+		checks = append(checks, check)
+	}
+	sort.Strings(checks)
+	for _, check := range checks {
+		expected := tc.Expected[check]
+		if len(statuses[check]) == 0 {
+			fail("%s: no result, expected %s", check, expected)
+		}
+		for _, status := range statuses[check] {
+			if status != expected {
+				fail("%s: %s, expected %s", check, status, expected)
+			}
+		}
+	}
+	return result
+}
+
+// String returns the outcome and the failures of the test case, one per line
+func (r Result) String() string {
+	if r.Passed() {
+		return "PASS " + r.Case
+	}
+	return "FAIL " + r.Case + "\n    " + strings.Join(r.Failures, "\n    ")
+}
@@ -0,0 +1,124 @@
+package policytest
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kreuzwerker/arebot/config"
+	_ "github.com/kreuzwerker/arebot/resource/ec2"
+	_ "github.com/kreuzwerker/arebot/resource/securitygroup"
+)
+
+func TestRun(t *testing.T) {
+
+	cfg, err := config.ParseConfig(policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "arebot-policytest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "ec2"), 0755)
+	for name, content := range map[string]string{"open-ssh.json": openSSHCase, "ec2/instance.json": instanceCase, "README.md": "not a case"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases, err := LoadCases(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) != 2 || cases[0].Name != "ec2/instance.json" || cases[1].Name != "open SSH" {
+		t.Fatalf("cases == %+v expected the 2 JSON files", cases)
+	}
+
+	instance := Run(cfg, cases[0])
+	expected := []string{"Tag.Name: no result, expected compliant", "Tag.Owner: compliant, expected non-compliant"}
+	if strings.Join(instance.Failures, "|") != strings.Join(expected, "|") {
+		t.Errorf("failures == %q expected %q", instance.Failures, expected)
+	}
+	if !strings.HasPrefix(instance.String(), "FAIL ec2/instance.json\n    Tag.Name") {
+		t.Errorf("output == %q", instance)
+	}
+	if sg := Run(cfg, cases[1]); !sg.Passed() {
+		t.Errorf("failures == %q expected none", sg.Failures)
+	}
+
+	cases[1].ResourceType = "s3_bucket"
+	if Run(cfg, cases[1]).Passed() {
+		t.Error("an unknown type of resource should fail")
+	}
+	cases[1].ResourceType, cases[1].Resource = "security_group", []byte(`{"GroupName": "web"}`)
+	if Run(cfg, cases[1]).Passed() {
+		t.Error("a state without ID should fail")
+	}
+}
+
+const policies = `
+security_group_policy "sg" {
+  api_call "AuthorizeSecurityGroupIngress" {
+    compliant "IpPermissions.IpRanges" {
+      deny_world = true
+    }
+    compliant "Tag.Owner" {
+      mandatory = true
+    }
+  }
+}
+
+ec2_policy "instances" {
+  resource "ec2_instance" {
+    compliant "Tag.Owner" {
+      mandatory = true
+    }
+  }
+}
+
+exemption "bastion" {
+  resource_ids = [ "sg-0011aabb" ]
+  checks = [ "Tag.Owner" ]
+  reason = "SSH bastion"
+  approver = "security@company.com"
+  expires = "2999-12-31"
+}
+`
+
+const openSSHCase = `{
+  "name": "open SSH",
+  "resource_type": "security_group",
+  "event": "AuthorizeSecurityGroupIngress",
+  "resource": {
+    "GroupId": "sg-0011aabb",
+    "IpPermissions": [ { "IpProtocol": "tcp", "FromPort": 22, "ToPort": 22, "IpRanges": [ { "CidrIp": "0.0.0.0/0" } ] } ]
+  },
+  "expected": { "IpPermissions.IpRanges": "non-compliant", "Tag.Owner": "waived" }
+}`
+
+const instanceCase = `{
+  "resource_type": "ec2_instance",
+  "event": "ModifyInstanceAttribute",
+  "resource": { "InstanceId": "i-0011aabb", "Tags": [ { "Key": "Owner", "Value": "team-a" } ] },
+  "expected": { "Tag.Owner": "non-compliant", "Tag.Name": "compliant" }
+}`
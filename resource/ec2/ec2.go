@@ -17,6 +17,7 @@ package ec2instance
 */
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -26,6 +27,7 @@ import (
 	"github.com/kreuzwerker/arebot/util"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
@@ -46,8 +48,9 @@ func newLogger() *logrus.Logger {
 
 // EC2Error error definition
 type EC2Error struct {
-	id     string
-	msg    string
+	id  string
+	msg string
+	// the resource does not exist (anymore): the event is not handled again
 	Ignore bool
 }
 
@@ -60,6 +63,12 @@ func NewEC2Error(id, msg string, ignore bool) EC2Error {
 	return EC2Error{id: id, msg: msg, Ignore: ignore}
 }
 
+// describeError returns the error of a failed describe call, ignored only if the resource does not exist: the
+// other errors (throttling, network, ...) are retried
+func describeError(id string, err error, notFoundCode string) EC2Error {
+	return NewEC2Error(id, err.Error(), util.IsAWSErrorCode(err, notFoundCode))
+}
+
 type EC2inst struct {
 	State  *ec2.Instance
 }
@@ -84,16 +93,16 @@ func NewEC2(ec2out *ec2.Instance) EC2inst {
 }
 
 // NewEC2WithStatus create a new EC2 object including the current status of the AWS resource
-func NewEC2WithStatus(eID string, accountID string) (EC2inst, error) {
+func NewEC2WithStatus(ctx context.Context, eID string, accountID string, region string) (EC2inst, error) {
 	desc := NewEC2(nil)
 
 	var reservation *ec2.Reservation
 	var err error
-	reservation, err = util.DescribeEC2ById(eID, accountID)
+	reservation, err = util.DescribeEC2ById(ctx, eID, accountID, region)
 
 	if err != nil {
 		Log.Errorf("ec2instance.NewEC2WithStatus: %s", err)
-		return desc, NewEC2Error(eID, err.Error(), true)
+		return desc, describeError(eID, err, "InvalidInstanceID.NotFound")
 	}
 
 	desc.State = reservation.Instances[0]
@@ -208,6 +217,10 @@ func (e *EC2inst) GetId() string {
 	return *e.State.InstanceId
 }
 
+func (e *EC2inst) GetVpcId() string {
+	return aws.StringValue(e.State.VpcId)
+}
+
 // NewVolume create a new Volume object
 func NewVolume(volOut *ec2.Volume) Volume {
 	vol := Volume{}
@@ -220,14 +233,14 @@ func NewVolume(volOut *ec2.Volume) Volume {
 }
 
 // NewVolumeWithStatus create a new Volume object including the current status of the AWS resource
-func NewVolumeWithStatus(vID string, accountID string) (Volume, error) {
+func NewVolumeWithStatus(ctx context.Context, vID string, accountID string, region string) (Volume, error) {
 	desc := NewVolume(nil)
 
-	state, err := util.DescribeVolumeById(vID, accountID)
+	state, err := util.DescribeVolumeById(ctx, vID, accountID, region)
 
 	if err != nil {
 		Log.Errorf("ec2instance.NewVolumeWithStatus: %s", err)
-		return desc, NewEC2Error(vID, err.Error(), true)
+		return desc, describeError(vID, err, "InvalidVolume.NotFound")
 	}
 
 	desc.State = state
@@ -373,14 +386,14 @@ func NewSnapshot(snap *ec2.Snapshot) Snapshot {
 }
 
 // NewSnapshotWithStatus create a new Snapshot object including the current status of the AWS resource
-func NewSnapshotWithStatus(snapId string, accountID string) (Snapshot, error) {
+func NewSnapshotWithStatus(ctx context.Context, snapId string, accountID string, region string) (Snapshot, error) {
 	desc := NewSnapshot(nil)
 
-	state, err := util.DescribeSnapshotById(snapId, accountID)
+	state, err := util.DescribeSnapshotById(ctx, snapId, accountID, region)
 
 	if err != nil {
 		Log.Errorf("ec2instance.NewSnapshotWithStatus: %s", err)
-		return desc, NewEC2Error(snapId, err.Error(), true)
+		return desc, describeError(snapId, err, "InvalidSnapshot.NotFound")
 	}
 
 	desc.State = state
@@ -0,0 +1,42 @@
+package ec2instance
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestDescribeError(t *testing.T) {
+
+	expected := []struct {
+		err    error
+		ignore bool
+	}{
+		{awserr.New("InvalidInstanceID.NotFound", "The instance ID 'i-0011aabb' does not exist", nil), true},
+		{awserr.New("Throttling", "Rate exceeded", nil), false},
+		{awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil), false},
+		{errors.New("dial tcp: i/o timeout"), false},
+	}
+	for _, e := range expected {
+		if err := describeError("i-0011aabb", e.err, "InvalidInstanceID.NotFound"); err.Ignore != e.ignore {
+			t.Errorf("Ignore == %v for %s, expected %v", err.Ignore, e.err, e.ignore)
+		}
+	}
+}
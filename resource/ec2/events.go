@@ -0,0 +1,192 @@
+package ec2instance
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/kreuzwerker/arebot/cloudwatch"
+	"github.com/kreuzwerker/arebot/util"
+)
+
+func init() {
+	cloudwatch.RegisterResourceType(cloudwatch.ResourceType{
+		Name:       "ec2 instance",
+		ConfigName: "ec2_instance",
+		IdPrefix:   "i",
+		PolicyType: "ec2",
+		New: func(ctx context.Context, id string, accountId string, region string) (util.AwsResourceType, error) {
+			e, err := NewEC2WithStatus(ctx, id, accountId, region)
+			return &e, err
+		},
+		FromState: func(state []byte) (util.AwsResourceType, error) {
+			var instance ec2.Instance
+			if err := decodeState(state, &instance, func() bool { return instance.InstanceId != nil }); err != nil {
+				return nil, err
+			}
+			e := NewEC2(&instance)
+			return &e, nil
+		},
+		Properties: []string{"ImageId", "InstanceType", "Placement.AvailabilityZone", "Placement.GroupName", "Placement.Tenancy",
+			"PrivateIpAddress", "PublicIpAddress", "RootDeviceName", "RootDeviceType", "Tag.", "Tag:Value.", "Tag:Pair."},
+	})
+	cloudwatch.RegisterResourceType(cloudwatch.ResourceType{
+		Name:       "volume",
+		ConfigName: "ec2_volume",
+		IdPrefix:   "vol",
+		PolicyType: "ec2",
+		New: func(ctx context.Context, id string, accountId string, region string) (util.AwsResourceType, error) {
+			v, err := NewVolumeWithStatus(ctx, id, accountId, region)
+			return &v, err
+		},
+		FromState: func(state []byte) (util.AwsResourceType, error) {
+			var volume ec2.Volume
+			if err := decodeState(state, &volume, func() bool { return volume.VolumeId != nil }); err != nil {
+				return nil, err
+			}
+			v := NewVolume(&volume)
+			return &v, nil
+		},
+		Properties: []string{"Attachments.AttachTime", "Attachments.DeleteOnTermination", "Attachments.Device", "Attachments.InstanceId",
+			"Attachments.Status", "AvailabilityZone", "CreateTime", "Iops", "Size", "SnapshotId", "Status", "VolumeType", "Tag.", "Tag:Value.", "Tag:Pair."},
+	})
+	cloudwatch.RegisterResourceType(cloudwatch.ResourceType{
+		Name:       "snapshot",
+		ConfigName: "ec2_snapshot",
+		IdPrefix:   "snap",
+		PolicyType: "ec2",
+		New: func(ctx context.Context, id string, accountId string, region string) (util.AwsResourceType, error) {
+			s, err := NewSnapshotWithStatus(ctx, id, accountId, region)
+			return &s, err
+		},
+		FromState: func(state []byte) (util.AwsResourceType, error) {
+			var snapshot ec2.Snapshot
+			if err := decodeState(state, &snapshot, func() bool { return snapshot.SnapshotId != nil }); err != nil {
+				return nil, err
+			}
+			s := NewSnapshot(&snapshot)
+			return &s, nil
+		},
+		Properties: []string{"Description", "StartTime", "Encrypted", "VolumeSize", "VolumeId", "Status", "OwnerAlias", "OwnerId", "Tag.", "Tag:Value.", "Tag:Pair."},
+	})
+
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames: []string{"RunInstances", "StartInstances"},
+		ResourceIds: func(e cloudwatch.AWSEvent) ([]string, error) {
+			return util.ParseEC2ResponseRunStartInstances(e.ApiDetail.ResponseElements, "instanceId")
+		},
+	})
+	// only checked by the resource blocks of the policies
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames:        []string{"ModifyInstanceAttribute"},
+		RequestParameters: func() interface{} { return &cloudwatch.InstanceRequestParameters{} },
+		ResourceIds: func(e cloudwatch.AWSEvent) ([]string, error) {
+			return []string{e.RequestParameter.(*cloudwatch.InstanceRequestParameters).InstanceId}, nil
+		},
+	})
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames:  []string{"CreateVolume", "AttachVolume"},
+		ResourceIds: responseElementId("volumeId"),
+	})
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames:  []string{"CreateSnapshot"},
+		ResourceIds: responseElementId("snapshotId"),
+	})
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames:        []string{"DeleteVolume"},
+		RequestParameters: func() interface{} { return &cloudwatch.VolumeRequestParameters{} },
+		ResourceIds: func(e cloudwatch.AWSEvent) ([]string, error) {
+			return []string{e.RequestParameter.(*cloudwatch.VolumeRequestParameters).VolumeId}, nil
+		},
+		Deletes: true,
+	})
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames:        []string{"DeleteSnapshot"},
+		RequestParameters: func() interface{} { return &cloudwatch.SnapshotRequestParameters{} },
+		ResourceIds: func(e cloudwatch.AWSEvent) ([]string, error) {
+			return []string{e.RequestParameter.(*cloudwatch.SnapshotRequestParameters).SnapshotId}, nil
+		},
+		Deletes: true,
+	})
+
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames: []string{"TerminateInstances"},
+		ResourceIds: func(e cloudwatch.AWSEvent) ([]string, error) {
+			return util.ParseEC2ResponseRunStartInstances(e.ApiDetail.ResponseElements, "instanceId")
+		},
+		Deletes: true,
+	})
+
+	// CloudWatch events that are not API calls: the instances launched by Auto Scaling
+	// never appear in a RunInstances call of a user
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames:        []string{"EC2 Instance State-change Notification"},
+		RequestParameters: func() interface{} { return &cloudwatch.EC2Detail{} },
+		ResourceIds: func(e cloudwatch.AWSEvent) ([]string, error) {
+			detail := e.RequestParameter.(*cloudwatch.EC2Detail)
+			// the instances are checked once they are running, like after RunInstances/StartInstances
+			if detail.State != "running" && detail.State != "terminated" {
+				return nil, nil
+			}
+			return []string{detail.InstanceID}, nil
+		},
+		IsDeletion: func(e cloudwatch.AWSEvent) bool {
+			return e.RequestParameter.(*cloudwatch.EC2Detail).State == "terminated"
+		},
+	})
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames:        []string{"EC2 Instance Launch Successful"},
+		RequestParameters: func() interface{} { return &cloudwatch.AutoScalingGroupDetail{} },
+		ResourceIds:       autoScalingInstanceId,
+	})
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames:        []string{"EC2 Instance Terminate Successful"},
+		RequestParameters: func() interface{} { return &cloudwatch.AutoScalingGroupDetail{} },
+		ResourceIds:       autoScalingInstanceId,
+		Deletes:           true,
+	})
+}
+
+// decodeState decodes the JSON state of a resource, which must have an ID
+func decodeState(state []byte, v interface{}, hasId func() bool) error {
+	if err := json.Unmarshal(state, v); err != nil {
+		return err
+	}
+	if !hasId() {
+		return errors.New("the state has no ID")
+	}
+	return nil
+}
+
+func autoScalingInstanceId(e cloudwatch.AWSEvent) ([]string, error) {
+	id := e.RequestParameter.(*cloudwatch.AutoScalingGroupDetail).EC2InstanceID
+	if id == "" {
+		return nil, cloudwatch.NewDecodeError(e.ApiCall + " event without EC2InstanceId")
+	}
+	return []string{id}, nil
+}
+
+func responseElementId(key string) func(e cloudwatch.AWSEvent) ([]string, error) {
+	return func(e cloudwatch.AWSEvent) ([]string, error) {
+		id, err := e.ResponseElement(key)
+		return []string{id}, err
+	}
+}
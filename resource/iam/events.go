@@ -0,0 +1,121 @@
+package iam
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+
+	"github.com/kreuzwerker/arebot/cloudwatch"
+	"github.com/kreuzwerker/arebot/util"
+)
+
+func init() {
+	cloudwatch.RegisterResourceType(cloudwatch.ResourceType{
+		Name:       "IAM user",
+		ConfigName: "iam_user",
+		IdPattern:  regexp.MustCompile(`^arn:[^:]+:iam::\d{12}:user/`),
+		PolicyType: "iam",
+		New: func(ctx context.Context, id string, accountId string, region string) (util.AwsResourceType, error) {
+			u, err := NewUserWithStatus(ctx, id, accountId, region)
+			return &u, err
+		},
+		FromState: func(state []byte) (util.AwsResourceType, error) {
+			var desc util.IAMUserDescription
+			if err := json.Unmarshal(state, &desc); err != nil {
+				return nil, err
+			}
+			if desc.User == nil || desc.User.Arn == nil || desc.User.UserName == nil {
+				return nil, errors.New("the state has no User.Arn and User.UserName")
+			}
+			u := NewUser(&desc)
+			return &u, nil
+		},
+		Properties: []string{"UserName", "Path", "AccessKeys.Id", "AccessKeys.Age", "MFADevices", "AttachedPolicies", "InlinePolicies",
+			"Policy.WildcardActions", "Policy.WildcardResources"},
+	})
+	cloudwatch.RegisterResourceType(cloudwatch.ResourceType{
+		Name:       "IAM role",
+		ConfigName: "iam_role",
+		IdPattern:  regexp.MustCompile(`^arn:[^:]+:iam::\d{12}:role/`),
+		PolicyType: "iam",
+		New: func(ctx context.Context, id string, accountId string, region string) (util.AwsResourceType, error) {
+			r, err := NewRoleWithStatus(ctx, id, accountId, region)
+			return &r, err
+		},
+		FromState: func(state []byte) (util.AwsResourceType, error) {
+			var desc util.IAMRoleDescription
+			if err := json.Unmarshal(state, &desc); err != nil {
+				return nil, err
+			}
+			if desc.Role == nil || desc.Role.Arn == nil || desc.Role.RoleName == nil {
+				return nil, errors.New("the state has no Role.Arn and Role.RoleName")
+			}
+			r := NewRole(&desc)
+			return &r, nil
+		},
+		Properties: []string{"RoleName", "Path", "AttachedPolicies", "InlinePolicies", "Policy.WildcardActions", "Policy.WildcardResources",
+			"TrustPolicy.Principals", "TrustPolicy.ExternalAccounts"},
+	})
+
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames: []string{"CreateUser", "CreateAccessKey", "UpdateAccessKey", "DeleteAccessKey", "EnableMFADevice", "DeactivateMFADevice",
+			"AttachUserPolicy", "DetachUserPolicy", "PutUserPolicy", "DeleteUserPolicy"},
+		RequestParameters: func() interface{} { return &cloudwatch.IAMRequestParameters{} },
+		ResourceIds:       requestUserArn,
+	})
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames:        []string{"DeleteUser"},
+		RequestParameters: func() interface{} { return &cloudwatch.IAMRequestParameters{} },
+		ResourceIds:       requestUserArn,
+		Deletes:           true,
+	})
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames:        []string{"CreateRole", "UpdateAssumeRolePolicy", "AttachRolePolicy", "DetachRolePolicy", "PutRolePolicy", "DeleteRolePolicy"},
+		RequestParameters: func() interface{} { return &cloudwatch.IAMRequestParameters{} },
+		ResourceIds:       requestRoleArn,
+	})
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames:        []string{"DeleteRole"},
+		RequestParameters: func() interface{} { return &cloudwatch.IAMRequestParameters{} },
+		ResourceIds:       requestRoleArn,
+		Deletes:           true,
+	})
+}
+
+// requestUserArn returns the user of the request, or the IAM user calling CreateAccessKey for itself. The ARN is in the
+// partition of the caller (e.g. "aws-cn"), as the users and roles are.
+func requestUserArn(e cloudwatch.AWSEvent) ([]string, error) {
+	name := e.RequestParameter.(*cloudwatch.IAMRequestParameters).UserName
+	if name == "" && e.ApiDetail.UserIdentity.Type == "IAMUser" {
+		name = arnName(e.ApiDetail.UserIdentity.ARN)
+	}
+	if name == "" {
+		return nil, cloudwatch.NewDecodeError("missing userName in the request parameters")
+	}
+	return []string{UserArn(arnPartition(e.ApiDetail.UserIdentity.ARN), e.Event.Account, name)}, nil
+}
+
+func requestRoleArn(e cloudwatch.AWSEvent) ([]string, error) {
+	name := e.RequestParameter.(*cloudwatch.IAMRequestParameters).RoleName
+	if name == "" {
+		return nil, cloudwatch.NewDecodeError("missing roleName in the request parameters")
+	}
+	return []string{RoleArn(arnPartition(e.ApiDetail.UserIdentity.ARN), e.Event.Account, name)}, nil
+}
@@ -0,0 +1,384 @@
+package iam
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+
+	"github.com/kreuzwerker/arebot/config"
+	"github.com/kreuzwerker/arebot/util"
+)
+
+var (
+	// Log Logger for this package
+	Log = newLogger()
+	// Cfg Config for this package
+	Cfg *config.Config
+
+	// the current time, for the age of the access keys
+	timeNow = time.Now
+)
+
+func newLogger() *logrus.Logger {
+	_log := logrus.New()
+	_log.Out = os.Stdout
+	_log.Formatter = &logrus.TextFormatter{FullTimestamp: true}
+	_log.Level = logrus.DebugLevel
+	return _log
+}
+
+// IAMError error definition
+type IAMError struct {
+	id     string
+	msg    string
+	Ignore bool
+}
+
+func (e IAMError) Error() string {
+	return fmt.Sprintf("IAM error %s: %s", e.id, e.msg)
+}
+
+// NewIAMError create new IAMError
+func NewIAMError(id, msg string, ignore bool) IAMError {
+	return IAMError{id: id, msg: msg, Ignore: ignore}
+}
+
+// describeError returns the error of a failed describe call, ignored only if the user or role does not exist:
+// the other errors (throttling, access denied, ...) are retried
+func describeError(id string, err error) IAMError {
+	return NewIAMError(id, err.Error(), util.IsAWSErrorCode(err, "NoSuchEntity"))
+}
+
+type User struct {
+	State *util.IAMUserDescription
+}
+
+type Role struct {
+	State *util.IAMRoleDescription
+}
+
+// UserArn returns the ID of the user: its ARN, without its path. The partition is "aws", or e.g. "aws-cn" in China.
+func UserArn(partition string, accountID string, name string) string {
+	return "arn:" + partition + ":iam::" + accountID + ":user/" + name
+}
+
+// RoleArn returns the ID of the role: its ARN, without its path
+func RoleArn(partition string, accountID string, name string) string {
+	return "arn:" + partition + ":iam::" + accountID + ":role/" + name
+}
+
+// arnPartition returns the partition of the ARN, "aws" for an invalid ARN
+func arnPartition(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 || parts[1] == "" {
+		return "aws"
+	}
+	return parts[1]
+}
+
+// arnName returns the name of the user or role of the ARN, the part after the last slash
+func arnName(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+// arnAccount returns the account ID of the ARN
+func arnAccount(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[4]
+}
+
+// NewUser create a new User object
+func NewUser(state *util.IAMUserDescription) User {
+	if state == nil {
+		state = &util.IAMUserDescription{}
+	}
+	if state.User == nil {
+		state.User = new(iam.User)
+	}
+	return User{State: state}
+}
+
+// NewUserWithStatus create a new User object including the current status of the IAM user
+func NewUserWithStatus(ctx context.Context, id string, accountID string, region string) (User, error) {
+	desc := NewUser(nil)
+
+	state, err := util.DescribeIAMUser(ctx, arnName(id), accountID, region)
+	if err != nil {
+		Log.Errorf("iam.NewUserWithStatus: %s", err)
+		return desc, describeError(id, err)
+	}
+
+	desc.State = state
+	return desc, nil
+}
+
+// NewRole create a new Role object
+func NewRole(state *util.IAMRoleDescription) Role {
+	if state == nil {
+		state = &util.IAMRoleDescription{}
+	}
+	if state.Role == nil {
+		state.Role = new(iam.Role)
+	}
+	return Role{State: state}
+}
+
+// NewRoleWithStatus create a new Role object including the current status of the IAM role
+func NewRoleWithStatus(ctx context.Context, id string, accountID string, region string) (Role, error) {
+	desc := NewRole(nil)
+
+	state, err := util.DescribeIAMRole(ctx, arnName(id), accountID, region)
+	if err != nil {
+		Log.Errorf("iam.NewRoleWithStatus: %s", err)
+		return desc, describeError(id, err)
+	}
+
+	desc.State = state
+	return desc, nil
+}
+
+// GetProperties returns the given properties for <key> argument
+func (u *User) GetProperties(key string) []string {
+	/*
+		UserName
+		Path
+		AccessKeys (the active ones)
+			Id
+			Age (in days)
+		MFADevices (serial numbers)
+		AttachedPolicies (ARNs of the managed policies)
+		InlinePolicies (names)
+		Policy (the Allow statements of the inline and attached policies, as <policy name>:<value>)
+			WildcardActions
+			WildcardResources
+	*/
+	var result []string
+
+	splitKey := strings.Split(key, ".")
+	Log.Debugf("IAM user Property keys: %+v", splitKey)
+
+	state := u.State
+	switch splitKey[0] {
+	case "UserName":
+		result = appendValue(result, state.User.UserName)
+	case "Path":
+		result = appendValue(result, state.User.Path)
+	case "AccessKeys":
+		if len(splitKey) < 2 {
+			return nil
+		}
+		for _, accessKey := range state.AccessKeys {
+			if aws.StringValue(accessKey.Status) != "Active" {
+				continue
+			}
+			switch splitKey[1] {
+			case "Id":
+				result = appendValue(result, accessKey.AccessKeyId)
+			case "Age":
+				if accessKey.CreateDate != nil {
+					result = append(result, strconv.Itoa(int(timeNow().Sub(*accessKey.CreateDate).Hours()/24)))
+				}
+			default:
+				Log.Warnf("IAMUser.GetProperties: Configuration AccessKeys.%s is not supported!", splitKey[1])
+				return nil
+			}
+		}
+	case "MFADevices":
+		for _, device := range state.MFADevices {
+			result = appendValue(result, device.SerialNumber)
+		}
+	case "AttachedPolicies":
+		result = attachedPolicies(state.AttachedPolicies)
+	case "InlinePolicies":
+		result = policyNames(state.InlinePolicies)
+	case "Policy":
+		result = wildcards(key, state.InlinePolicies, state.AttachedPolicyDocuments)
+	}
+
+	Log.Debugf("IAMUser.GetProperties: Found %s: %v", key, result)
+	return result
+}
+
+func (u *User) GetId() string {
+	arn := aws.StringValue(u.State.User.Arn)
+	return UserArn(arnPartition(arn), arnAccount(arn), aws.StringValue(u.State.User.UserName))
+}
+
+// GetProperties returns the given properties for <key> argument
+func (r *Role) GetProperties(key string) []string {
+	/*
+		RoleName
+		Path
+		AttachedPolicies (ARNs of the managed policies)
+		InlinePolicies (names)
+		Policy (the Allow statements of the inline and attached policies, as <policy name>:<value>)
+			WildcardActions
+			WildcardResources
+		TrustPolicy (the Allow statements of the trust policy)
+			Principals
+			ExternalAccounts (of the AWS principals: "*" or the accounts without account block)
+	*/
+	var result []string
+
+	splitKey := strings.Split(key, ".")
+	Log.Debugf("IAM role Property keys: %+v", splitKey)
+
+	state := r.State
+	switch splitKey[0] {
+	case "RoleName":
+		result = appendValue(result, state.Role.RoleName)
+	case "Path":
+		result = appendValue(result, state.Role.Path)
+	case "AttachedPolicies":
+		result = attachedPolicies(state.AttachedPolicies)
+	case "InlinePolicies":
+		result = policyNames(state.InlinePolicies)
+	case "Policy":
+		result = wildcards(key, state.InlinePolicies, state.AttachedPolicyDocuments)
+	case "TrustPolicy":
+		if len(splitKey) < 2 {
+			return nil
+		}
+		statements, err := util.ParsePolicyDocument(aws.StringValue(state.Role.AssumeRolePolicyDocument))
+		if err != nil {
+			Log.Warnf("IAMRole.GetProperties: invalid trust policy of %s: %s", aws.StringValue(state.Role.RoleName), err)
+			return nil
+		}
+		for _, s := range statements {
+			if s.Effect != "Allow" {
+				continue
+			}
+			switch splitKey[1] {
+			case "Principals":
+				result = append(result, s.Principals...)
+			case "ExternalAccounts":
+				result = append(result, r.externalAccounts(s.Principals)...)
+			default:
+				Log.Warnf("IAMRole.GetProperties: Configuration TrustPolicy.%s is not supported!", splitKey[1])
+				return nil
+			}
+		}
+	}
+
+	Log.Debugf("IAMRole.GetProperties: Found %s: %v", key, result)
+	return result
+}
+
+func (r *Role) GetId() string {
+	arn := aws.StringValue(r.State.Role.Arn)
+	return RoleArn(arnPartition(arn), arnAccount(arn), aws.StringValue(r.State.Role.RoleName))
+}
+
+// externalAccounts returns the accounts of the AWS principals that are neither the account of the role
+// nor one of the accounts of the configuration; "*" for everyone
+func (r *Role) externalAccounts(principals []string) []string {
+	var result []string
+	for _, principal := range principals {
+		// the services, the identity providers and the canonical users are not accounts
+		if strings.HasPrefix(principal, "Service:") || strings.HasPrefix(principal, "Federated:") || strings.HasPrefix(principal, "CanonicalUser:") {
+			continue
+		}
+		account := principal
+		if strings.HasPrefix(principal, "arn:") {
+			account = arnAccount(principal)
+		}
+		if account == arnAccount(aws.StringValue(r.State.Role.Arn)) || (Cfg != nil && Cfg.GetAccount(account) != nil) {
+			continue
+		}
+		result = append(result, account)
+	}
+	return result
+}
+
+func appendValue(result []string, value *string) []string {
+	if value == nil {
+		return result
+	}
+	return append(result, *value)
+}
+
+func policyNames(policies map[string]string) []string {
+	var names []string
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func attachedPolicies(policies []*iam.AttachedPolicy) []string {
+	var result []string
+	for _, policy := range policies {
+		result = appendValue(result, policy.PolicyArn)
+	}
+	return result
+}
+
+// wildcards returns the actions (Policy.WildcardActions) or the resources (Policy.WildcardResources) with a
+// wildcard of the Allow statements of the policy documents, e.g. "admin:*" for the Action "*" of the policy admin
+func wildcards(key string, documents ...map[string]string) []string {
+	var result []string
+	for _, policies := range documents {
+		result = append(result, policyWildcards(key, policies)...)
+	}
+	return result
+}
+
+func policyWildcards(key string, policies map[string]string) []string {
+	var result []string
+	for _, name := range policyNames(policies) {
+		statements, err := util.ParsePolicyDocument(policies[name])
+		if err != nil {
+			Log.Warnf("IAM.GetProperties: invalid policy %s: %s", name, err)
+			continue
+		}
+		for _, s := range statements {
+			if s.Effect != "Allow" {
+				continue
+			}
+			var values []string
+			switch key {
+			case "Policy.WildcardActions":
+				values = s.Actions
+			case "Policy.WildcardResources":
+				values = s.Resources
+			default:
+				Log.Warnf("IAM.GetProperties: Configuration %s is not supported!", key)
+				return nil
+			}
+			for _, value := range values {
+				if strings.Contains(value, "*") {
+					result = append(result, name+":"+value)
+				}
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,191 @@
+package iam
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+
+	"github.com/kreuzwerker/arebot/cloudwatch"
+	"github.com/kreuzwerker/arebot/config"
+	"github.com/kreuzwerker/arebot/util"
+)
+
+func TestUserProperties(t *testing.T) {
+
+	timeNow = func() time.Time { return time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC) }
+	defer func() { timeNow = time.Now }()
+
+	var state util.IAMUserDescription
+	if err := json.Unmarshal([]byte(userState), &state); err != nil {
+		t.Fatal(err)
+	}
+	user := NewUser(&state)
+	if user.GetId() != "arn:aws:iam::000011112222:user/alice" {
+		t.Errorf("id == %s expected the ARN without the path", user.GetId())
+	}
+
+	expected := map[string][]string{
+		"UserName":                 {"alice"},
+		"AccessKeys.Age":           {"122"},
+		"AccessKeys.Id":            {"AKIA0001"},
+		"MFADevices":               nil,
+		"AttachedPolicies":         {"arn:aws:iam::aws:policy/ReadOnlyAccess"},
+		"InlinePolicies":           {"admin", "logs"},
+		"Policy.WildcardActions":   {"admin:*", "logs:logs:*"},
+		"Policy.WildcardResources": {"admin:*"},
+	}
+	for key, values := range expected {
+		if properties := user.GetProperties(key); !reflect.DeepEqual(properties, values) {
+			t.Errorf("%s == %q expected %q", key, properties, values)
+		}
+	}
+}
+
+func TestRoleProperties(t *testing.T) {
+
+	var err error
+	Cfg, err = config.ParseConfig(`
+account "partner" {
+  account_id = "333344445555"
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { Cfg = nil }()
+
+	var state util.IAMRoleDescription
+	if err := json.Unmarshal([]byte(roleState), &state); err != nil {
+		t.Fatal(err)
+	}
+	role := NewRole(&state)
+	expected := map[string][]string{
+		"RoleName": {"deploy"},
+		"TrustPolicy.Principals": {"arn:aws:iam::000011112222:root", "arn:aws:iam::333344445555:root", "999988887777",
+			"Service:ec2.amazonaws.com"},
+		"TrustPolicy.ExternalAccounts": {"999988887777"},
+		"Policy.WildcardActions":       {"AdministratorAccess:*"},
+	}
+	for key, values := range expected {
+		if properties := role.GetProperties(key); !reflect.DeepEqual(properties, values) {
+			t.Errorf("%s == %q expected %q", key, properties, values)
+		}
+	}
+}
+
+func TestDescribeError(t *testing.T) {
+	expected := []struct {
+		err    error
+		ignore bool
+	}{
+		{awserr.New("NoSuchEntity", "The user with name alice cannot be found.", nil), true},
+		{awserr.New("Throttling", "Rate exceeded", nil), false},
+		{awserr.New("AccessDenied", "User is not authorized to perform: iam:GetUser", nil), false},
+		{errors.New("dial tcp: i/o timeout"), false},
+	}
+	for _, e := range expected {
+		if err := describeError(UserArn("aws", "000011112222", "alice"), e.err); err.Ignore != e.ignore {
+			t.Errorf("Ignore == %v for %s, expected %v", err.Ignore, e.err, e.ignore)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+
+	for id, configName := range map[string]string{"arn:aws:iam::000011112222:user/alice": "iam_user", "arn:aws:iam::000011112222:role/deploy": "iam_role",
+		"arn:aws-cn:iam::000011112222:user/alice": "iam_user", "arn:aws-us-gov:iam::000011112222:role/deploy": "iam_role"} {
+		if rt, ok := cloudwatch.GetResourceType(id); !ok || rt.ConfigName != configName || rt.PolicyType != "iam" {
+			t.Errorf("resource type of %s == %+v expected %s", id, rt, configName)
+		}
+	}
+
+	// an IAM user creating its own access key
+	event := cloudwatch.AWSEvent{Event: cloudwatch.Event{Account: "000011112222"}, RequestParameter: &cloudwatch.IAMRequestParameters{}}
+	event.ApiDetail.UserIdentity = cloudwatch.UserIdentity{Type: "IAMUser", ARN: "arn:aws:iam::000011112222:user/dev/bob"}
+	decoder, _ := cloudwatch.GetEventDecoder("CreateAccessKey")
+	if ids, err := decoder.ResourceIds(event); err != nil || !reflect.DeepEqual(ids, []string{"arn:aws:iam::000011112222:user/bob"}) {
+		t.Errorf("ids == %v, %v expected the calling user", ids, err)
+	}
+
+	// the changes of the policies and of the access keys check the user or the role again
+	event.RequestParameter = &cloudwatch.IAMRequestParameters{UserName: "alice"}
+	for _, apiCall := range []string{"PutUserPolicy", "AttachUserPolicy", "DetachUserPolicy", "DeleteUserPolicy", "UpdateAccessKey", "DeleteAccessKey"} {
+		decoder, ok := cloudwatch.GetEventDecoder(apiCall)
+		if !ok {
+			t.Errorf("%s should be handled", apiCall)
+			continue
+		}
+		if ids, err := decoder.ResourceIds(event); err != nil || !reflect.DeepEqual(ids, []string{"arn:aws:iam::000011112222:user/alice"}) {
+			t.Errorf("%s: ids == %v, %v expected the user", apiCall, ids, err)
+		}
+	}
+	event.RequestParameter = &cloudwatch.IAMRequestParameters{RoleName: "deploy"}
+	for _, apiCall := range []string{"UpdateAssumeRolePolicy", "PutRolePolicy", "AttachRolePolicy", "DetachRolePolicy", "DeleteRolePolicy"} {
+		decoder, ok := cloudwatch.GetEventDecoder(apiCall)
+		if !ok {
+			t.Errorf("%s should be handled", apiCall)
+			continue
+		}
+		if ids, err := decoder.ResourceIds(event); err != nil || !reflect.DeepEqual(ids, []string{"arn:aws:iam::000011112222:role/deploy"}) {
+			t.Errorf("%s: ids == %v, %v expected the role", apiCall, ids, err)
+		}
+	}
+
+	// the users and roles are in the partition of the caller
+	event.ApiDetail.UserIdentity = cloudwatch.UserIdentity{Type: "IAMUser", ARN: "arn:aws-cn:iam::000011112222:user/dev/bob"}
+	decoder, _ = cloudwatch.GetEventDecoder("AttachRolePolicy")
+	if ids, err := decoder.ResourceIds(event); err != nil || !reflect.DeepEqual(ids, []string{"arn:aws-cn:iam::000011112222:role/deploy"}) {
+		t.Errorf("ids == %v, %v expected the role in the aws-cn partition", ids, err)
+	}
+	user := NewUser(nil)
+	user.State.User.Arn = aws.String("arn:aws-us-gov:iam::000011112222:user/dev/alice")
+	user.State.User.UserName = aws.String("alice")
+	if user.GetId() != "arn:aws-us-gov:iam::000011112222:user/alice" {
+		t.Errorf("GetId() == %s expected the ARN in the aws-us-gov partition", user.GetId())
+	}
+}
+
+const userState = `{
+  "User": { "Arn": "arn:aws:iam::000011112222:user/dev/alice", "UserName": "alice", "Path": "/dev/" },
+  "AccessKeys": [
+    { "AccessKeyId": "AKIA0001", "Status": "Active", "CreateDate": "2017-03-01T10:00:00Z" },
+    { "AccessKeyId": "AKIA0002", "Status": "Inactive", "CreateDate": "2016-01-01T10:00:00Z" }
+  ],
+  "AttachedPolicies": [ { "PolicyArn": "arn:aws:iam::aws:policy/ReadOnlyAccess", "PolicyName": "ReadOnlyAccess" } ],
+  "InlinePolicies": {
+    "admin": "{\"Statement\": {\"Effect\": \"Allow\", \"Action\": \"*\", \"Resource\": \"*\"}}",
+    "logs": "{\"Statement\": [{\"Effect\": \"Allow\", \"Action\": [\"logs:*\", \"s3:GetObject\"], \"Resource\": \"arn:aws:logs:eu-west-1:000011112222:log-group:app\"}, {\"Effect\": \"Deny\", \"Action\": \"iam:*\", \"Resource\": \"*\"}]}"
+  }
+}`
+
+const roleState = `{
+  "Role": {
+    "Arn": "arn:aws:iam::000011112222:role/deploy",
+    "RoleName": "deploy",
+    "AssumeRolePolicyDocument": "{\"Statement\": [{\"Effect\": \"Allow\", \"Principal\": {\"AWS\": [\"arn:aws:iam::000011112222:root\", \"arn:aws:iam::333344445555:root\", \"999988887777\"], \"Service\": \"ec2.amazonaws.com\"}, \"Action\": \"sts:AssumeRole\"}]}"
+  },
+  "AttachedPolicies": [ { "PolicyArn": "arn:aws:iam::aws:policy/AdministratorAccess", "PolicyName": "AdministratorAccess" } ],
+  "AttachedPolicyDocuments": {
+    "AdministratorAccess": "{\"Version\": \"2012-10-17\", \"Statement\": [{\"Effect\": \"Allow\", \"Action\": \"*\", \"Resource\": \"*\"}]}"
+  }
+}`
@@ -0,0 +1,75 @@
+package s3bucket
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+
+	"github.com/kreuzwerker/arebot/cloudwatch"
+	"github.com/kreuzwerker/arebot/util"
+)
+
+func init() {
+	cloudwatch.RegisterResourceType(cloudwatch.ResourceType{
+		Name:       "bucket",
+		ConfigName: "s3_bucket",
+		IdPattern:  regexp.MustCompile("^" + regexp.QuoteMeta(arnPrefix) + "[^/]+$"),
+		PolicyType: "s3",
+		New: func(ctx context.Context, id string, accountId string, region string) (util.AwsResourceType, error) {
+			b, err := NewBucketWithStatus(ctx, id, accountId, region)
+			return &b, err
+		},
+		FromState: func(state []byte) (util.AwsResourceType, error) {
+			var desc util.BucketDescription
+			if err := json.Unmarshal(state, &desc); err != nil {
+				return nil, err
+			}
+			if desc.Name == "" {
+				return nil, errors.New("the state has no Name")
+			}
+			b := NewBucket(&desc)
+			return &b, nil
+		},
+		Properties: []string{"Encryption.Algorithm", "Encryption.KMSMasterKeyID", "Versioning.Status", "Versioning.MFADelete",
+			"PublicAccessBlock.BlockPublicAcls", "PublicAccessBlock.IgnorePublicAcls", "PublicAccessBlock.BlockPublicPolicy",
+			"PublicAccessBlock.RestrictPublicBuckets", "Grants", "Policy.Principals", "Logging.TargetBucket", "Logging.TargetPrefix",
+			"Lifecycle.Rule", "Lifecycle.ExpirationDays", "Tag.", "Tag:Value.", "Tag:Pair."},
+	})
+
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames:        []string{"CreateBucket", "PutBucketTagging", "PutBucketAcl", "PutBucketPolicy", "PutBucketEncryption"},
+		RequestParameters: func() interface{} { return &cloudwatch.BucketRequestParameters{} },
+		ResourceIds:       requestBucketArn,
+	})
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames:        []string{"DeleteBucket"},
+		RequestParameters: func() interface{} { return &cloudwatch.BucketRequestParameters{} },
+		ResourceIds:       requestBucketArn,
+		Deletes:           true,
+	})
+}
+
+func requestBucketArn(e cloudwatch.AWSEvent) ([]string, error) {
+	name := e.RequestParameter.(*cloudwatch.BucketRequestParameters).BucketName
+	if name == "" {
+		return nil, cloudwatch.NewDecodeError("missing bucketName in the request parameters")
+	}
+	return []string{BucketArn(name)}, nil
+}
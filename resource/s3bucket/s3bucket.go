@@ -0,0 +1,299 @@
+package s3bucket
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/kreuzwerker/arebot/config"
+	"github.com/kreuzwerker/arebot/util"
+)
+
+// the buckets are identified by their ARN, e.g. "arn:aws:s3:::my-bucket"
+const arnPrefix = "arn:aws:s3:::"
+
+var (
+	// Log Logger for this package
+	Log = newLogger()
+	// Cfg Config for this package
+	Cfg *config.Config
+)
+
+func newLogger() *logrus.Logger {
+	_log := logrus.New()
+	_log.Out = os.Stdout
+	_log.Formatter = &logrus.TextFormatter{FullTimestamp: true}
+	_log.Level = logrus.DebugLevel
+	return _log
+}
+
+// BucketError error definition
+type BucketError struct {
+	id     string
+	msg    string
+	Ignore bool
+}
+
+func (e BucketError) Error() string {
+	return fmt.Sprintf("Bucket error %s: %s", e.id, e.msg)
+}
+
+// NewBucketError create new BucketError
+func NewBucketError(id, msg string, ignore bool) BucketError {
+	return BucketError{id: id, msg: msg, Ignore: ignore}
+}
+
+// describeError returns the error of a failed describe call, ignored only if the bucket does not exist: the
+// other errors (throttling, access denied, ...) are retried
+func describeError(id string, err error) BucketError {
+	return NewBucketError(id, err.Error(), util.IsAWSErrorCode(err, "NoSuchBucket", "NotFound"))
+}
+
+type Bucket struct {
+	State *util.BucketDescription
+}
+
+// BucketArn returns the ID of the bucket with the given name
+func BucketArn(name string) string {
+	return arnPrefix + name
+}
+
+// NewBucket create a new Bucket object
+func NewBucket(state *util.BucketDescription) Bucket {
+	if state == nil {
+		state = new(util.BucketDescription)
+	}
+	return Bucket{State: state}
+}
+
+// NewBucketWithStatus create a new Bucket object including the current configuration of the AWS bucket
+func NewBucketWithStatus(ctx context.Context, id string, accountID string, region string) (Bucket, error) {
+	desc := NewBucket(nil)
+
+	state, err := util.DescribeBucket(ctx, strings.TrimPrefix(id, arnPrefix), accountID, region)
+	if err != nil {
+		Log.Errorf("s3bucket.NewBucketWithStatus: %s", err)
+		return desc, describeError(id, err)
+	}
+
+	desc.State = state
+	return desc, nil
+}
+
+// GetProperties returns the given properties for <key> argument
+func (b *Bucket) GetProperties(key string) []string {
+	/*
+		Encryption
+			Algorithm (AES256, aws:kms)
+			KMSMasterKeyID
+		Versioning
+			Status (Enabled, Suspended)
+			MFADelete
+		PublicAccessBlock
+			BlockPublicAcls
+			IgnorePublicAcls
+			BlockPublicPolicy
+			RestrictPublicBuckets
+		Grants (<grantee type>:<grantee>:<permission>, e.g. Group:AllUsers:READ)
+		Policy
+			Principals (of the Allow statements: *, the AWS ARNs and Service:<service>)
+		Logging
+			TargetBucket
+			TargetPrefix
+		Lifecycle (of the enabled rules)
+			Rule
+			ExpirationDays
+		Tags
+	*/
+	var result []string
+
+	splitKey := strings.Split(key, ".")
+	Log.Debugf("Bucket Property keys: %+v", splitKey)
+
+	state := b.State
+	switch splitKey[0] {
+	case "Encryption":
+		if len(splitKey) < 2 || state.Encryption == nil {
+			return nil
+		}
+		for _, rule := range state.Encryption.Rules {
+			def := rule.ApplyServerSideEncryptionByDefault
+			if def == nil {
+				continue
+			}
+			switch splitKey[1] {
+			case "Algorithm":
+				result = appendValue(result, def.SSEAlgorithm)
+			case "KMSMasterKeyID":
+				result = appendValue(result, def.KMSMasterKeyID)
+			default:
+				Log.Warnf("Bucket.GetProperties: Configuration Encryption.%s is not supported!", splitKey[1])
+				return nil
+			}
+		}
+	case "Versioning":
+		if len(splitKey) < 2 || state.Versioning == nil {
+			return nil
+		}
+		switch splitKey[1] {
+		case "Status":
+			result = appendValue(result, state.Versioning.Status)
+		case "MFADelete":
+			result = appendValue(result, state.Versioning.MFADelete)
+		default:
+			Log.Warnf("Bucket.GetProperties: Configuration Versioning.%s is not supported!", splitKey[1])
+		}
+	case "PublicAccessBlock":
+		if len(splitKey) < 2 || state.PublicAccessBlock == nil {
+			return nil
+		}
+		pab := state.PublicAccessBlock
+		settings := map[string]*bool{"BlockPublicAcls": pab.BlockPublicAcls, "IgnorePublicAcls": pab.IgnorePublicAcls,
+			"BlockPublicPolicy": pab.BlockPublicPolicy, "RestrictPublicBuckets": pab.RestrictPublicBuckets}
+		setting, ok := settings[splitKey[1]]
+		if !ok {
+			Log.Warnf("Bucket.GetProperties: Configuration PublicAccessBlock.%s is not supported!", splitKey[1])
+			return nil
+		}
+		result = append(result, strconv.FormatBool(aws.BoolValue(setting)))
+	case "Grants":
+		for _, grant := range state.Grants {
+			result = append(result, grantString(grant))
+		}
+	case "Policy":
+		if len(splitKey) < 2 || splitKey[1] != "Principals" {
+			Log.Warnf("Bucket.GetProperties: Configuration %s is not supported!", key)
+			return nil
+		}
+		principals, err := policyPrincipals(state.Policy)
+		if err != nil {
+			Log.Warnf("Bucket.GetProperties: invalid policy of %s: %s", state.Name, err)
+		}
+		result = append(result, principals...)
+	case "Logging":
+		if len(splitKey) < 2 || state.Logging == nil {
+			return nil
+		}
+		switch splitKey[1] {
+		case "TargetBucket":
+			result = appendValue(result, state.Logging.TargetBucket)
+		case "TargetPrefix":
+			result = appendValue(result, state.Logging.TargetPrefix)
+		default:
+			Log.Warnf("Bucket.GetProperties: Configuration Logging.%s is not supported!", splitKey[1])
+		}
+	case "Lifecycle":
+		if len(splitKey) < 2 {
+			return nil
+		}
+		for _, rule := range state.LifecycleRules {
+			if aws.StringValue(rule.Status) != "Enabled" {
+				continue
+			}
+			switch splitKey[1] {
+			case "Rule":
+				result = appendValue(result, rule.ID)
+			case "ExpirationDays":
+				if rule.Expiration != nil && rule.Expiration.Days != nil {
+					result = append(result, strconv.FormatInt(*rule.Expiration.Days, 10))
+				}
+			default:
+				Log.Warnf("Bucket.GetProperties: Configuration Lifecycle.%s is not supported!", splitKey[1])
+				return nil
+			}
+		}
+	case "Tag":
+		for _, t := range state.Tags {
+			if t.Key != nil && *t.Key == splitKey[1] {
+				Log.Debugf("Bucket.GetProperties: Found Tag: `%s: %s`", *t.Key, *t.Value)
+				result = append(result, *t.Value)
+			}
+		}
+	case "Tag:Value":
+		value := strings.Split(key, "Tag:Value.")
+		for _, t := range state.Tags {
+			if t.Value != nil && *t.Value == value[1] {
+				Log.Debugf("Bucket.GetProperties: Found Tag with Value: `%s: %s`", *t.Key, *t.Value)
+				result = append(result, *t.Value)
+			}
+		}
+	case "Tag:Pair":
+		value := strings.Split(key, "Tag:Pair.")
+		pair := strings.Split(value[1], "---")
+		for _, t := range state.Tags {
+			if t.Key != nil && *t.Key == pair[0] && t.Value != nil && *t.Value == pair[1] {
+				Log.Debugf("Bucket.GetProperties: Found Tag with pair: `%s - %s`", *t.Key, *t.Value)
+				result = append(result, *t.Value)
+			}
+		}
+	}
+
+	Log.Debugf("Bucket.GetProperties: Found %s: %v", key, result)
+	return result
+}
+
+func appendValue(result []string, value *string) []string {
+	if value == nil {
+		return result
+	}
+	return append(result, *value)
+}
+
+// grantString formats a grant of the ACL for the compliance checks, e.g. "Group:AllUsers:READ" for the
+// groups of Amazon S3, "CanonicalUser:<ID>:FULL_CONTROL" for the accounts
+func grantString(grant *s3.Grant) string {
+	grantee := ""
+	if g := grant.Grantee; g != nil {
+		switch aws.StringValue(g.Type) {
+		case "Group":
+			uri := aws.StringValue(g.URI)
+			grantee = "Group:" + uri[strings.LastIndex(uri, "/")+1:]
+		case "AmazonCustomerByEmail":
+			grantee = "AmazonCustomerByEmail:" + aws.StringValue(g.EmailAddress)
+		default:
+			grantee = aws.StringValue(g.Type) + ":" + aws.StringValue(g.ID)
+		}
+	}
+	return grantee + ":" + aws.StringValue(grant.Permission)
+}
+
+// policyPrincipals returns the principals of the Allow statements of the policy document
+func policyPrincipals(policy string) ([]string, error) {
+	statements, err := util.ParsePolicyDocument(policy)
+	if err != nil {
+		return nil, err
+	}
+	var principals []string
+	for _, s := range statements {
+		if s.Effect == "Allow" {
+			principals = append(principals, s.Principals...)
+		}
+	}
+	return principals, nil
+}
+
+func (b *Bucket) GetId() string {
+	return BucketArn(b.State.Name)
+}
@@ -0,0 +1,119 @@
+package s3bucket
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+
+	"github.com/kreuzwerker/arebot/cloudwatch"
+	"github.com/kreuzwerker/arebot/util"
+)
+
+func TestGetProperties(t *testing.T) {
+
+	var state util.BucketDescription
+	if err := json.Unmarshal([]byte(bucketState), &state); err != nil {
+		t.Fatal(err)
+	}
+	bucket := NewBucket(&state)
+	if bucket.GetId() != "arn:aws:s3:::my-logs" {
+		t.Errorf("id == %s", bucket.GetId())
+	}
+
+	expected := map[string][]string{
+		"Encryption.Algorithm":                {"aws:kms"},
+		"Versioning.Status":                   {"Enabled"},
+		"Versioning.MFADelete":                nil,
+		"PublicAccessBlock.BlockPublicAcls":   {"true"},
+		"PublicAccessBlock.BlockPublicPolicy": {"false"},
+		"Grants":                              {"CanonicalUser:0011aabb:FULL_CONTROL", "Group:AllUsers:READ"},
+		"Policy.Principals":                   {"*", "arn:aws:iam::111122223333:root", "Service:cloudtrail.amazonaws.com"},
+		"Logging.TargetBucket":                {"audit-logs"},
+		"Lifecycle.Rule":                      {"expire"},
+		"Lifecycle.ExpirationDays":            {"365"},
+		"Tag.Owner":                           {"team-a"},
+		"Tag:Pair.Owner---team-a":             {"team-a"},
+	}
+	for key, values := range expected {
+		if properties := bucket.GetProperties(key); !reflect.DeepEqual(properties, values) {
+			t.Errorf("%s == %q expected %q", key, properties, values)
+		}
+	}
+
+	// the configurations that are not set have no properties
+	empty := NewBucket(&util.BucketDescription{Name: "empty"})
+	for _, key := range []string{"Encryption.Algorithm", "Versioning.Status", "PublicAccessBlock.BlockPublicAcls", "Policy.Principals", "Logging.TargetBucket"} {
+		if properties := empty.GetProperties(key); len(properties) != 0 {
+			t.Errorf("%s == %q expected none", key, properties)
+		}
+	}
+}
+
+func TestResourceType(t *testing.T) {
+
+	rt, ok := cloudwatch.GetResourceType(BucketArn("my-logs"))
+	if !ok || rt.ConfigName != "s3_bucket" || rt.PolicyType != "s3" {
+		t.Errorf("resource type == %+v expected the buckets", rt)
+	}
+	if _, ok := cloudwatch.GetResourceType("arn:aws:s3:::my-logs/key"); ok {
+		t.Error("the objects are not buckets")
+	}
+
+	decoder, _ := cloudwatch.GetEventDecoder("DeleteBucket")
+	event := cloudwatch.AWSEvent{RequestParameter: &cloudwatch.BucketRequestParameters{BucketName: "my-logs"}}
+	if ids, err := decoder.ResourceIds(event); err != nil || !reflect.DeepEqual(ids, []string{"arn:aws:s3:::my-logs"}) || !decoder.DeletesResources(event) {
+		t.Errorf("ids == %v, %v expected the deleted bucket", ids, err)
+	}
+}
+
+const bucketState = `{
+  "Name": "my-logs",
+  "Tags": [ { "Key": "Owner", "Value": "team-a" } ],
+  "Encryption": { "Rules": [ { "ApplyServerSideEncryptionByDefault": { "SSEAlgorithm": "aws:kms", "KMSMasterKeyID": "alias/logs" } } ] },
+  "Versioning": { "Status": "Enabled" },
+  "PublicAccessBlock": { "BlockPublicAcls": true, "IgnorePublicAcls": true, "BlockPublicPolicy": false },
+  "Grants": [
+    { "Grantee": { "Type": "CanonicalUser", "ID": "0011aabb" }, "Permission": "FULL_CONTROL" },
+    { "Grantee": { "Type": "Group", "URI": "http://acs.amazonaws.com/groups/global/AllUsers" }, "Permission": "READ" }
+  ],
+  "Policy": "{\"Statement\": [{\"Effect\": \"Allow\", \"Principal\": \"*\"}, {\"Effect\": \"Allow\", \"Principal\": {\"Service\": \"cloudtrail.amazonaws.com\", \"AWS\": [\"arn:aws:iam::111122223333:root\"]}}, {\"Effect\": \"Deny\", \"Principal\": {\"AWS\": \"arn:aws:iam::444455556666:root\"}}]}",
+  "Logging": { "TargetBucket": "audit-logs", "TargetPrefix": "my-logs/" },
+  "LifecycleRules": [ { "ID": "expire", "Status": "Enabled", "Expiration": { "Days": 365 } }, { "ID": "old", "Status": "Disabled" } ]
+}`
+
+func TestDescribeError(t *testing.T) {
+	expected := []struct {
+		err    error
+		ignore bool
+	}{
+		{awserr.New("NoSuchBucket", "The specified bucket does not exist", nil), true},
+		{awserr.New("NotFound", "Not Found", nil), true},
+		{awserr.New("SlowDown", "Please reduce your request rate.", nil), false},
+		{awserr.New("AccessDenied", "Access Denied", nil), false},
+		{errors.New("dial tcp: i/o timeout"), false},
+	}
+	for _, e := range expected {
+		if err := describeError(BucketArn("my-bucket"), e.err); err.Ignore != e.ignore {
+			t.Errorf("Ignore == %v for %s, expected %v", err.Ignore, e.err, e.ignore)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package securitygroup
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/kreuzwerker/arebot/cloudwatch"
+	"github.com/kreuzwerker/arebot/util"
+)
+
+func init() {
+	cloudwatch.RegisterResourceType(cloudwatch.ResourceType{
+		Name:       "security group",
+		ConfigName: "security_group",
+		IdPrefix:   "sg",
+		PolicyType: "security_group",
+		New: func(ctx context.Context, id string, accountId string, region string) (util.AwsResourceType, error) {
+			sg, err := NewSecurityGroupWithStatus(ctx, id, accountId, region)
+			return &sg, err
+		},
+		FromState: func(state []byte) (util.AwsResourceType, error) {
+			var group ec2.SecurityGroup
+			if err := json.Unmarshal(state, &group); err != nil {
+				return nil, err
+			}
+			if group.GroupId == nil {
+				return nil, errors.New("the state has no ID")
+			}
+			sg := NewSecurityGroup(&group)
+			return &sg, nil
+		},
+		Properties: []string{"GroupName", "IpPermissions.FromPort", "IpPermissions.ToPort", "IpPermissions.IpRanges",
+			"IpPermissions.UserIdGroupPairs.GroupId", "IpPermissions.UserIdGroupPairs.UserId", "Tag.", "Tag:Value.", "Tag:Pair."},
+	})
+
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames:        []string{"AuthorizeSecurityGroupIngress", "RevokeSecurityGroupIngress", "AuthorizeSecurityGroupEgress", "RevokeSecurityGroupEgress"},
+		RequestParameters: func() interface{} { return &cloudwatch.SecurityGroupPolicyRequestParameters{} },
+		ResourceIds:       requestGroupId,
+	})
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames:        []string{"CreateSecurityGroup"},
+		RequestParameters: func() interface{} { return &cloudwatch.CreateSecurityGroupRequestParameters{} },
+		ResourceIds: func(e cloudwatch.AWSEvent) ([]string, error) {
+			id, err := e.ResponseElement("groupId")
+			return []string{id}, err
+		},
+	})
+	cloudwatch.RegisterEventDecoder(cloudwatch.EventDecoder{
+		EventNames:        []string{"DeleteSecurityGroup"},
+		RequestParameters: func() interface{} { return &cloudwatch.SecurityGroupPolicyRequestParameters{} },
+		ResourceIds:       requestGroupId,
+		Deletes:           true,
+	})
+}
+
+func requestGroupId(e cloudwatch.AWSEvent) ([]string, error) {
+	return []string{e.RequestParameter.(*cloudwatch.SecurityGroupPolicyRequestParameters).GroupId}, nil
+}
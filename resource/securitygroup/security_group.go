@@ -17,10 +17,10 @@ package securitygroup
 */
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
-	"reflect"
 	"strconv"
 	"strings"
 
@@ -66,6 +66,7 @@ func NewSecurityGroupError(id, msg string, ignore bool) SecurityGroupError {
 
 type SecurityGroup struct {
 	State  *ec2.SecurityGroup
+	Region string
 }
 
 // NewSecurityGroup create a new SecurityGroup object
@@ -80,16 +81,17 @@ func NewSecurityGroup(sgout *ec2.SecurityGroup) SecurityGroup {
 }
 
 // NewSecurityGroupWithStatus create a new SecurityGroup object including the current status of the AWS resource
-func NewSecurityGroupWithStatus(sgID string, accountID string, describeFunc ...func(string, string) (*ec2.DescribeSecurityGroupsOutput, error)) (SecurityGroup, error) {
+func NewSecurityGroupWithStatus(ctx context.Context, sgID string, accountID string, region string, describeFunc ...func(context.Context, string, string, string) (*ec2.DescribeSecurityGroupsOutput, error)) (SecurityGroup, error) {
 	desc := NewSecurityGroup(nil)
+	desc.Region = region
 
 	var state *ec2.DescribeSecurityGroupsOutput
 	var err error
 	// check for function that returns the current state of the security group and use it
 	if len(describeFunc) == 1 {
-		state, err = describeFunc[0](sgID, accountID)
+		state, err = describeFunc[0](ctx, sgID, accountID, region)
 	} else {
-		state, err = util.DescribeSecurityGroupById(sgID, accountID)
+		state, err = util.DescribeSecurityGroupById(ctx, sgID, accountID, region)
 	}
 
 	if err != nil {
@@ -102,16 +104,20 @@ func NewSecurityGroupWithStatus(sgID string, accountID string, describeFunc ...f
 	return desc, nil
 }
 
-func FindAllSecGroupsWithTag(tagKey string, tagValue string) ([]SecurityGroup, error) {
+func FindAllSecGroupsWithTag(ctx context.Context, tagKey string, tagValue string) ([]SecurityGroup, error) {
 	var result []SecurityGroup
 	for _, account := range Cfg.Account {
-		groups, err := util.DescribeSecurityGroupsByTag(account.AccountID, tagKey, tagValue)
-		if err != nil {
-			Log.Errorf("security_group.FindAllSecGroupsWithTag: %s", err)
-			return nil, err
-		}
-		for _, group := range groups.SecurityGroups {
-			result = append(result, NewSecurityGroup(group))
+		for _, region := range Cfg.GetAccountRegions(account.AccountID) {
+			groups, err := util.DescribeSecurityGroupsByTag(ctx, account.AccountID, region, tagKey, tagValue)
+			if err != nil {
+				Log.Errorf("security_group.FindAllSecGroupsWithTag: %s", err)
+				return nil, err
+			}
+			for _, group := range groups.SecurityGroups {
+				sg := NewSecurityGroup(group)
+				sg.Region = region
+				result = append(result, sg)
+			}
 		}
 	}
 	return result, nil
@@ -155,28 +161,17 @@ func (sg *SecurityGroup) GetProperties(key string) []string {
 
 		case "FromPort", "ToPort":
 			for _, x := range sg.State.IpPermissions {
-				v := reflect.ValueOf(x).Elem()
-				n := v.FieldByName(splitKey[1])
-
-				for _, ipr := range x.IpRanges {
-					resultFormat := "P:" + *x.IpProtocol + ";FP:" + strconv.Itoa(int(*x.FromPort)) + ";TP:" + strconv.Itoa(int(*x.ToPort)) + ";IP:" + *ipr.CidrIp
-					Log.Debugf("SecurityGroup.GetProperties: Found %s: %d\n\tAdd result: %s", splitKey[1], n.Elem(), resultFormat)
-					// convert reflect.Value == int64 to string type is kind of hard
-					result = append(result, resultFormat)
-				}
-				for _, ugp := range x.UserIdGroupPairs {
-					resultFormat := "P:" + *x.IpProtocol + ";FP:" + strconv.Itoa(int(*x.FromPort)) + ";TP:" + strconv.Itoa(int(*x.ToPort)) + ";UG:" + *ugp.UserId + "/" + *ugp.GroupId
-					Log.Debugf("SecurityGroup.GetProperties: Found %s: %d\n\tAdd result: %s", splitKey[1], n.Elem(), resultFormat)
-					// convert reflect.Value == int64 to string type is kind of hard
+				for _, source := range append(ipSources(x), groupSources(x)...) {
+					resultFormat := permissionRule(x, source)
+					Log.Debugf("SecurityGroup.GetProperties: Found %s\n\tAdd result: %s", splitKey[1], resultFormat)
 					result = append(result, resultFormat)
 				}
 			}
 		case "IpRanges":
 			for _, x := range sg.State.IpPermissions {
-				for _, ipr := range x.IpRanges {
-					resultFormat := "P:" + *x.IpProtocol + ";FP:" + strconv.Itoa(int(*x.FromPort)) + ";TP:" + strconv.Itoa(int(*x.ToPort)) + ";IP:" + *ipr.CidrIp
-					Log.Debugf("SecurityGroup.GetProperties: Found %s: %s\n\tAdd result: %s", splitKey[1], *ipr.CidrIp, resultFormat)
-					// convert reflect.Value == int64 to string type is kind of hard
+				for _, source := range ipSources(x) {
+					resultFormat := permissionRule(x, source)
+					Log.Debugf("SecurityGroup.GetProperties: Found %s: %s\n\tAdd result: %s", splitKey[1], source, resultFormat)
 					result = append(result, resultFormat)
 				}
 			}
@@ -188,13 +183,9 @@ func (sg *SecurityGroup) GetProperties(key string) []string {
 			switch splitKey[2] {
 			case "GroupId", "UserId":
 				for _, x := range sg.State.IpPermissions {
-					for _, p := range x.UserIdGroupPairs {
-
-						v := reflect.ValueOf(p).Elem()
-						n := v.FieldByName(splitKey[2])
-
-						resultFormat := "P:" + *x.IpProtocol + ";FP:" + strconv.Itoa(int(*x.FromPort)) + ";TP:" + strconv.Itoa(int(*x.ToPort)) + ";UG:" + *p.UserId + "/" + *p.GroupId
-						Log.Debugf("SecurityGroup.GetProperties: Found %s: %s\n\tAdd result: %s", splitKey[2], n.Elem(), resultFormat)
+					for _, source := range groupSources(x) {
+						resultFormat := permissionRule(x, source)
+						Log.Debugf("SecurityGroup.GetProperties: Found %s: %s\n\tAdd result: %s", splitKey[2], source, resultFormat)
 						result = append(result, resultFormat)
 					}
 				}
@@ -234,14 +225,52 @@ func (sg *SecurityGroup) GetProperties(key string) []string {
 	return result
 }
 
+// permissionRule formats a rule of the security group for the compliance checks, e.g. "P:tcp;FP:22;TP:22;IP:0.0.0.0/0".
+// The rules of all the protocols (-1) have no ports, formatted as -1.
+func permissionRule(p *ec2.IpPermission, source string) string {
+	return "P:" + aws.StringValue(p.IpProtocol) + ";FP:" + portString(p.FromPort) + ";TP:" + portString(p.ToPort) + ";" + source
+}
+
+func portString(port *int64) string {
+	if port == nil {
+		return "-1"
+	}
+	return strconv.FormatInt(*port, 10)
+}
+
+// ipSources returns the IPv4 and IPv6 ranges of the rule, e.g. "IP:10.0.0.0/8"
+func ipSources(p *ec2.IpPermission) []string {
+	var sources []string
+	for _, ipr := range p.IpRanges {
+		sources = append(sources, "IP:"+aws.StringValue(ipr.CidrIp))
+	}
+	for _, ipr := range p.Ipv6Ranges {
+		sources = append(sources, "IP:"+aws.StringValue(ipr.CidrIpv6))
+	}
+	return sources
+}
+
+// groupSources returns the user/group pairs of the rule, e.g. "UG:000000000000/sg-0011aabb"
+func groupSources(p *ec2.IpPermission) []string {
+	var sources []string
+	for _, ugp := range p.UserIdGroupPairs {
+		sources = append(sources, "UG:"+aws.StringValue(ugp.UserId)+"/"+aws.StringValue(ugp.GroupId))
+	}
+	return sources
+}
+
 func (sg *SecurityGroup) GetId() string {
 	return *sg.State.GroupId
 }
 
+func (sg *SecurityGroup) GetVpcId() string {
+	return aws.StringValue(sg.State.VpcId)
+}
+
 /*
 TagCreator Tag security group with the user identity given in the event
 */
-func (sg SecurityGroup) TagCreator(ui map[string]string) error {
+func (sg SecurityGroup) TagCreator(ctx context.Context, ui map[string]string) error {
 	creator := ui["name"]
 	switch ui["entity"] {
 	case "assumed-role":
@@ -251,13 +280,13 @@ func (sg SecurityGroup) TagCreator(ui map[string]string) error {
 		Log.Printf("user: %s", ui["name"])
 	}
 
-	return sg.Tag("Creator", creator)
+	return sg.Tag(ctx, "Creator", creator)
 }
 
-func (sg SecurityGroup) Tag(key string, value string) error {
+func (sg SecurityGroup) Tag(ctx context.Context, key string, value string) error {
 	Log.Printf("Tagging %s with: `%s: %s`", *sg.State.GroupId, key, value)
 
-	cfg := util.GetAWSConfig(*sg.State.OwnerId)
+	cfg := util.GetAWSConfig(*sg.State.OwnerId, sg.Region)
 	if cfg == nil {
 		return errors.New("Can't describe resource ID: " /*+ sg.ID*/)
 	}
@@ -277,7 +306,7 @@ func (sg SecurityGroup) Tag(key string, value string) error {
 			// More values...
 		},
 	}
-	_, err := svc.CreateTags(params)
+	_, err := svc.CreateTagsWithContext(ctx, params)
 
 	if err != nil {
 		// Print the error, cast err to awserr.Error to get the Code and
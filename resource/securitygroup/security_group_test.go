@@ -17,6 +17,7 @@ package securitygroup
 */
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -57,7 +58,7 @@ func TestCreateSG(t *testing.T) {
 		panic(err)
 	}
 
-	sg, err := NewSecurityGroupWithStatus("sg-aabb1122", "222233334444", func(id string, account string) (*ec2.DescribeSecurityGroupsOutput, error) {
+	sg, err := NewSecurityGroupWithStatus(context.Background(), "sg-aabb1122", "222233334444", "eu-central-1", func(ctx context.Context, id string, account string, region string) (*ec2.DescribeSecurityGroupsOutput, error) {
 		return &ec2.DescribeSecurityGroupsOutput{
 			SecurityGroups: []*ec2.SecurityGroup{
 				{
@@ -129,7 +130,7 @@ func TestCreateSG(t *testing.T) {
 	}
 
 	/*sgConfigParents*/
-	_, apicallsConfigs := Cfg.GetAPICallConfigs("CreateSecurityGroup", "222233334444", *sg.State.VpcId, "security_group")
+	_, apicallsConfigs := Cfg.GetAPICallConfigs("CreateSecurityGroup", "222233334444", *sg.State.VpcId, "security_group", "")
 
 	if len(apicallsConfigs) != 1 {
 		t.Errorf("GetAPICallConfigs should return exactly 1 results. But it returned: %d \n%+v", len(apicallsConfigs), apicallsConfigs)
@@ -144,3 +145,39 @@ func TestCreateSG(t *testing.T) {
 		}
 	}
 }
+
+func TestPermissionRules(t *testing.T) {
+
+	sg := NewSecurityGroup(&ec2.SecurityGroup{
+		GroupId: strHelper("sg-bbaa2211"),
+		IpPermissions: []*ec2.IpPermission{
+			{
+				IpProtocol: strHelper("-1"),
+				IpRanges:   []*ec2.IpRange{{CidrIp: strHelper("10.0.0.0/8")}},
+			},
+			{
+				FromPort:   intHelper(20),
+				IpProtocol: strHelper("tcp"),
+				Ipv6Ranges: []*ec2.Ipv6Range{{CidrIpv6: strHelper("::/0")}},
+				ToPort:     intHelper(23),
+			},
+		},
+	})
+
+	rules := sg.GetProperties("IpPermissions.IpRanges")
+	expected := []string{"P:-1;FP:-1;TP:-1;IP:10.0.0.0/8", "P:tcp;FP:20;TP:23;IP:::/0"}
+	if len(rules) != 2 || rules[0] != expected[0] || rules[1] != expected[1] {
+		t.Errorf("rules == %v expected %v", rules, expected)
+	}
+
+	// no world-open SSH: the IPv6 rule exposes the port 22 within its range
+	apicall := config.APICall{Compliant: []config.CompliantCheck{{
+		Name:             "IpPermissions.IpRanges",
+		PermissionChecks: config.PermissionChecks{DenyWorld: true, ForbiddenPorts: []string{"22"}},
+	}}}
+	for _, result := range apicall.CheckCompliance(sg.GetProperties, "sg-bbaa2211", config.EventUserInfo{}) {
+		if result.IsCompliant != (result.Value == expected[0]) {
+			t.Errorf("%s: compliant == %t", result.Value, result.IsCompliant)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package sqsworker
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+
+	"github.com/kreuzwerker/arebot/cloudwatch"
+	"github.com/kreuzwerker/arebot/config"
+)
+
+// RetryPolicy defines how often and when a message whose handling failed is delivered again
+type RetryPolicy struct {
+	// number of deliveries after which the message is dead-lettered
+	MaxAttempts int
+	// visibility timeout (in seconds) after the first failed attempt; doubled at each further attempt
+	BackoffSeconds int64
+	// upper bound of the visibility timeout (in seconds)
+	MaxBackoffSeconds int64
+}
+
+// DefaultRetryPolicy is used by workers created without an explicit policy
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 5, BackoffSeconds: 30, MaxBackoffSeconds: 43200}
+
+// NewRetryPolicy creates the RetryPolicy defined in the worker configuration
+func NewRetryPolicy(wc config.WorkerConfig) RetryPolicy {
+	return RetryPolicy{MaxAttempts: wc.MaxAttempts, BackoffSeconds: wc.BackoffSeconds, MaxBackoffSeconds: wc.MaxBackoffSeconds}
+}
+
+// Backoff returns the visibility timeout (in seconds) of a message that failed `attempts` times
+func (p RetryPolicy) Backoff(attempts int) int64 {
+	backoff := p.BackoffSeconds
+	for i := 1; i < attempts && backoff < p.MaxBackoffSeconds; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoffSeconds {
+		backoff = p.MaxBackoffSeconds
+	}
+	return backoff
+}
+
+// Retrier is implemented by the event sources that can deliver a failed message again
+type Retrier interface {
+	// Retry makes the message visible again after delay seconds
+	Retry(m *sqs.Message, delay int64) error
+}
+
+func (s *SQSSource) Retry(m *sqs.Message, delay int64) error {
+	params := &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(s.queueURL), // Required
+		ReceiptHandle:     m.ReceiptHandle,        // Required
+		VisibilityTimeout: aws.Int64(delay),       // Required
+	}
+	_, err := s.svc.ChangeMessageVisibility(params)
+	return err
+}
+
+// DeadLetter is the destination of the messages that could not be handled
+type DeadLetter interface {
+	// Quarantine stores the message together with the last handling error
+	Quarantine(m *sqs.Message, attempts int, cause error) error
+}
+
+// QueueDeadLetter moves the messages to another SQS queue. The handling error and the number of
+// attempts are sent as the message attributes "ArebotError" and "ArebotAttempts".
+type QueueDeadLetter struct {
+	svc      *sqs.SQS
+	queueURL string
+}
+
+// NewQueueDeadLetter creates a DeadLetter sending the messages to the queue at queueURL
+func NewQueueDeadLetter(svc *sqs.SQS, queueURL string) *QueueDeadLetter {
+	return &QueueDeadLetter{svc: svc, queueURL: queueURL}
+}
+
+func (d *QueueDeadLetter) Quarantine(m *sqs.Message, attempts int, cause error) error {
+	params := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(d.queueURL), // Required
+		MessageBody: m.Body,                 // Required
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"ArebotError": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(cause.Error()),
+			},
+			"ArebotAttempts": {
+				DataType:    aws.String("Number"),
+				StringValue: aws.String(strconv.Itoa(attempts)),
+			},
+		},
+	}
+	_, err := d.svc.SendMessage(params)
+	return err
+}
+
+// FolderDeadLetter saves the messages as JSON files into a local folder. The files use the format
+// of misc/sqs-dump/dump.py, extended by the handling error, so that they can be replayed.
+type FolderDeadLetter struct {
+	folder string
+}
+
+// NewFolderDeadLetter creates a DeadLetter saving the messages into folder
+func NewFolderDeadLetter(folder string) *FolderDeadLetter {
+	return &FolderDeadLetter{folder: folder}
+}
+
+type deadLetterRecord struct {
+	ID         string            `json:"id"`
+	Attributes map[string]string `json:"attributes"`
+	Body       string            `json:"body"`
+	Error      string            `json:"error"`
+	Attempts   int               `json:"attempts"`
+	Time       time.Time         `json:"time"`
+}
+
+func (d *FolderDeadLetter) Quarantine(m *sqs.Message, attempts int, cause error) error {
+	record := deadLetterRecord{
+		ID:         aws.StringValue(m.MessageId),
+		Attributes: map[string]string{},
+		Body:       aws.StringValue(m.Body),
+		Error:      cause.Error(),
+		Attempts:   attempts,
+		Time:       time.Now(),
+	}
+	for k, v := range m.Attributes {
+		record.Attributes[k] = aws.StringValue(v)
+	}
+
+	b, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.folder, os.ModePerm); err != nil {
+		return err
+	}
+	name := strings.NewReplacer("/", "_", string(os.PathSeparator), "_").Replace(record.ID)
+	return ioutil.WriteFile(filepath.Join(d.folder, name+".json"), b, 0644)
+}
+
+// receiveCount returns how many times the message has been delivered (at least once)
+func receiveCount(m *sqs.Message) int {
+	count, err := strconv.Atoi(aws.StringValue(m.Attributes["ApproximateReceiveCount"]))
+	if err != nil || count < 1 {
+		return 1
+	}
+	return count
+}
+
+// isPermanent returns true for the errors that handling the message again does not solve
+func isPermanent(err error) bool {
+	_, ok := err.(cloudwatch.DecodeError)
+	return ok
+}
@@ -0,0 +1,282 @@
+package sqsworker
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// EventSource delivers the messages processed by the worker
+type EventSource interface {
+	// Receive returns the next batch of messages. It returns io.EOF once the
+	// source is exhausted (a live queue never is) and ctx.Err() once ctx is done.
+	Receive(ctx context.Context) ([]*sqs.Message, error)
+	// Delete acknowledges a successfully handled message
+	Delete(m *sqs.Message) error
+	// Name identifies the source in the log output
+	Name() string
+}
+
+// SQSSource reads the messages from an SQS queue
+type SQSSource struct {
+	svc      *sqs.SQS
+	queueURL string
+}
+
+// NewSQSSource creates an EventSource polling the queue at queueURL
+func NewSQSSource(svc *sqs.SQS, queueURL string) *SQSSource {
+	return &SQSSource{svc: svc, queueURL: queueURL}
+}
+
+func (s *SQSSource) Receive(ctx context.Context) ([]*sqs.Message, error) {
+	params := &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(s.queueURL), // Required
+		MaxNumberOfMessages: aws.Int64(MaxNumberOfMessage),
+		AttributeNames: []*string{
+			aws.String("ApproximateReceiveCount"), // used by the RetryPolicy
+		},
+		MessageAttributeNames: []*string{
+			aws.String("All"), // Required
+		},
+		WaitTimeSeconds: aws.Int64(WaitTimeSecond),
+	}
+
+	resp, err := s.svc.ReceiveMessageWithContext(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Messages, nil
+}
+
+func (s *SQSSource) Delete(m *sqs.Message) error {
+	params := &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(s.queueURL), // Required
+		ReceiptHandle: m.ReceiptHandle,        // Required
+	}
+	if _, err := s.svc.DeleteMessage(params); err != nil {
+		return err
+	}
+	Log.Debugf("worker: deleted message from queue: %s", aws.StringValue(m.ReceiptHandle))
+	return nil
+}
+
+func (s *SQSSource) Name() string {
+	return s.queueURL
+}
+
+// FileSource replays recorded events stored in local files. Every file may contain
+// CloudWatch events (JSON Lines or pretty-printed), CloudTrail log files `{"Records":[...]}`
+// (optionally gzip compressed), single CloudTrail records or the messages saved by
+// misc/sqs-dump/dump.py. CloudTrail records are wrapped into the CloudWatch event
+// envelope expected by cloudwatch.DecodeEvent.
+type FileSource struct {
+	mu       sync.Mutex
+	files    []string
+	messages []*sqs.Message
+}
+
+// NewFileSource creates an EventSource reading all the files found in paths. Directories
+// are walked recursively and their files are replayed in lexical order.
+func NewFileSource(paths ...string) (*FileSource, error) {
+	src := &FileSource{}
+	for _, p := range paths {
+		err := filepath.Walk(p, func(path string, f os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !f.IsDir() && !strings.HasPrefix(f.Name(), ".") {
+				src.files = append(src.files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(src.files)
+	return src, nil
+}
+
+// Receive returns the messages of the next file, at most MaxNumberOfMessage at a time
+func (s *FileSource) Receive(ctx context.Context) ([]*sqs.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for len(s.messages) == 0 {
+		if len(s.files) == 0 {
+			return nil, io.EOF
+		}
+		file := s.files[0]
+		s.files = s.files[1:]
+
+		messages, err := readEventFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("sqsworker: cannot read events from %s: %s", file, err)
+		}
+		Log.Infof("worker: replaying %d events from %s", len(messages), file)
+		s.messages = messages
+	}
+
+	n := int(MaxNumberOfMessage)
+	if n > len(s.messages) {
+		n = len(s.messages)
+	}
+	batch := s.messages[:n]
+	s.messages = s.messages[n:]
+	return batch, nil
+}
+
+// Delete is a no-op: replayed files are never modified
+func (s *FileSource) Delete(m *sqs.Message) error {
+	return nil
+}
+
+func (s *FileSource) Name() string {
+	return "file replay"
+}
+
+func readEventFile(file string) ([]*sqs.Message, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var messages []*sqs.Message
+	dec := json.NewDecoder(r)
+	for i := 0; ; i++ {
+		var doc map[string]json.RawMessage
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		bodies, err := eventBodies(doc)
+		if err != nil {
+			return nil, err
+		}
+		for j, body := range bodies {
+			messages = append(messages, &sqs.Message{
+				MessageId:     aws.String(fmt.Sprintf("%s:%d:%d", filepath.Base(file), i, j)),
+				ReceiptHandle: aws.String(fmt.Sprintf("%s:%d:%d", file, i, j)),
+				Body:          aws.String(string(body)),
+			})
+		}
+	}
+	return messages, nil
+}
+
+// eventBodies returns the CloudWatch events encoded in a recorded JSON document
+func eventBodies(doc map[string]json.RawMessage) ([][]byte, error) {
+	switch {
+	case doc["detail-type"] != nil: // CloudWatch event
+		b, err := json.Marshal(doc)
+		return [][]byte{b}, err
+
+	case doc["Records"] != nil: // CloudTrail log file
+		var records []map[string]json.RawMessage
+		if err := json.Unmarshal(doc["Records"], &records); err != nil {
+			return nil, err
+		}
+		var bodies [][]byte
+		for _, record := range records {
+			b, err := wrapCloudTrailRecord(record)
+			if err != nil {
+				return nil, err
+			}
+			bodies = append(bodies, b)
+		}
+		return bodies, nil
+
+	case doc["eventName"] != nil: // single CloudTrail record
+		b, err := wrapCloudTrailRecord(doc)
+		return [][]byte{b}, err
+
+	case doc["body"] != nil: // message saved by sqs-dump
+		var body string
+		if err := json.Unmarshal(doc["body"], &body); err != nil {
+			return nil, err
+		}
+		return [][]byte{[]byte(body)}, nil
+	}
+	return nil, NewInvalidEventError("unknown", "recorded document is neither a CloudWatch event nor a CloudTrail record")
+}
+
+// wrapCloudTrailRecord creates the CloudWatch event that would have been delivered for a CloudTrail record
+func wrapCloudTrailRecord(record map[string]json.RawMessage) ([]byte, error) {
+	var r struct {
+		EventID            string `json:"eventID"`
+		EventSource        string `json:"eventSource"`
+		EventTime          string `json:"eventTime"`
+		AWSRegion          string `json:"awsRegion"`
+		RecipientAccountID string `json:"recipientAccountId"`
+		UserIdentity       struct {
+			AccountID string `json:"accountId"`
+		} `json:"userIdentity"`
+	}
+	detail, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(detail, &r); err != nil {
+		return nil, err
+	}
+
+	account := r.RecipientAccountID
+	if account == "" {
+		account = r.UserIdentity.AccountID
+	}
+	event := map[string]interface{}{
+		"version":     "0",
+		"id":          r.EventID,
+		"detail-type": "AWS API Call via CloudTrail",
+		"source":      "aws." + strings.Split(r.EventSource, ".")[0], // e.g. ec2.amazonaws.com -> aws.ec2
+		"account":     account,
+		"time":        r.EventTime,
+		"region":      r.AWSRegion,
+		"resources":   []string{},
+		"detail":      json.RawMessage(detail),
+	}
+	return json.Marshal(event)
+}
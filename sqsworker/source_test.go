@@ -0,0 +1,119 @@
+package sqsworker
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestMain(m *testing.M) {
+	Log = logrus.New()
+	retCode := m.Run()
+	os.Exit(retCode)
+}
+
+func TestFileSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arebot-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// two CloudWatch events as JSON Lines
+	if err := ioutil.WriteFile(filepath.Join(dir, "a-events.jsonl"), []byte(cloudWatchEventLine+"\n"+cloudWatchEventLine+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a gzip compressed CloudTrail log file with a single record
+	f, err := os.Create(filepath.Join(dir, "b-cloudtrail.json.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte(`{"Records":[` + cloudTrailRecord + `]}`))
+	gz.Close()
+	f.Close()
+
+	src, err := NewFileSource(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []*sqs.Message
+	for {
+		batch, err := src.Receive(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, batch...)
+	}
+
+	if len(messages) != 3 {
+		t.Fatalf("FileSource should return 3 messages, but returned %d", len(messages))
+	}
+
+	var event struct {
+		DetailType string `json:"detail-type"`
+		Source     string `json:"source"`
+		Account    string `json:"account"`
+		Detail     struct {
+			EventName string `json:"eventName"`
+		} `json:"detail"`
+	}
+	if err := json.Unmarshal([]byte(aws.StringValue(messages[2].Body)), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.DetailType != "AWS API Call via CloudTrail" || event.Source != "aws.ec2" || event.Account != "222233334444" {
+		t.Errorf("CloudTrail record wrapped into the wrong event envelope: %+v", event)
+	}
+	if event.Detail.EventName != "CreateSecurityGroup" {
+		t.Errorf("CloudTrail record not preserved as event detail: %+v", event)
+	}
+}
+
+const cloudWatchEventLine = `{"version":"0","id":"5afe66f1-7fc6-410a-83cf-1ba77cb14c8d","detail-type":"AWS API Call via CloudTrail","source":"aws.ec2","account":"222233334444","time":"2017-04-11T20:27:47Z","region":"eu-central-1","resources":[],"detail":{"eventName":"DeleteSecurityGroup","eventSource":"ec2.amazonaws.com","requestParameters":{"groupId":"sg-00aa11bb"}}}`
+
+const cloudTrailRecord = `{
+	"eventVersion": "1.05",
+	"userIdentity": {
+		"type": "IAMUser",
+		"arn": "arn:aws:iam::222233334444:user/dev",
+		"accountId": "222233334444"
+	},
+	"eventTime": "2017-04-11T20:27:47Z",
+	"eventSource": "ec2.amazonaws.com",
+	"eventName": "CreateSecurityGroup",
+	"awsRegion": "eu-central-1",
+	"requestParameters": {"groupName": "test", "groupDescription": "test", "vpcId": "vpc-22cc33dd"},
+	"responseElements": {"_return": true, "groupId": "sg-aa00bb11"},
+	"eventID": "793fc880-58cc-4126-86df-67045515f912",
+	"eventType": "AwsApiCall",
+	"recipientAccountId": "222233334444"
+}`
@@ -24,8 +24,22 @@ import (
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
-// NewSQSClient returns a SQS Client and a Queue URL for you you to connect to
+// NewSQSClient returns a SQS Client and a Queue URL for you you to connect to. AreBOT terminates if the queue cannot be accessed.
 func NewSQSClient(queueName string, cfgs ...*aws.Config) (*sqs.SQS, string) {
+	svc, url, err := GetSQSClient(queueName, cfgs...)
+	if err != nil {
+		// Print the error, cast err to aws err.Error to get the Code and
+		// Message from an error.
+		Log.Errorf("Impossible to access the '%s' SQS queue specified in the configuration file: %s", queueName, err.Error())
+		Log.Errorf("Terminate Arebot execution")
+		os.Exit(1)
+		// return nil, ""
+	}
+	return svc, url
+}
+
+// GetSQSClient returns a SQS Client and a Queue URL, or an error if the queue cannot be accessed
+func GetSQSClient(queueName string, cfgs ...*aws.Config) (*sqs.SQS, string, error) {
 	sess, err := session.NewSession()
 	if err != nil {
 		Log.Warn("failed to create session,", err)
@@ -39,15 +53,9 @@ func NewSQSClient(queueName string, cfgs ...*aws.Config) (*sqs.SQS, string) {
 		QueueName: aws.String(queueName), // Required
 	}
 	resp, err := svc.GetQueueUrl(params)
-
 	if err != nil {
-		// Print the error, cast err to aws err.Error to get the Code and
-		// Message from an error.
-		Log.Errorf("Impossible to access the '%s' SQS queue specified in the configuration file: %s", queueName, err.Error())
-		Log.Errorf("Terminate Arebot execution")
-		os.Exit(1)
-		// return nil, ""
+		return nil, "", err
 	}
 
-	return svc, aws.StringValue(resp.QueueUrl)
+	return svc, aws.StringValue(resp.QueueUrl), nil
 }
@@ -17,8 +17,11 @@ package sqsworker
 */
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"sync"
+	"time"
 
 	"github.com/kreuzwerker/arebot/resource/securitygroup"
 
@@ -29,15 +32,16 @@ import (
 )
 
 // HandlerFunc is used to define the Handler that is run on for each message
-type HandlerFunc func(msg *sqs.Message) error
+type HandlerFunc func(ctx context.Context, msg *sqs.Message) error
 
-func (f HandlerFunc) HandleMessage(msg *sqs.Message) error {
-	return f(msg)
+func (f HandlerFunc) HandleMessage(ctx context.Context, msg *sqs.Message) error {
+	return f(ctx, msg)
 }
 
-// Handler interface
+// Handler interface. The context is cancelled when the worker is stopped and the
+// handler did not complete within the DrainTimeout.
 type Handler interface {
-	HandleMessage(msg *sqs.Message) error
+	HandleMessage(ctx context.Context, msg *sqs.Message) error
 }
 
 type InvalidEventError struct {
@@ -67,76 +71,212 @@ var (
 	Log *logrus.Logger
 )
 
+// Worker handles the messages delivered by an EventSource
+type Worker struct {
+	Source  EventSource
+	Handler Handler
+	Retry   RetryPolicy
+	// optional destination of the messages that exceeded Retry.MaxAttempts or cannot be decoded
+	DeadLetter DeadLetter
+	// number of goroutines handling the messages (at least 1)
+	Workers int
+	// number of goroutines polling the source (at least 1)
+	Receivers int
+	// time given to the in-flight messages to complete once the worker is stopped
+	DrainTimeout time.Duration
+}
+
+// DefaultDrainTimeout is used by workers created without an explicit DrainTimeout, the default of shutdown_timeout_seconds
+var DefaultDrainTimeout = 30 * time.Second
+
+// NewWorker creates a Worker using the DefaultRetryPolicy, the DefaultDrainTimeout, a single receiver and handler goroutine,
+// and no dead-letter destination
+func NewWorker(src EventSource, h Handler) *Worker {
+	return &Worker{Source: src, Handler: h, Retry: DefaultRetryPolicy, Workers: 1, Receivers: 1, DrainTimeout: DefaultDrainTimeout}
+}
+
 // Start starts the polling and will continue polling till the application is forcibly stopped
 func Start(svc *sqs.SQS, queueURL string, h Handler) {
-	for {
-
-		Log.Debug("worker: Start Polling: ", queueURL)
-		params := &sqs.ReceiveMessageInput{
-			QueueUrl:            aws.String(queueURL), // Required
-			MaxNumberOfMessages: aws.Int64(MaxNumberOfMessage),
-			MessageAttributeNames: []*string{
-				aws.String("All"), // Required
-			},
-			WaitTimeSeconds: aws.Int64(WaitTimeSecond),
-		}
+	NewWorker(NewSQSSource(svc, queueURL), h).Run(context.Background())
+}
+
+// Run handles the messages delivered by src until the source is exhausted
+func Run(src EventSource, h Handler) {
+	NewWorker(src, h).Run(context.Background())
+}
+
+// Run polls the source and handles its messages until the source is exhausted or ctx is done. The receivers
+// hand the messages over to the pool of workers and stop polling while all the workers are busy.
+// Once ctx is done, the receivers stop and Run returns as soon as the in-flight messages are handled; the
+// handlers still running after the DrainTimeout are cancelled.
+func (w *Worker) Run(ctx context.Context) {
+	workers, receivers := w.Workers, w.Receivers
+	if workers < 1 {
+		workers = 1
+	}
+	if receivers < 1 {
+		receivers = 1
+	}
+	Log.Debugf("worker: %s: %d receivers, %d workers", w.Source.Name(), receivers, workers)
 
-		resp, err := svc.ReceiveMessage(params)
+	messages := make(chan *sqs.Message)
+
+	// the handlers do not use ctx: the in-flight messages are drained after the worker has been stopped
+	handlerCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+	go w.drain(ctx, handlerCtx, abort)
+
+	var handlers sync.WaitGroup
+	handlers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer handlers.Done()
+			for m := range messages {
+				if err := w.handleMessage(handlerCtx, m); err != nil {
+					Log.Errorf("sqsworker.worker.run: %s", err.Error())
+				}
+			}
+		}()
+	}
+
+	var pollers sync.WaitGroup
+	pollers.Add(receivers)
+	for i := 0; i < receivers; i++ {
+		go func() {
+			defer pollers.Done()
+			w.poll(ctx, messages)
+		}()
+	}
+
+	pollers.Wait()
+	close(messages)
+	handlers.Wait()
+}
+
+// poll receives messages from the source and sends them to the workers until the source is exhausted or ctx is done
+func (w *Worker) poll(ctx context.Context, messages chan<- *sqs.Message) {
+	for ctx.Err() == nil {
+		Log.Debug("worker: Start Polling: ", w.Source.Name())
+		batch, err := w.Source.Receive(ctx)
+		if err == io.EOF {
+			Log.Infof("worker: no more messages from %s", w.Source.Name())
+			return
+		}
 		if err != nil {
-			Log.Println(err)
+			if ctx.Err() == nil {
+				Log.Println(err)
+			}
 			continue
 		}
-		if len(resp.Messages) > 0 {
-			run(svc, queueURL, h, resp.Messages)
+		Log.Debugf("worker: Received %d messages", len(batch))
+		for i, m := range batch {
+			select {
+			case messages <- m:
+			case <-ctx.Done():
+				// not handed over to a worker: the messages become visible again after their visibility timeout
+				Log.Debugf("worker: stopped, %d received messages left to the queue", len(batch)-i)
+				return
+			}
 		}
 	}
+	Log.Infof("worker: stopped receiving from %s", w.Source.Name())
 }
 
-// poll launches goroutine per received message and wait for all message to be processed
-func run(svc *sqs.SQS, queueURL string, h Handler, messages []*sqs.Message) {
-	numMessages := len(messages)
-	Log.Debugf("worker: Received %d messages", numMessages)
-
-	var wg sync.WaitGroup
-	wg.Add(numMessages)
-	for i := range messages {
-		go func(m *sqs.Message) {
-			// launch goroutine
-			defer wg.Done()
-			if err := handleMessage(svc, queueURL, m, h); err != nil {
-				Log.Errorf("sqsworker.worker.run: %s", err.Error())
-			}
-		}(messages[i])
+// drain cancels the handlers if they are still running DrainTimeout after ctx is done
+func (w *Worker) drain(ctx, handlerCtx context.Context, abort context.CancelFunc) {
+	select {
+	case <-ctx.Done():
+	case <-handlerCtx.Done(): // Run returned
+		return
 	}
 
-	wg.Wait()
+	timer := time.NewTimer(w.DrainTimeout)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		Log.Warnf("worker: %s: messages still in flight after %s, cancelling them", w.Source.Name(), w.DrainTimeout)
+		abort()
+	case <-handlerCtx.Done():
+	}
 }
 
-func handleMessage(svc *sqs.SQS, queueURL string, m *sqs.Message, h Handler) error {
+func (w *Worker) handleMessage(ctx context.Context, m *sqs.Message) error {
 	var err error
-	err = h.HandleMessage(m)
+	err = w.callHandler(ctx, m)
+
+	/* cancelled by the shutdown */
+	if err != nil && ctx.Err() != nil {
+		return w.release(m, err)
+	}
 
 	/* sqs worker errors */
 	if _, ok := err.(InvalidEventError); ok {
 		Log.Error(err.Error())
-	} else /* securitygroup errors */ if _err, ok := err.(securitygroup.SecurityGroupError); ok {
-		if _err.Ignore != true {
-			return err
-		}
+	} else /* securitygroup errors */ if _err, ok := err.(securitygroup.SecurityGroupError); ok && _err.Ignore {
 		Log.Warnf("Ignoring error and deleting message: %s", _err.Error())
 	} else /* all other error */ if err != nil {
-		return err
+		return w.handleFailure(m, err)
+	}
+
+	return w.Source.Delete(m)
+}
+
+// callHandler runs the handler, turning a panic into an error so that it counts as a failed attempt
+func (w *Worker) callHandler(ctx context.Context, m *sqs.Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic: %v", r)
+		}
+	}()
+	return w.Handler.HandleMessage(ctx, m)
+}
+
+// release makes a message whose handling has been cancelled immediately visible again, so that the next
+// AreBOT instance handles it without waiting for its backoff
+func (w *Worker) release(m *sqs.Message, cause error) error {
+	if retrier, ok := w.Source.(Retrier); ok {
+		if err := retrier.Retry(m, 0); err != nil {
+			Log.Errorf("worker: cannot release message %s: %s", aws.StringValue(m.MessageId), err)
+		}
 	}
+	return fmt.Errorf("handling of message %s cancelled: %s", aws.StringValue(m.MessageId), cause)
+}
+
+// handleFailure makes a failed message visible again after an exponential backoff or,
+// once it exceeded the maximum number of attempts, moves it to the dead-letter destination
+func (w *Worker) handleFailure(m *sqs.Message, cause error) error {
+	attempts := receiveCount(m)
+	retrier, canRetry := w.Source.(Retrier)
 
-	params := &sqs.DeleteMessageInput{
-		QueueUrl:      aws.String(queueURL), // Required
-		ReceiptHandle: m.ReceiptHandle,      // Required
+	if !isPermanent(cause) && canRetry && attempts < w.Retry.MaxAttempts {
+		backoff := w.Retry.Backoff(attempts)
+		Log.Warnf("worker: attempt %d/%d of message %s failed, retrying in %d seconds: %s",
+			attempts, w.Retry.MaxAttempts, aws.StringValue(m.MessageId), backoff, cause)
+		if err := retrier.Retry(m, backoff); err != nil {
+			Log.Errorf("worker: cannot change the visibility of message %s: %s", aws.StringValue(m.MessageId), err)
+		}
+		return cause
 	}
-	_, err = svc.DeleteMessage(params)
-	if err != nil {
-		return err
+
+	if w.DeadLetter == nil {
+		if isPermanent(cause) {
+			// never handled, whatever the number of attempts: keeping it would cost a receive per backoff forever
+			Log.Errorf("worker: dropping message %s, it cannot be handled and no dead-letter destination is configured: %s",
+				aws.StringValue(m.MessageId), cause)
+			return w.Source.Delete(m)
+		}
+		if canRetry {
+			// leave the message in the queue (a redrive policy of the queue may still apply)
+			retrier.Retry(m, w.Retry.MaxBackoffSeconds)
+		}
+		return fmt.Errorf("message %s failed after %d attempts and no dead-letter destination is configured: %s",
+			aws.StringValue(m.MessageId), attempts, cause)
 	}
-	Log.Debug("worker: deleted message from queue: %s", aws.StringValue(m.ReceiptHandle))
 
-	return nil
+	if err := w.DeadLetter.Quarantine(m, attempts, cause); err != nil {
+		return fmt.Errorf("cannot dead-letter message %s: %s (handling error: %s)", aws.StringValue(m.MessageId), err, cause)
+	}
+	Log.Errorf("worker: message %s moved to the dead-letter destination after %d attempts: %s",
+		aws.StringValue(m.MessageId), attempts, cause)
+	return w.Source.Delete(m)
 }
@@ -0,0 +1,256 @@
+package sqsworker
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+
+	"github.com/kreuzwerker/arebot/cloudwatch"
+)
+
+// testSource delivers a fixed list of messages once and records what the worker does with them
+type testSource struct {
+	mu       sync.Mutex
+	messages []*sqs.Message
+	deleted  []string
+	retried  map[string]int64
+}
+
+func (s *testSource) Receive(ctx context.Context) ([]*sqs.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.messages) == 0 {
+		return nil, io.EOF
+	}
+	m := s.messages
+	s.messages = nil
+	return m, nil
+}
+
+func (s *testSource) Delete(m *sqs.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleted = append(s.deleted, aws.StringValue(m.MessageId))
+	return nil
+}
+
+func (s *testSource) Retry(m *sqs.Message, delay int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retried[aws.StringValue(m.MessageId)] = delay
+	return nil
+}
+
+func (s *testSource) Name() string {
+	return "test"
+}
+
+func testMessage(id string, receiveCount string) *sqs.Message {
+	return &sqs.Message{
+		MessageId:  aws.String(id),
+		Body:       aws.String("{}"),
+		Attributes: map[string]*string{"ApproximateReceiveCount": aws.String(receiveCount)},
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, BackoffSeconds: 30, MaxBackoffSeconds: 100}
+	expected := map[int]int64{1: 30, 2: 60, 3: 100, 10: 100}
+	for attempts, backoff := range expected {
+		if p.Backoff(attempts) != backoff {
+			t.Errorf("Backoff(%d) == %d, expected %d", attempts, p.Backoff(attempts), backoff)
+		}
+	}
+}
+
+func TestWorkerRetryAndDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arebot-deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := &testSource{
+		messages: []*sqs.Message{
+			testMessage("ok", "1"),
+			testMessage("first-failure", "1"),
+			testMessage("last-failure", "3"),
+			testMessage("undecodable", "1"),
+			testMessage("panic", "3"),
+		},
+		retried: map[string]int64{},
+	}
+	w := NewWorker(src, HandlerFunc(func(ctx context.Context, m *sqs.Message) error {
+		switch aws.StringValue(m.MessageId) {
+		case "ok":
+			return nil
+		case "undecodable":
+			return cloudwatch.NewDecodeError("unexpected end of JSON input")
+		case "panic":
+			panic("index out of range")
+		}
+		return errors.New("describe failed")
+	}))
+	w.Retry = RetryPolicy{MaxAttempts: 3, BackoffSeconds: 10, MaxBackoffSeconds: 100}
+	w.DeadLetter = NewFolderDeadLetter(dir)
+	w.Run(context.Background())
+
+	if len(src.retried) != 1 || src.retried["first-failure"] != 10 {
+		t.Errorf("only the first failure should be retried after 10 seconds: %+v", src.retried)
+	}
+	if len(src.deleted) != 4 {
+		t.Errorf("the successful and the dead-lettered messages should be deleted: %+v", src.deleted)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 3 {
+		t.Errorf("3 messages should have been dead-lettered, found %d", len(files))
+	}
+
+	// dead-lettered messages can be replayed
+	replay, err := NewFileSource(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		messages, err := replay.Receive(context.Background())
+		if err != nil || len(messages) != 1 || aws.StringValue(messages[0].Body) != "{}" {
+			t.Errorf("dead-lettered messages cannot be replayed: %+v %s", messages, err)
+		}
+	}
+}
+
+func TestWorkerWithoutDeadLetter(t *testing.T) {
+	src := &testSource{
+		messages: []*sqs.Message{
+			testMessage("last-failure", "3"),
+			testMessage("undecodable", "1"),
+		},
+		retried: map[string]int64{},
+	}
+	w := NewWorker(src, HandlerFunc(func(ctx context.Context, m *sqs.Message) error {
+		if aws.StringValue(m.MessageId) == "undecodable" {
+			return cloudwatch.NewDecodeError("unexpected end of JSON input")
+		}
+		return errors.New("describe failed")
+	}))
+	w.Retry = RetryPolicy{MaxAttempts: 3, BackoffSeconds: 10, MaxBackoffSeconds: 100}
+	w.Run(context.Background())
+
+	if len(src.retried) != 1 || src.retried["last-failure"] != 100 {
+		t.Errorf("the last failure should be left to the queue after the maximum backoff: %+v", src.retried)
+	}
+	if len(src.deleted) != 1 || src.deleted[0] != "undecodable" {
+		t.Errorf("the message that cannot be decoded should be deleted: %+v", src.deleted)
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	src := &testSource{retried: map[string]int64{}}
+	for i := 0; i < 20; i++ {
+		src.messages = append(src.messages, testMessage(fmt.Sprintf("m%d", i), "1"))
+	}
+
+	var inFlight, maxInFlight int32
+	w := NewWorker(src, HandlerFunc(func(ctx context.Context, m *sqs.Message) error {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	}))
+	w.Workers = 3
+	w.Receivers = 2
+	w.Run(context.Background())
+
+	if len(src.deleted) != 20 {
+		t.Errorf("all the 20 messages should be handled and deleted, got %d", len(src.deleted))
+	}
+	if maxInFlight > 3 || maxInFlight < 2 {
+		t.Errorf("3 workers should handle the messages concurrently, but %d were in flight", maxInFlight)
+	}
+}
+
+// blockingSource delivers its messages and then blocks like an empty queue until ctx is done
+type blockingSource struct {
+	testSource
+}
+
+func (s *blockingSource) Receive(ctx context.Context) ([]*sqs.Message, error) {
+	if m, _ := s.testSource.Receive(ctx); len(m) > 0 {
+		return m, nil
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestWorkerShutdown(t *testing.T) {
+	src := &blockingSource{testSource{
+		messages: []*sqs.Message{testMessage("fast", "1"), testMessage("slow", "1")},
+		retried:  map[string]int64{},
+	}}
+	started := make(chan struct{}, 2)
+	w := NewWorker(src, HandlerFunc(func(ctx context.Context, m *sqs.Message) error {
+		started <- struct{}{}
+		if aws.StringValue(m.MessageId) == "fast" {
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		}
+		<-ctx.Done() // a call that only returns when cancelled
+		return ctx.Err()
+	}))
+	w.Workers = 2
+	w.DrainTimeout = 50 * time.Millisecond
+
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	<-started
+	<-started
+	stop()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("worker not stopped after the drain timeout")
+	}
+	if len(src.deleted) != 1 || src.deleted[0] != "fast" {
+		t.Errorf("the in-flight message should complete after the stop: %+v", src.deleted)
+	}
+	if delay, ok := src.retried["slow"]; !ok || delay != 0 {
+		t.Errorf("the cancelled message should be made visible again: %+v", src.retried)
+	}
+}
@@ -0,0 +1,65 @@
+package dynamodb
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/guregu/dynamo"
+
+	"github.com/kreuzwerker/arebot/util"
+)
+
+// the table recording the processed events; its partition key is EventId and ExpiresAt
+// can be configured as the time to live attribute of the table
+const eventsTableName = "ProcessedEvent"
+
+type processedEvent struct {
+	EventId     string
+	ProcessedAt time.Time
+	ExpiresAt   int64 // unix time
+}
+
+// GetEventProcessingTime returns when the event has been processed, or the zero time if it has not been recorded
+func GetEventProcessingTime(eventId string) (time.Time, error) {
+	db := dynamo.New(session.New(), util.GetDynamoDBConfig())
+	table := db.Table(eventsTableName)
+
+	var event processedEvent
+	if err := table.Get("EventId", eventId).One(&event); err != nil {
+		if err == dynamo.ErrNotFound {
+			return time.Time{}, nil
+		}
+		Log.Errorf("Error while reading processed event: %s. Error message: %s", eventId, err.Error())
+		return time.Time{}, err
+	}
+	return event.ProcessedAt, nil
+}
+
+// StoreProcessedEvent records the event as processed at the given time, to be expired after window
+func StoreProcessedEvent(eventId string, processedAt time.Time, window time.Duration) error {
+	db := dynamo.New(session.New(), util.GetDynamoDBConfig())
+	table := db.Table(eventsTableName)
+
+	event := processedEvent{EventId: eventId, ProcessedAt: processedAt, ExpiresAt: processedAt.Add(window).Unix()}
+	if err := table.Put(event).Run(); err != nil {
+		Log.Errorf("Error while storing processed event: %s. Error message: %s", eventId, err.Error())
+		return err
+	}
+	return nil
+}
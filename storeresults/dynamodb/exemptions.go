@@ -0,0 +1,76 @@
+package dynamodb
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/guregu/dynamo"
+
+	"github.com/kreuzwerker/arebot/config"
+	"github.com/kreuzwerker/arebot/util"
+)
+
+// ErrExemptionNotFound is returned by DeleteExemption for an exemption that is not stored
+var ErrExemptionNotFound = errors.New("dynamodb: exemption not found")
+
+// the table of the exemptions managed through the HTTP API; its partition key is Name
+const exemptionsTableName = "Exemption"
+
+// GetExemptions returns all the stored exemptions
+func GetExemptions() ([]config.Exemption, error) {
+	db := dynamo.New(session.New(), util.GetDynamoDBConfig())
+	table := db.Table(exemptionsTableName)
+
+	var exemptions []config.Exemption
+	if err := table.Scan().All(&exemptions); err != nil {
+		Log.Errorf("Error while reading the exemptions. Error message: %s", err.Error())
+		return nil, err
+	}
+	return exemptions, nil
+}
+
+// StoreExemption adds the exemption, or replaces the one with the same name
+func StoreExemption(exemption config.Exemption) error {
+	db := dynamo.New(session.New(), util.GetDynamoDBConfig())
+	table := db.Table(exemptionsTableName)
+
+	if err := table.Put(exemption).Run(); err != nil {
+		Log.Errorf("Error while storing exemption: %s. Error message: %s", exemption.Name, err.Error())
+		return err
+	}
+	return nil
+}
+
+// DeleteExemption deletes the exemption with the given name, or returns ErrExemptionNotFound
+func DeleteExemption(name string) error {
+	db := dynamo.New(session.New(), util.GetDynamoDBConfig())
+	table := db.Table(exemptionsTableName)
+
+	// Name is a reserved word of the DynamoDB expressions
+	err := table.Delete("Name", name).If("attribute_exists($)", "Name").Run()
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return ErrExemptionNotFound
+	}
+	if err != nil {
+		Log.Errorf("Error while deleting exemption: %s. Error message: %s", name, err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package storeresults
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"time"
+
+	"github.com/kreuzwerker/arebot/storeresults/dynamodb"
+	"github.com/kreuzwerker/arebot/storeresults/filesystem"
+)
+
+// IsEventProcessed returns true if the event has already been processed within the deduplication
+// window, fetching the processing time either from the dynamodb or the file system repos.
+func IsEventProcessed(eventId string) bool {
+	var processedAt time.Time
+
+	if Cfg.ShouldStoreOnDynamoDB() {
+		processedAt, _ = dynamodb.GetEventProcessingTime(eventId)
+	}
+	if processedAt.IsZero() {
+		processedAt, _ = filesystem.GetEventProcessingTime(eventId)
+	}
+
+	return !processedAt.IsZero() && time.Since(processedAt) < dedupWindow()
+}
+
+// MarkEventProcessed records the event as processed now
+func MarkEventProcessed(eventId string) error {
+	if err := beginWrite(); err != nil {
+		return err
+	}
+	defer endWrite()
+
+	now := time.Now()
+	if Cfg.ShouldStoreOnDynamoDB() {
+		if err := dynamodb.StoreProcessedEvent(eventId, now, dedupWindow()); err != nil {
+			return err
+		}
+	}
+	return filesystem.StoreProcessedEvent(eventId, now)
+}
+
+// PruneProcessedEvents removes the processed events recorded in the local folder and in the s3 bucket that are
+// older than the deduplication window (the dynamodb items expire through their TTL)
+func PruneProcessedEvents() error {
+	return filesystem.PruneProcessedEvents(dedupWindow())
+}
+
+func dedupWindow() time.Duration {
+	return time.Duration(Cfg.WorkerConfig.DedupWindowSeconds) * time.Second
+}
@@ -0,0 +1,141 @@
+package storeresults
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/kreuzwerker/arebot/config"
+	"github.com/kreuzwerker/arebot/storeresults/dynamodb"
+	"github.com/kreuzwerker/arebot/storeresults/filesystem"
+)
+
+// ErrExemptionNotFound is returned by DeleteExemption for an exemption that is not stored
+var ErrExemptionNotFound = errors.New("storeresults: exemption not found")
+
+// serializes the updates of the stored exemptions, which are read and written as a whole by the file system repo
+var exemptionsMu sync.Mutex
+
+// the stored exemptions are read again after exemptionsCacheTTL, to pick up the ones written by the other
+// AreBOT instances; the writes of this instance and a new configuration invalidate the cache
+const exemptionsCacheTTL = time.Minute
+
+var (
+	cacheMu          sync.Mutex
+	cachedExemptions []config.Exemption
+	cachedAt         time.Time
+)
+
+// GetExemptions returns the exemptions managed through the HTTP API, fetching them either from the dynamodb
+// or the file system repos unless they have been cached
+func GetExemptions() []config.Exemption {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if cachedAt.IsZero() || time.Since(cachedAt) > exemptionsCacheTTL {
+		cachedExemptions, cachedAt = readExemptions(), time.Now()
+	}
+	return cachedExemptions
+}
+
+func readExemptions() []config.Exemption {
+	if Cfg.ShouldStoreOnDynamoDB() {
+		if exemptions, err := dynamodb.GetExemptions(); err == nil && len(exemptions) > 0 {
+			return exemptions
+		}
+	}
+
+	exemptions, err := filesystem.GetExemptions()
+	if err != nil {
+		Log.Errorf("Cannot read the stored exemptions: %s", err)
+	}
+	return exemptions
+}
+
+// invalidateExemptions makes the next GetExemptions read the stored exemptions
+func invalidateExemptions() {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	cachedExemptions, cachedAt = nil, time.Time{}
+}
+
+// StoreExemption adds the exemption, or replaces the stored one with the same name
+func StoreExemption(exemption config.Exemption) error {
+	if err := beginWrite(); err != nil {
+		return err
+	}
+	defer endWrite()
+	exemptionsMu.Lock()
+	defer exemptionsMu.Unlock()
+	defer invalidateExemptions()
+
+	if Cfg.ShouldStoreOnDynamoDB() {
+		if err := dynamodb.StoreExemption(exemption); err != nil {
+			return err
+		}
+	}
+
+	exemptions, err := filesystem.GetExemptions()
+	if err != nil {
+		return err
+	}
+	for i := range exemptions {
+		if exemptions[i].Name == exemption.Name {
+			exemptions[i] = exemption
+			return filesystem.StoreExemptions(exemptions)
+		}
+	}
+	return filesystem.StoreExemptions(append(exemptions, exemption))
+}
+
+// DeleteExemption deletes the stored exemption with the given name
+func DeleteExemption(name string) error {
+	if err := beginWrite(); err != nil {
+		return err
+	}
+	defer endWrite()
+	exemptionsMu.Lock()
+	defer exemptionsMu.Unlock()
+	defer invalidateExemptions()
+
+	found := false
+	if Cfg.ShouldStoreOnDynamoDB() {
+		switch err := dynamodb.DeleteExemption(name); err {
+		case nil:
+			found = true
+		case dynamodb.ErrExemptionNotFound:
+		default:
+			return err
+		}
+	}
+
+	exemptions, err := filesystem.GetExemptions()
+	if err != nil {
+		return err
+	}
+	for i := range exemptions {
+		if exemptions[i].Name == name {
+			return filesystem.StoreExemptions(append(exemptions[:i], exemptions[i+1:]...))
+		}
+	}
+	if found {
+		// only stored in the dynamodb table
+		return nil
+	}
+	return ErrExemptionNotFound
+}
@@ -0,0 +1,156 @@
+package filesystem
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/kreuzwerker/arebot/util"
+)
+
+// suffix of the files recording the processed events, next to the check results files
+const eventFileSuffix = "-event"
+
+type processedEvent struct {
+	EventId     string    `json:"eventId"`
+	ProcessedAt time.Time `json:"processedAt"`
+}
+
+// GetEventProcessingTime returns when the event has been processed, looking into the local folder first and
+// into the s3 bucket then. It returns the zero time if the event has not been recorded.
+func GetEventProcessingTime(eventId string) (time.Time, error) {
+	bucket, folder := Cfg.GetBucketAndFolder()
+	var data []byte
+	var err error
+
+	if folder != "" {
+		if _, statErr := os.Stat(filepath.Join(folder, eventId+eventFileSuffix)); statErr == nil {
+			data, err = getDataFromFile(NewFileFetcher(), folder, eventId+eventFileSuffix)
+		}
+	}
+	if len(data) == 0 && bucket != "" {
+		data, err = getDataFromFile(NewS3FileFetcher(), bucket, eventId+eventFileSuffix)
+	}
+	if len(data) == 0 {
+		return time.Time{}, err
+	}
+
+	var event processedEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return time.Time{}, err
+	}
+	return event.ProcessedAt, nil
+}
+
+// StoreProcessedEvent records the event as processed at the given time
+func StoreProcessedEvent(eventId string, processedAt time.Time) error {
+	bucket, folder := Cfg.GetBucketAndFolder()
+
+	data, err := json.Marshal(processedEvent{EventId: eventId, ProcessedAt: processedAt})
+	if err != nil {
+		return err
+	}
+	if folder != "" {
+		if _, err := saveDataToFile(data, NewFileFetcher(), folder, eventId+eventFileSuffix); err != nil {
+			return err
+		}
+	}
+	if bucket != "" {
+		if _, err := saveDataToFile(data, NewS3FileFetcher(), bucket, eventId+eventFileSuffix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PruneProcessedEvents removes the processed events older than window, from the local folder and from the s3 bucket
+func PruneProcessedEvents(window time.Duration) error {
+	bucket, folder := Cfg.GetBucketAndFolder()
+	var errs *multierror.Error
+	if folder != "" {
+		errs = multierror.Append(errs, pruneLocalProcessedEvents(folder, window))
+	}
+	if bucket != "" {
+		errs = multierror.Append(errs, pruneS3ProcessedEvents(bucket, window))
+	}
+	return errs.ErrorOrNil()
+}
+
+func pruneLocalProcessedEvents(folder string, window time.Duration) error {
+	pruned := 0
+	err := filepath.Walk(folder, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !f.IsDir() && strings.HasSuffix(f.Name(), eventFileSuffix) && time.Since(f.ModTime()) > window {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			pruned++
+		}
+		return nil
+	})
+	Log.Debugf("Pruned %d processed events older than %s from %s.", pruned, window, folder)
+	return err
+}
+
+// pruneS3ProcessedEvents deletes the event objects of the bucket older than window, by batches of at most 1000
+// keys (the limit of DeleteObjects)
+func pruneS3ProcessedEvents(bucket string, window time.Duration) error {
+	svc := s3.New(session.Must(session.NewSession()), util.GetS3Config())
+
+	var expired []*s3.ObjectIdentifier
+	err := svc.ListObjectsPages(&s3.ListObjectsInput{Bucket: aws.String(bucket)}, func(page *s3.ListObjectsOutput, lastPage bool) bool {
+		for _, o := range page.Contents {
+			if strings.HasSuffix(aws.StringValue(o.Key), eventFileSuffix) && time.Since(aws.TimeValue(o.LastModified)) > window {
+				expired = append(expired, &s3.ObjectIdentifier{Key: o.Key})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(expired); start += 1000 {
+		end := start + 1000
+		if end > len(expired) {
+			end = len(expired)
+		}
+		_, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &s3.Delete{Objects: expired[start:end], Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	Log.Debugf("Pruned %d processed events older than %s from the bucket %s.", len(expired), window, bucket)
+	return nil
+}
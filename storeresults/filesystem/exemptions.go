@@ -0,0 +1,74 @@
+package filesystem
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/kreuzwerker/arebot/config"
+)
+
+// the file of the exemptions managed through the HTTP API, next to the check results files
+const exemptionsFile = "arebot-exemptions"
+
+// GetExemptions returns the stored exemptions, looking into the local folder first and into the s3 bucket then
+func GetExemptions() ([]config.Exemption, error) {
+	bucket, folder := Cfg.GetBucketAndFolder()
+	var data []byte
+	var err error
+
+	if folder != "" {
+		if _, statErr := os.Stat(filepath.Join(folder, exemptionsFile)); statErr == nil {
+			data, err = getDataFromFile(NewFileFetcher(), folder, exemptionsFile)
+		}
+	}
+	if len(data) == 0 && bucket != "" {
+		data, err = getDataFromFile(NewS3FileFetcher(), bucket, exemptionsFile)
+	}
+	if len(data) == 0 {
+		return nil, err
+	}
+
+	var exemptions []config.Exemption
+	if err := json.Unmarshal(data, &exemptions); err != nil {
+		return nil, err
+	}
+	return exemptions, nil
+}
+
+// StoreExemptions replaces the stored exemptions
+func StoreExemptions(exemptions []config.Exemption) error {
+	bucket, folder := Cfg.GetBucketAndFolder()
+
+	data, err := json.Marshal(exemptions)
+	if err != nil {
+		return err
+	}
+	if folder != "" {
+		if _, err := saveDataToFile(data, NewFileFetcher(), folder, exemptionsFile); err != nil {
+			return err
+		}
+	}
+	if bucket != "" {
+		if _, err := saveDataToFile(data, NewS3FileFetcher(), bucket, exemptionsFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
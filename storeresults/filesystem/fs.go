@@ -19,6 +19,7 @@ package filesystem
 import (
 	"encoding/json"
 	"errors"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -37,13 +38,13 @@ func GetCheckResultsByResourceId(resourceId string) (*[]config.CompliantCheckRes
 	var err error
 	if folder != "" {
 		// fetch the data from the state file associated with the sec.group `groupId`
-		pstr, err = getDataFromFile(f, folder, resourceId)
+		pstr, err = getDataFromFile(f, folder, resultsFile(resourceId))
 		if err != nil {
 			Log.Error(err)
 		}
 	}
 	if len(pstr) <= 0 && bucket != "" {
-		pstr, err = getDataFromFile(s3, folder, resourceId)
+		pstr, err = getDataFromFile(s3, folder, resultsFile(resourceId))
 		if err != nil {
 			Log.Error(err)
 		}
@@ -78,8 +79,12 @@ func GetCheckResultsByActionAndPolicyName(action string, policyName string) (*[]
 
 	filenameList := []string{}
 	err := filepath.Walk(Cfg.S3Config.LocalFolder, func(path string, f os.FileInfo, err error) error {
-		if !f.IsDir() && !strings.HasSuffix(f.Name(), "-state") {
-			filenameList = append(filenameList, filepath.Base(path))
+		// skip the state files, the processed events, the exemptions and the temporary files of the interrupted writes
+		if !f.IsDir() && !strings.HasSuffix(f.Name(), "-state") && !strings.HasSuffix(f.Name(), eventFileSuffix) &&
+			f.Name() != exemptionsFile && !strings.HasSuffix(f.Name(), ".tmp") {
+			if resourceId, err := url.PathUnescape(filepath.Base(path)); err == nil {
+				filenameList = append(filenameList, resourceId)
+			}
 		}
 		return nil
 	})
@@ -126,7 +131,7 @@ func DeleteCheckResultsByResourceIdAndResultsList(resourceId string, resultsToDe
 }
 
 func DeleteCheckResultsByResourceId(resourceId string) error {
-	var err = os.Remove(Cfg.S3Config.LocalFolder + string(os.PathSeparator) + resourceId)
+	var err = os.Remove(Cfg.S3Config.LocalFolder + string(os.PathSeparator) + resultsFile(resourceId))
 	return err
 }
 
@@ -144,9 +149,9 @@ func StoreCheckResults(resourceId string, results []config.CompliantCheckResult)
 	}
 
 	// save the encoded merged array into the local folder, if any configured
-	// (the file name is the same as the resourceId)
+	// (the file name is the resourceId, see resultsFile)
 	if folder != "" {
-		pstr, err := saveDataToFile(byteArr, f, folder, resourceId)
+		pstr, err := saveDataToFile(byteArr, f, folder, resultsFile(resourceId))
 		if err != nil {
 			Log.Error(err)
 			return err
@@ -155,9 +160,9 @@ func StoreCheckResults(resourceId string, results []config.CompliantCheckResult)
 	}
 
 	// save the encoded merged array into the s3 bucket, if any configured
-	// (the file name is the same as the resourceId)
+	// (the file name is the resourceId, see resultsFile)
 	if bucket != "" {
-		pstrS3, err := saveDataToFile(byteArr, s3, bucket, resourceId)
+		pstrS3, err := saveDataToFile(byteArr, s3, bucket, resultsFile(resourceId))
 		if err != nil {
 			Log.Error(err)
 			return err
@@ -167,6 +172,12 @@ func StoreCheckResults(resourceId string, results []config.CompliantCheckResult)
 	return nil
 }
 
+// resultsFile returns the name of the file of the check results of the resource: its ID, escaped for the
+// IDs that are paths, e.g. the ARNs of the IAM users ("arn:aws:iam::000011112222:user%2Falice")
+func resultsFile(resourceId string) string {
+	return url.PathEscape(resourceId)
+}
+
 // ************************************************************************************
 // ***	Functions used for testing purposes
 
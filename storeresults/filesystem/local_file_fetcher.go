@@ -59,7 +59,17 @@ func (fetcher *fileFetcher) PutObject(s3Input *s3.PutObjectInput) (*s3.PutObject
   }
   //log.Printf("%+v, %+v", furl, filepath.Dir(furl))
   b, err := ioutil.ReadAll(s3Input.Body)
-  if err = ioutil.WriteFile(furl, b, 0644); err != nil {
+  if err != nil {
+    return nil, err
+  }
+  // write a temporary file and rename it, so that an interrupted write never leaves a truncated file
+  tmp := furl + ".tmp"
+  if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+    os.Remove(tmp)
+    return nil, err
+  }
+  if err = os.Rename(tmp, furl); err != nil {
+    os.Remove(tmp)
     return nil, err
   }
   return &s3.PutObjectOutput{}, nil
@@ -19,6 +19,7 @@ package storeresults
 import (
 	"errors"
 	"os"
+	"sync"
 
 	"github.com/Sirupsen/logrus"
 
@@ -32,6 +33,13 @@ var (
 	Log = newLogger()
 	// Cfg Config for this package
 	Cfg *config.Config
+
+	// ErrStorageFlushed is returned by the writes issued after Flush
+	ErrStorageFlushed = errors.New("storeresults: the storage has been flushed on shutdown")
+
+	// held (shared) by every write to the storage backends and (exclusive) by Flush
+	writesMu sync.RWMutex
+	flushed  bool
 )
 
 func newLogger() *logrus.Logger {
@@ -50,6 +58,32 @@ func InitVars(log *logrus.Logger, cfg *config.Config) {
 	Cfg = cfg
 	dynamodb.Cfg = cfg
 	filesystem.Cfg = cfg
+	// the storage of the new configuration may differ
+	invalidateExemptions()
+}
+
+/*	Flush waits for the writes in progress to complete and rejects all the further ones, so that no
+	state file is left half-written when AreBOT terminates.
+*/
+func Flush() {
+	writesMu.Lock()
+	defer writesMu.Unlock()
+	flushed = true
+	Log.Info("Check results storage flushed.")
+}
+
+// beginWrite registers a write to the storage backends; endWrite must be called once it completed
+func beginWrite() error {
+	writesMu.RLock()
+	if flushed {
+		writesMu.RUnlock()
+		return ErrStorageFlushed
+	}
+	return nil
+}
+
+func endWrite() {
+	writesMu.RUnlock()
 }
 
 /* 	GetResourceCheckResults, given a security group ID, checks whether there is any CompliantCheckResult object
@@ -89,6 +123,10 @@ func GetCheckResultsByActionAndPolicyName(actionName, policyName string) (*[]con
 	a resource into either a local folder, or s3 bucket, or both. The stored objects must be: non duplicated (FILO), non compliant
 */
 func StoreResourceCheckResults(resourceId string, results []config.CompliantCheckResult) error {
+	if err := beginWrite(); err != nil {
+		return err
+	}
+	defer endWrite()
 
 	// the merged array of current and stored CompliantCheckResults objects associated with
 	// the resource, and encoded as JSON
@@ -114,6 +152,11 @@ func StoreResourceCheckResults(resourceId string, results []config.CompliantChec
 func DeleteCheckResultsByResourceId(resourceId string) error {
 	var err error
 
+	if err = beginWrite(); err != nil {
+		return err
+	}
+	defer endWrite()
+
 	if Cfg.ShouldStoreOnDynamoDB() {
 		err = dynamodb.DeleteCheckResultsByResourceId(resourceId)
 		if err != nil {
@@ -129,6 +172,11 @@ func DeleteCheckResultsByResourceId(resourceId string) error {
  	contained in the passed list. Typically used to delete expired results
 */
 func DeleteCheckResultsByResourceIdAndResultsList(resourceId string, resultlist []config.CompliantCheckResult) {
+	if err := beginWrite(); err != nil {
+		Log.Errorf("Cannot delete the check results of %s: %s", resourceId, err)
+		return
+	}
+	defer endWrite()
 
 	if Cfg.ShouldStoreOnDynamoDB() {
 		for _, r := range resultlist {
@@ -186,6 +234,8 @@ func mergeWithCurrent(resourceId string, results []config.CompliantCheckResult)
 				if curr.IsSameCheck(stor) {
 					// always keep the first (stored) object
 					currentResultsToSkipIndexes = append(currentResultsToSkipIndexes, j)
+					// with the current exemption, which may have been granted or have expired since
+					(*storedContent)[i].Waived, (*storedContent)[i].Exemption = curr.Waived, curr.Exemption
 					// if the current check is compliant
 					if curr.IsCompliant {
 						// then delete also the stored object, as the non-compliance has been fixed
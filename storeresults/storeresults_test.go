@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/kreuzwerker/arebot/config"
+	"github.com/kreuzwerker/arebot/storeresults/filesystem"
 )
 
 const groupId = "sg-0011aabb"
@@ -102,3 +103,106 @@ func TestStoreResourceCheckResults(t *testing.T) {
 	_, folder := Cfg.GetBucketAndFolder()
 	os.RemoveAll(folder)
 }
+
+func TestProcessedEvents(t *testing.T) {
+	_, folder := Cfg.GetBucketAndFolder()
+	defer os.RemoveAll(folder)
+
+	eventId := "793fc880-58cc-4126-86df-67045515f912"
+	if IsEventProcessed(eventId) {
+		t.Error("The event should not be processed yet")
+	}
+	if err := MarkEventProcessed(eventId); err != nil {
+		t.Fatal(err)
+	}
+	if !IsEventProcessed(eventId) {
+		t.Error("The event should be processed")
+	}
+
+	// the processed events are not mistaken for check results
+	if results, _ := GetCheckResultsByActionAndPolicyName("ignore", "any"); results != nil && len(*results) > 0 {
+		t.Errorf("No check result expected, found: %v", *results)
+	}
+
+	// events older than the window are processed again
+	window := Cfg.WorkerConfig.DedupWindowSeconds
+	Cfg.WorkerConfig.DedupWindowSeconds = 0
+	defer func() { Cfg.WorkerConfig.DedupWindowSeconds = window }()
+	if IsEventProcessed(eventId) {
+		t.Error("The event processed outside of the window should be processed again")
+	}
+	if err := PruneProcessedEvents(); err != nil {
+		t.Error(err)
+	}
+	Cfg.WorkerConfig.DedupWindowSeconds = window
+	if IsEventProcessed(eventId) {
+		t.Error("The pruned event should be processed again")
+	}
+}
+
+func TestStoreExemptions(t *testing.T) {
+	defer os.Remove(".checkresult_states/arebot-exemptions")
+
+	bastion := config.Exemption{Name: "bastion", ResourceIds: []string{groupId}, Reason: "SSH bastion", Approver: "security@company.com", Expires: "2099-12-31"}
+	legacy := config.Exemption{Name: "legacy", TagKey: "Legacy", Reason: "migration", Approver: "cto@company.com", Expires: "2099-06-30"}
+	for _, e := range []config.Exemption{bastion, legacy} {
+		if err := StoreExemption(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bastion.Expires = "2099-01-31"
+	if err := StoreExemption(bastion); err != nil {
+		t.Fatal(err)
+	}
+	exemptions := GetExemptions()
+	if len(exemptions) != 2 || exemptions[0].Expires != "2099-01-31" || exemptions[1].TagKey != "Legacy" {
+		t.Errorf("exemptions == %+v expected the replaced bastion and legacy", exemptions)
+	}
+
+	if err := DeleteExemption("legacy"); err != nil {
+		t.Error(err)
+	}
+	if err := DeleteExemption("legacy"); err != ErrExemptionNotFound {
+		t.Errorf("err == %v expected ErrExemptionNotFound", err)
+	}
+	if exemptions := GetExemptions(); len(exemptions) != 1 || exemptions[0].Name != "bastion" {
+		t.Errorf("exemptions == %+v expected bastion", exemptions)
+	}
+
+	// the stored exemptions are cached, a write of another instance is picked up after the TTL
+	if err := filesystem.StoreExemptions(nil); err != nil {
+		t.Fatal(err)
+	}
+	if exemptions := GetExemptions(); len(exemptions) != 1 {
+		t.Errorf("exemptions == %+v expected the cached bastion", exemptions)
+	}
+	cachedAt = cachedAt.Add(-exemptionsCacheTTL)
+	if exemptions := GetExemptions(); len(exemptions) != 0 {
+		t.Errorf("exemptions == %+v expected none once the cache expired", exemptions)
+	}
+}
+
+func TestStoreResultsOfArns(t *testing.T) {
+	_, folder := Cfg.GetBucketAndFolder()
+	defer os.RemoveAll(folder)
+
+	// the ARNs of the IAM resources are paths
+	userArn := "arn:aws:iam::000011112222:user/alice"
+	check := config.CompliantCheck{Name: "MFADevices", Mandatory: true, Actions: []string{"notify"}, PolicyName: "iam"}
+	StoreResourceCheckResults(userArn, []config.CompliantCheckResult{{EventType: "CreateUser", ResourceId: userArn,
+		IsCompliant: false, Check: check, Value: "missing", CreationDate: time.Now()}})
+
+	if results := GetResourceCheckResults(userArn); results == nil || len(*results) != 1 {
+		t.Fatalf("results == %v expected the result of the user", results)
+	}
+	results, err := GetCheckResultsByActionAndPolicyName("notify", "iam")
+	if err != nil || len(*results) != 1 || (*results)[0].ResourceId != userArn {
+		t.Errorf("results == %v, %v expected the result of the user", results, err)
+	}
+	if err := DeleteCheckResultsByResourceId(userArn); err != nil {
+		t.Error(err)
+	}
+	if results := GetResourceCheckResults(userArn); results != nil && len(*results) != 0 {
+		t.Errorf("results == %v expected none once deleted", *results)
+	}
+}
@@ -17,16 +17,19 @@ package util
 */
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/kreuzwerker/arebot/config"
 	"github.com/kreuzwerker/arebot/ldap"
 	"github.com/Sirupsen/logrus"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -39,6 +42,9 @@ var (
 	Log = newLogger()
 	// Cfg Config for this package
 	Cfg *config.Config
+
+	awsConfigsMu sync.Mutex
+	awsConfigs   = map[string]*aws.Config{}
 )
 
 type AwsResourceType interface {
@@ -46,6 +52,19 @@ type AwsResourceType interface {
 	GetProperties(string)	[]string
 }
 
+// VpcResource is implemented by the resources that belong to a VPC
+type VpcResource interface {
+	GetVpcId() string
+}
+
+// GetVpcId returns the VPC of the resource, or an empty string if it does not belong to a VPC
+func GetVpcId(r AwsResourceType) string {
+	if v, ok := r.(VpcResource); ok {
+		return v.GetVpcId()
+	}
+	return ""
+}
+
 func newLogger() *logrus.Logger {
 	_log := logrus.New()
 	_log.Out = os.Stdout
@@ -54,7 +73,35 @@ func newLogger() *logrus.Logger {
 	return _log
 }
 
-func GetAWSConfig(accountID string) *aws.Config {
+// GetAWSConfig returns the configuration of the calls to the AWS APIs of an account in the given region,
+// or in the region of the account if region is empty
+func GetAWSConfig(accountID string, region string) *aws.Config {
+	// the assumed role credentials are cached and refreshed by the SDK, so one
+	// configuration per account avoids an STS call for each handled event
+	awsConfigsMu.Lock()
+	defer awsConfigsMu.Unlock()
+	base, ok := awsConfigs[accountID]
+	if !ok {
+		if base = newAWSConfig(accountID); base == nil {
+			return nil
+		}
+		awsConfigs[accountID] = base
+	}
+	if region == "" || region == aws.StringValue(base.Region) {
+		return base
+	}
+	// the regional configurations share the credentials of the account
+	return base.Copy().WithRegion(region)
+}
+
+// ResetAWSConfigs drops the cached configurations, e.g. after the role of an account has changed
+func ResetAWSConfigs() {
+	awsConfigsMu.Lock()
+	defer awsConfigsMu.Unlock()
+	awsConfigs = map[string]*aws.Config{}
+}
+
+func newAWSConfig(accountID string) *aws.Config {
 	var account *config.Account
 
 	if account = Cfg.GetAccount(accountID); account == nil {
@@ -102,9 +149,14 @@ func GetDynamoDBConfig() *aws.Config {
 /*
 Describe security group current state getter 123Test123 172.31.18.11
 */
-func DescribeSecurityGroupById(id string, accountID string) (*ec2.DescribeSecurityGroupsOutput, error) {
+func DescribeSecurityGroupById(ctx context.Context, id string, accountID string, region string) (*ec2.DescribeSecurityGroupsOutput, error) {
+	release, err := acquireDescribeSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-	cfg := GetAWSConfig(accountID)
+	cfg := GetAWSConfig(accountID, region)
 	if cfg == nil {
 		return nil, errors.New("Can't describe resource ID: " + id)
 	}
@@ -117,7 +169,7 @@ func DescribeSecurityGroupById(id string, accountID string) (*ec2.DescribeSecuri
 			aws.String(id),
 		},
 	}
-	resp, err := svc.DescribeSecurityGroups(params)
+	resp, err := svc.DescribeSecurityGroupsWithContext(ctx, params)
 
 	if err != nil {
 		return nil, err
@@ -128,12 +180,23 @@ func DescribeSecurityGroupById(id string, accountID string) (*ec2.DescribeSecuri
 	return resp, nil
 }
 
+// IsAWSErrorCode returns true if err is an AWS error with one of the given codes, e.g. "InvalidInstanceID.NotFound"
+func IsAWSErrorCode(err error, codes ...string) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && config.ContainsString(codes, aerr.Code())
+}
+
 /*
 Describe EC2 instance
 */
-func DescribeEC2ById(id string, accountID string) (*ec2.Reservation, error) {
+func DescribeEC2ById(ctx context.Context, id string, accountID string, region string) (*ec2.Reservation, error) {
+release, err := acquireDescribeSlot(ctx)
+if err != nil {
+	return nil, err
+}
+defer release()
 
-cfg := GetAWSConfig(accountID)
+cfg := GetAWSConfig(accountID, region)
 if cfg == nil {
 	return nil, errors.New("Can't describe resource ID: " + id)
 }
@@ -146,7 +209,7 @@ params := &ec2.DescribeInstancesInput{
 		aws.String(id),
 	},
 }
-resp, err := svc.DescribeInstances(params)
+resp, err := svc.DescribeInstancesWithContext(ctx, params)
 
 if err != nil {
 	return nil, err
@@ -157,9 +220,14 @@ return resp.Reservations[0], nil
 /*
 Describe Volume instance
 */
-func DescribeVolumeById(id string, accountID string) (*ec2.Volume, error) {
+func DescribeVolumeById(ctx context.Context, id string, accountID string, region string) (*ec2.Volume, error) {
+	release, err := acquireDescribeSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-	cfg := GetAWSConfig(accountID)
+	cfg := GetAWSConfig(accountID, region)
 	if cfg == nil {
 		return nil, errors.New("Can't describe resource ID: " /*+ sg.ID*/)
 	}
@@ -172,7 +240,7 @@ func DescribeVolumeById(id string, accountID string) (*ec2.Volume, error) {
 			aws.String(id),
 		},
 	}
-	resp, err := svc.DescribeVolumes(params)
+	resp, err := svc.DescribeVolumesWithContext(ctx, params)
 
 	if err != nil {
 		return nil, err
@@ -183,9 +251,14 @@ func DescribeVolumeById(id string, accountID string) (*ec2.Volume, error) {
 /*
 Describe Snapshot instance
 */
-func DescribeSnapshotById(id string, accountID string) (*ec2.Snapshot, error) {
+func DescribeSnapshotById(ctx context.Context, id string, accountID string, region string) (*ec2.Snapshot, error) {
+	release, err := acquireDescribeSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-	cfg := GetAWSConfig(accountID)
+	cfg := GetAWSConfig(accountID, region)
 	if cfg == nil {
 		return nil, errors.New("Can't describe resource ID: " /*+ sg.ID*/)
 	}
@@ -198,7 +271,7 @@ func DescribeSnapshotById(id string, accountID string) (*ec2.Snapshot, error) {
 			aws.String(id),
 		},
 	}
-	resp, err := svc.DescribeSnapshots(params)
+	resp, err := svc.DescribeSnapshotsWithContext(ctx, params)
 
 	if err != nil {
 		return nil, err
@@ -206,9 +279,14 @@ func DescribeSnapshotById(id string, accountID string) (*ec2.Snapshot, error) {
 	return resp.Snapshots[0], nil
 }
 
-func DescribeSecurityGroupsByTag(accountID string, tagKey string, tagValue string) (*ec2.DescribeSecurityGroupsOutput, error) {
+func DescribeSecurityGroupsByTag(ctx context.Context, accountID string, region string, tagKey string, tagValue string) (*ec2.DescribeSecurityGroupsOutput, error) {
+	release, err := acquireDescribeSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-	cfg := GetAWSConfig(accountID)
+	cfg := GetAWSConfig(accountID, region)
 	if cfg == nil {
 		return nil, errors.New("Can't describe resource ID: " /*+ sg.ID*/)
 	}
@@ -228,7 +306,7 @@ func DescribeSecurityGroupsByTag(accountID string, tagKey string, tagValue strin
 	params := &ec2.DescribeSecurityGroupsInput{
 		Filters: filters,
 	}
-	resp, err := svc.DescribeSecurityGroups(params)
+	resp, err := svc.DescribeSecurityGroupsWithContext(ctx, params)
 
 	if err != nil {
 		return nil, err
@@ -239,18 +317,26 @@ func DescribeSecurityGroupsByTag(accountID string, tagKey string, tagValue strin
 	return resp, nil
 }
 
-func ParseEC2ResponseRunStartInstance(response interface{}, property string) (string, error) {
-	respMap := response.(map[string]interface{})
-	isets := (respMap["instancesSet"].(map[string]interface{}))["items"].([]interface{})
-	propMap := isets[0].(map[string]interface{})
+// ParseEC2ResponseRunStartInstances returns the property of every instance in the instancesSet
+// of a RunInstances/StartInstances response
+func ParseEC2ResponseRunStartInstances(response interface{}, property string) ([]string, error) {
+	respMap, _ := response.(map[string]interface{})
+	iset, _ := respMap["instancesSet"].(map[string]interface{})
+	items, _ := iset["items"].([]interface{})
+	if len(items) == 0 {
+		return nil, errors.New("aws_utils: Error parsing RunInstances/StartInstances response elements. Cannot find the instancesSet items.")
+	}
 
-	result := propMap[property]
-	if result == nil {
-		err := errors.New("aws_utils: Error parsing RunInstances/StartInstances response elements. Cannot find the " + property + " property.")
-		return "", err
-	} else {
-		return result.(string), nil
+	var result []string
+	for _, item := range items {
+		propMap, _ := item.(map[string]interface{})
+		value, ok := propMap[property].(string)
+		if !ok {
+			return nil, errors.New("aws_utils: Error parsing RunInstances/StartInstances response elements. Cannot find the " + property + " property.")
+		}
+		result = append(result, value)
 	}
+	return result, nil
 }
 
 func FindEmailBasedOnUserIdentity(accountID string, userIdentity map[string]string) string {
@@ -285,7 +371,7 @@ func FindEmailBasedOnUserName(userName string) string {
 }
 
 // TODO send reports of multiple check results
-func SendEmail(emailAddress string, result config.CompliantCheckResult, template string) error {
+func SendEmail(ctx context.Context, emailAddress string, result config.CompliantCheckResult, template string) error {
 
 	cfg := GetSesConfig()
 	Log.Infof("Sending email to: %s", emailAddress)
@@ -318,7 +404,7 @@ func SendEmail(emailAddress string, result config.CompliantCheckResult, template
 			Body:    &ses.Body{Html: &ses.Content{Data: aws.String(finalMessageBody)}},
 		},
 	}
-	output, err := svc.SendEmail(message)
+	output, err := svc.SendEmailWithContext(ctx, message)
 	if err != nil {
 		return errors.New("Failed delivery: " + err.Error())
 	}
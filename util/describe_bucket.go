@@ -0,0 +1,126 @@
+package util
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/kreuzwerker/arebot/config"
+)
+
+// BucketDescription is the state of a bucket, read from the configurations of the bucket. The
+// configurations that are not set are nil (or empty).
+type BucketDescription struct {
+	Name              string
+	Tags              []*s3.Tag
+	Encryption        *s3.ServerSideEncryptionConfiguration
+	Versioning        *s3.GetBucketVersioningOutput
+	PublicAccessBlock *s3.PublicAccessBlockConfiguration
+	Grants            []*s3.Grant
+	// the bucket policy document, in JSON
+	Policy         string
+	Logging        *s3.LoggingEnabled
+	LifecycleRules []*s3.LifecycleRule
+}
+
+// the error codes of the configurations that are not set
+var bucketConfigurationNotFound = []string{"NoSuchTagSet", "ServerSideEncryptionConfigurationNotFoundError",
+	"NoSuchPublicAccessBlockConfiguration", "NoSuchBucketPolicy", "NoSuchLifecycleConfiguration"}
+
+// DescribeBucket returns the state of the bucket, with one call per configuration
+func DescribeBucket(ctx context.Context, name string, accountID string, region string) (*BucketDescription, error) {
+	release, err := acquireDescribeSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	cfg := GetAWSConfig(accountID, region)
+	if cfg == nil {
+		return nil, errors.New("Can't describe bucket: " + name)
+	}
+
+	sess := session.Must(session.NewSession())
+	svc := s3.New(sess, cfg)
+	bucket := aws.String(name)
+	desc := &BucketDescription{Name: name}
+
+	tagging, err := svc.GetBucketTaggingWithContext(ctx, &s3.GetBucketTaggingInput{Bucket: bucket})
+	if err = ignoreNotFound(err); err != nil {
+		return nil, err
+	}
+	if tagging != nil {
+		desc.Tags = tagging.TagSet
+	}
+	encryption, err := svc.GetBucketEncryptionWithContext(ctx, &s3.GetBucketEncryptionInput{Bucket: bucket})
+	if err = ignoreNotFound(err); err != nil {
+		return nil, err
+	}
+	if encryption != nil {
+		desc.Encryption = encryption.ServerSideEncryptionConfiguration
+	}
+	if desc.Versioning, err = svc.GetBucketVersioningWithContext(ctx, &s3.GetBucketVersioningInput{Bucket: bucket}); err != nil {
+		return nil, err
+	}
+	publicAccess, err := svc.GetPublicAccessBlockWithContext(ctx, &s3.GetPublicAccessBlockInput{Bucket: bucket})
+	if err = ignoreNotFound(err); err != nil {
+		return nil, err
+	}
+	if publicAccess != nil {
+		desc.PublicAccessBlock = publicAccess.PublicAccessBlockConfiguration
+	}
+	acl, err := svc.GetBucketAclWithContext(ctx, &s3.GetBucketAclInput{Bucket: bucket})
+	if err != nil {
+		return nil, err
+	}
+	desc.Grants = acl.Grants
+	policy, err := svc.GetBucketPolicyWithContext(ctx, &s3.GetBucketPolicyInput{Bucket: bucket})
+	if err = ignoreNotFound(err); err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		desc.Policy = aws.StringValue(policy.Policy)
+	}
+	logging, err := svc.GetBucketLoggingWithContext(ctx, &s3.GetBucketLoggingInput{Bucket: bucket})
+	if err != nil {
+		return nil, err
+	}
+	desc.Logging = logging.LoggingEnabled
+	lifecycle, err := svc.GetBucketLifecycleConfigurationWithContext(ctx, &s3.GetBucketLifecycleConfigurationInput{Bucket: bucket})
+	if err = ignoreNotFound(err); err != nil {
+		return nil, err
+	}
+	if lifecycle != nil {
+		desc.LifecycleRules = lifecycle.Rules
+	}
+
+	return desc, nil
+}
+
+// ignoreNotFound returns nil for the errors of the bucket configurations that are not set
+func ignoreNotFound(err error) error {
+	if aerr, ok := err.(awserr.Error); ok && config.ContainsString(bucketConfigurationNotFound, aerr.Code()) {
+		return nil
+	}
+	return err
+}
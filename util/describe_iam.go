@@ -0,0 +1,183 @@
+package util
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// IAMUserDescription is the state of an IAM user, with its access keys, MFA devices and policies
+type IAMUserDescription struct {
+	User             *iam.User
+	AccessKeys       []*iam.AccessKeyMetadata
+	MFADevices       []*iam.MFADevice
+	AttachedPolicies []*iam.AttachedPolicy
+	// the documents of the inline policies by name, in JSON
+	InlinePolicies map[string]string
+	// the documents of the default versions of the attached policies by name, in JSON
+	AttachedPolicyDocuments map[string]string
+}
+
+// IAMRoleDescription is the state of an IAM role, with its policies. The trust policy of the
+// role (AssumeRolePolicyDocument) is decoded.
+type IAMRoleDescription struct {
+	Role             *iam.Role
+	AttachedPolicies []*iam.AttachedPolicy
+	// the documents of the inline policies by name, in JSON
+	InlinePolicies map[string]string
+	// the documents of the default versions of the attached policies by name, in JSON
+	AttachedPolicyDocuments map[string]string
+}
+
+// DescribeIAMUser returns the state of the IAM user with the given name
+func DescribeIAMUser(ctx context.Context, name string, accountID string, region string) (*IAMUserDescription, error) {
+	release, err := acquireDescribeSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	svc, err := iamService(name, accountID, region)
+	if err != nil {
+		return nil, err
+	}
+	userName := aws.String(name)
+	desc := &IAMUserDescription{InlinePolicies: map[string]string{}}
+
+	user, err := svc.GetUserWithContext(ctx, &iam.GetUserInput{UserName: userName})
+	if err != nil {
+		return nil, err
+	}
+	desc.User = user.User
+	keys, err := svc.ListAccessKeysWithContext(ctx, &iam.ListAccessKeysInput{UserName: userName})
+	if err != nil {
+		return nil, err
+	}
+	desc.AccessKeys = keys.AccessKeyMetadata
+	devices, err := svc.ListMFADevicesWithContext(ctx, &iam.ListMFADevicesInput{UserName: userName})
+	if err != nil {
+		return nil, err
+	}
+	desc.MFADevices = devices.MFADevices
+	attached, err := svc.ListAttachedUserPoliciesWithContext(ctx, &iam.ListAttachedUserPoliciesInput{UserName: userName})
+	if err != nil {
+		return nil, err
+	}
+	desc.AttachedPolicies = attached.AttachedPolicies
+	if desc.AttachedPolicyDocuments, err = attachedPolicyDocuments(ctx, svc, desc.AttachedPolicies); err != nil {
+		return nil, err
+	}
+	inline, err := svc.ListUserPoliciesWithContext(ctx, &iam.ListUserPoliciesInput{UserName: userName})
+	if err != nil {
+		return nil, err
+	}
+	for _, policyName := range inline.PolicyNames {
+		policy, err := svc.GetUserPolicyWithContext(ctx, &iam.GetUserPolicyInput{UserName: userName, PolicyName: policyName})
+		if err != nil {
+			return nil, err
+		}
+		if desc.InlinePolicies[*policyName], err = url.QueryUnescape(aws.StringValue(policy.PolicyDocument)); err != nil {
+			return nil, err
+		}
+	}
+
+	return desc, nil
+}
+
+// DescribeIAMRole returns the state of the IAM role with the given name
+func DescribeIAMRole(ctx context.Context, name string, accountID string, region string) (*IAMRoleDescription, error) {
+	release, err := acquireDescribeSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	svc, err := iamService(name, accountID, region)
+	if err != nil {
+		return nil, err
+	}
+	roleName := aws.String(name)
+	desc := &IAMRoleDescription{InlinePolicies: map[string]string{}}
+
+	role, err := svc.GetRoleWithContext(ctx, &iam.GetRoleInput{RoleName: roleName})
+	if err != nil {
+		return nil, err
+	}
+	desc.Role = role.Role
+	trustPolicy, err := url.QueryUnescape(aws.StringValue(desc.Role.AssumeRolePolicyDocument))
+	if err != nil {
+		return nil, err
+	}
+	desc.Role.AssumeRolePolicyDocument = aws.String(trustPolicy)
+	attached, err := svc.ListAttachedRolePoliciesWithContext(ctx, &iam.ListAttachedRolePoliciesInput{RoleName: roleName})
+	if err != nil {
+		return nil, err
+	}
+	desc.AttachedPolicies = attached.AttachedPolicies
+	if desc.AttachedPolicyDocuments, err = attachedPolicyDocuments(ctx, svc, desc.AttachedPolicies); err != nil {
+		return nil, err
+	}
+	inline, err := svc.ListRolePoliciesWithContext(ctx, &iam.ListRolePoliciesInput{RoleName: roleName})
+	if err != nil {
+		return nil, err
+	}
+	for _, policyName := range inline.PolicyNames {
+		policy, err := svc.GetRolePolicyWithContext(ctx, &iam.GetRolePolicyInput{RoleName: roleName, PolicyName: policyName})
+		if err != nil {
+			return nil, err
+		}
+		if desc.InlinePolicies[*policyName], err = url.QueryUnescape(aws.StringValue(policy.PolicyDocument)); err != nil {
+			return nil, err
+		}
+	}
+
+	return desc, nil
+}
+
+// attachedPolicyDocuments returns the documents of the default versions of the managed policies by name
+func attachedPolicyDocuments(ctx context.Context, svc *iam.IAM, attached []*iam.AttachedPolicy) (map[string]string, error) {
+	documents := map[string]string{}
+	for _, policy := range attached {
+		p, err := svc.GetPolicyWithContext(ctx, &iam.GetPolicyInput{PolicyArn: policy.PolicyArn})
+		if err != nil {
+			return nil, err
+		}
+		version, err := svc.GetPolicyVersionWithContext(ctx, &iam.GetPolicyVersionInput{PolicyArn: policy.PolicyArn, VersionId: p.Policy.DefaultVersionId})
+		if err != nil {
+			return nil, err
+		}
+		if documents[aws.StringValue(policy.PolicyName)], err = url.QueryUnescape(aws.StringValue(version.PolicyVersion.Document)); err != nil {
+			return nil, err
+		}
+	}
+	return documents, nil
+}
+
+func iamService(name string, accountID string, region string) (*iam.IAM, error) {
+	cfg := GetAWSConfig(accountID, region)
+	if cfg == nil {
+		return nil, errors.New("Can't describe IAM resource: " + name)
+	}
+	sess := session.Must(session.NewSession())
+	return iam.New(sess, cfg), nil
+}
@@ -0,0 +1,57 @@
+package util
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	describeMu    sync.RWMutex
+	describeSlots chan struct{} // nil: no limit
+)
+
+// SetMaxDescribeCalls limits the number of AWS describe calls in flight at the same time,
+// over all the accounts. A value <= 0 removes the limit.
+func SetMaxDescribeCalls(n int) {
+	describeMu.Lock()
+	defer describeMu.Unlock()
+	if n <= 0 {
+		describeSlots = nil
+		return
+	}
+	describeSlots = make(chan struct{}, n)
+}
+
+// acquireDescribeSlot blocks until a describe call can be issued (or ctx is done) and returns the
+// function releasing the slot
+func acquireDescribeSlot(ctx context.Context) (func(), error) {
+	describeMu.RLock()
+	slots := describeSlots
+	describeMu.RUnlock()
+
+	if slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
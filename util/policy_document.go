@@ -0,0 +1,103 @@
+package util
+
+/*  This file is part of AreBOT.
+
+    AreBOT is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    AreBOT is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with AreBOT.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// PolicyStatement is a statement of an IAM policy document (an identity, bucket or trust policy)
+type PolicyStatement struct {
+	Effect    string
+	Actions   []string
+	Resources []string
+	// "*" for everyone, the ARNs or account IDs of the AWS principals, and "<type>:<name>" for the
+	// other types, e.g. "Service:ec2.amazonaws.com" or "Federated:cognito-identity.amazonaws.com"
+	Principals []string
+}
+
+// ParsePolicyDocument returns the statements of the JSON policy document. The elements that can be
+// a string or a list, e.g. Action, are always returned as a list.
+func ParsePolicyDocument(document string) ([]PolicyStatement, error) {
+	if document == "" {
+		return nil, nil
+	}
+	var doc struct {
+		Statement json.RawMessage
+	}
+	if err := json.Unmarshal([]byte(document), &doc); err != nil {
+		return nil, err
+	}
+	type statement struct {
+		Effect    string
+		Action    interface{}
+		Resource  interface{}
+		Principal interface{}
+	}
+	// a single statement may not be in a list
+	var statements []statement
+	if err := json.Unmarshal(doc.Statement, &statements); err != nil {
+		var single statement
+		if err := json.Unmarshal(doc.Statement, &single); err != nil {
+			return nil, err
+		}
+		statements = []statement{single}
+	}
+
+	var result []PolicyStatement
+	for _, s := range statements {
+		ps := PolicyStatement{Effect: s.Effect, Actions: stringList(s.Action), Resources: stringList(s.Resource)}
+		switch p := s.Principal.(type) {
+		case string:
+			ps.Principals = []string{p}
+		case map[string]interface{}:
+			var kinds []string
+			for kind := range p {
+				kinds = append(kinds, kind)
+			}
+			sort.Strings(kinds)
+			for _, kind := range kinds {
+				for _, value := range stringList(p[kind]) {
+					if kind != "AWS" {
+						value = kind + ":" + value
+					}
+					ps.Principals = append(ps.Principals, value)
+				}
+			}
+		}
+		result = append(result, ps)
+	}
+	return result, nil
+}
+
+// stringList returns the string or the strings of the list of a JSON value
+func stringList(v interface{}) []string {
+	switch values := v.(type) {
+	case string:
+		return []string{values}
+	case []interface{}:
+		var result []string
+		for _, value := range values {
+			if s, ok := value.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}